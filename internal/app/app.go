@@ -1,5 +1,7 @@
 package app
 import (
+	"errors"
+	"fmt"
 	"lazytea-mobile/internal/config"
 	"lazytea-mobile/internal/data"
 	"lazytea-mobile/internal/mobile"
@@ -7,19 +9,26 @@ import (
 	"lazytea-mobile/internal/ui/pages"
 	"lazytea-mobile/internal/utils"
 	"log"
+	"time"
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/layout"
 	fyneTheme "fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
 )
 type App struct {
 	fyneApp fyne.App
 	window  fyne.Window
+	clients *network.Manager
 	client  *network.Client
 	storage *data.Storage
+	storageErr error
+	pruner     *data.Pruner
 	tabs    *container.AppTabs
 	logger  *utils.Logger
 	config  *config.Config
+	profile *data.ConnectionProfile
 	mobileLifecycle   *mobile.MobileLifecycle
 	permissionManager *mobile.PermissionManager
 	networkManager    *mobile.MobileNetworkManager
@@ -27,6 +36,7 @@ type App struct {
 	botInfoPage  *pages.BotInfoPage
 	messagePage  *pages.MessagePage
 	pluginPage   *pages.PluginPage
+	statsPage    *pages.PluginStatsPage
 	settingsPage *pages.SettingsPage
 }
 func NewApp(fyneApp fyne.App) *App {
@@ -83,17 +93,57 @@ func NewApp(fyneApp fyne.App) *App {
 	app.storage, err = data.NewStorage(dbPath)
 	if err != nil {
 		app.logger.Error("Failed to initialize storage: %v", err)
+		app.storageErr = err
+	} else {
+		for _, backup := range app.storage.Backups() {
+			app.logger.Info("数据库升级前已备份到 %s", backup)
+		}
 	}
-	app.client = network.NewClient(app.logger)
+	app.clients = network.NewManager(app.logger)
+	app.clients.SetHeartbeatConfig(network.HeartbeatConfig{
+		Interval:  time.Duration(app.config.Network.HeartbeatInterval) * time.Second,
+		MaxMisses: app.config.Network.HeartbeatMaxMisses,
+	})
+	if app.storage != nil {
+		app.clients.SetOutbox(network.NewOutbox(app.storage, app.logger))
+		app.clients.SetHistorySync(network.NewHistorySync(app.storage, app.logger))
+		app.pruner = data.NewPruner(app.storage, app.config.Retention.Policy())
+		app.pruner.OnResult(func(result data.PruneResult, err error) {
+			if err != nil {
+				app.logger.Error("清理过期数据失败: %v", err)
+				return
+			}
+			if result.Messages > 0 || result.PluginCalls > 0 {
+				app.logger.Info("已清理 %d 条消息和 %d 条插件调用记录，释放 %d 字节", result.Messages, result.PluginCalls, result.Reclaimed)
+			}
+		})
+	}
+	app.client = app.clients.Primary()
 	return app
 }
 func (a *App) Run() {
 	a.setupWindow()
 	a.setupPages()
 	a.setupLayout()
+	a.setupOutboxNotifications()
+	a.showStorageError()
 	a.tryAutoConnect()
+	if a.pruner != nil {
+		a.pruner.Start(time.Hour)
+		defer a.pruner.Stop()
+	}
 	a.window.ShowAndRun()
 }
+func (a *App) showStorageError() {
+	if a.storageErr == nil {
+		return
+	}
+	if errors.Is(a.storageErr, data.ErrSchemaTooNew) {
+		dialog.ShowError(fmt.Errorf("数据库由更新版本的 LazyTea 创建，请升级应用后再打开"), a.window)
+		return
+	}
+	dialog.ShowError(fmt.Errorf("无法打开本地数据库: %v", a.storageErr), a.window)
+}
 func (a *App) setupWindow() {
 	a.window = a.fyneApp.NewWindow("LazyTea Mobile")
 	a.window.SetIcon(fyne.NewStaticResource("icon", []byte{}))  
@@ -106,11 +156,14 @@ func (a *App) setupWindow() {
 	a.window.SetContent(widget.NewLabel("Loading..."))
 }
 func (a *App) setupPages() {
-	a.overviewPage = pages.NewOverviewPage(a.client, a.storage, a.logger, a.config)
-	a.botInfoPage = pages.NewBotInfoPage(a.client, a.storage, a.logger, a.window)
-	a.messagePage = pages.NewMessagePage(a.client, a.storage, a.logger)
+	a.overviewPage = pages.NewOverviewPage(a.clients, a.storage, a.logger, a.config)
+	a.botInfoPage = pages.NewBotInfoPage(a.clients, a.storage, a.logger, a.window)
+	a.messagePage = pages.NewMessagePage(a.clients, a.storage, a.logger, a.window)
 	a.pluginPage = pages.NewPluginPage(a.client, a.storage, a.logger)
-	a.settingsPage = pages.NewSettingsPage(a.client, a.storage, a.logger, a.window, a.config)
+	a.statsPage = pages.NewPluginStatsPage(a.clients, a.storage, a.logger)
+	a.settingsPage = pages.NewSettingsPage(a.clients, a.storage, a.logger, a.window, a.config)
+	a.settingsPage.SetPruner(a.pruner)
+	a.overviewPage.SetConnector(a.connectCurrentProfile)
 }
 func (a *App) setupLayout() {
 	a.tabs = container.NewAppTabs(
@@ -118,6 +171,7 @@ func (a *App) setupLayout() {
 		container.NewTabItemWithIcon("Bot", fyneTheme.ComputerIcon(), a.botInfoPage.GetContent()),
 		container.NewTabItemWithIcon("消息", fyneTheme.MailComposeIcon(), a.messagePage.GetContent()),
 		container.NewTabItemWithIcon("插件", fyneTheme.FolderIcon(), a.pluginPage.GetContent()),
+		container.NewTabItemWithIcon("统计", fyneTheme.ListIcon(), a.statsPage.GetContent()),
 		container.NewTabItemWithIcon("设置", fyneTheme.SettingsIcon(), a.settingsPage.GetContent()),
 	)
 	a.tabs.SetTabLocation(container.TabLocationBottom)
@@ -136,18 +190,66 @@ func (a *App) createStatusBar() *fyne.Container {
 	statusLabel := widget.NewLabel("未连接")
 	statusLabel.TextStyle = fyne.TextStyle{Bold: true}
 	statusLabel.Importance = widget.DangerImportance
-	a.client.OnConnectionChanged(func(connected bool) {
-		if connected {
-			statusLabel.SetText("✓ 已连接")
-			statusLabel.Importance = widget.SuccessImportance
-		} else {
+	latencyLabel := widget.NewLabel("")
+	latencyLabel.Hide()
+	showLatency := func(rtt time.Duration) {
+		if !a.client.IsConnected() || rtt <= 0 {
+			latencyLabel.Hide()
+			return
+		}
+		latencyLabel.SetText(fmt.Sprintf("%d ms", rtt.Milliseconds()))
+		switch {
+		case rtt < 200*time.Millisecond:
+			latencyLabel.Importance = widget.SuccessImportance
+		case rtt < time.Second:
+			latencyLabel.Importance = widget.WarningImportance
+		default:
+			latencyLabel.Importance = widget.DangerImportance
+		}
+		latencyLabel.Show()
+		latencyLabel.Refresh()
+	}
+	states, _ := a.client.WatchState()
+	render := func() {
+		online, total := a.clients.ConnectionCounts()
+		state := a.client.State()
+		switch {
+		case online == 0 && state.State == network.StateConnecting:
+			statusLabel.SetText("⟳ 连接中...")
+			statusLabel.Importance = widget.WarningImportance
+		case online == 0 && state.State == network.StateBackoff:
+			statusLabel.SetText(fmt.Sprintf("⟳ %s 后重连 (第 %d 次)", state.Delay.Round(time.Second), state.Attempt))
+			statusLabel.Importance = widget.WarningImportance
+		case online == 0 && state.State == network.StateGaveUp:
+			statusLabel.SetText("✗ 重连失败")
+			statusLabel.Importance = widget.DangerImportance
+		case online == 0:
 			statusLabel.SetText("✗ 未连接")
 			statusLabel.Importance = widget.DangerImportance
+		case total > 1:
+			statusLabel.SetText(fmt.Sprintf("✓ 已连接 %d/%d", online, total))
+			statusLabel.Importance = widget.SuccessImportance
+		default:
+			statusLabel.SetText("✓ 已连接")
+			statusLabel.Importance = widget.SuccessImportance
 		}
+		statusLabel.Refresh()
+		showLatency(a.client.Latency())
+	}
+	a.clients.OnConnectionChanged(func(server string, connected bool) {
+		render()
 	})
+	go func() {
+		for range states {
+			render()
+		}
+	}()
+	a.client.OnLatency(showLatency)
 	statusContainer := container.NewHBox(
 		widget.NewIcon(fyneTheme.InfoIcon()),
 		statusLabel,
+		layout.NewSpacer(),
+		latencyLabel,
 	)
 	return container.NewBorder(
 		nil,                                   
@@ -157,17 +259,117 @@ func (a *App) createStatusBar() *fyne.Container {
 		container.NewPadded(statusContainer),  
 	)
 }
+func (a *App) setupOutboxNotifications() {
+	outbox := a.clients.Outbox()
+	if outbox == nil {
+		return
+	}
+	outbox.OnEvent(func(event network.OutboxEvent) {
+		switch event.Kind {
+		case network.OutboxConflict:
+			dialog.ShowInformation("离线请求冲突",
+				fmt.Sprintf("「%s」排队期间服务端状态已变化，未自动发送。\n请在 设置 → 离线队列 中选择仍然发送或丢弃。", event.Item.Summary),
+				a.window)
+		case network.OutboxFailed:
+			dialog.ShowError(fmt.Errorf("离线请求「%s」重放失败: %v", event.Item.Summary, event.Err), a.window)
+		}
+	})
+}
 func (a *App) tryAutoConnect() {
-	if !a.config.Network.AutoConnect || a.config.Network.Host == "" {
+	if a.config.Network.DemoMode {
+		a.logger.Info("演示模式已启用，连接到内置模拟服务端")
+		a.settingsPage.StartDemo()
+		return
+	}
+	if !a.config.Network.AutoConnect {
+		a.logger.Info("自动连接未启用或无连接配置")
+		return
+	}
+	profile := a.resolveAutoConnectProfile()
+	if profile == nil || profile.Host == "" {
 		a.logger.Info("自动连接未启用或无连接配置")
 		return
 	}
-	a.logger.Info("尝试自动连接到 %s:%d", a.config.Network.Host, a.config.Network.Port)
+	a.profile = profile
+	a.logger.Info("尝试自动连接到 %s (%s:%d)", profile.Name, profile.Host, profile.Port)
 	go func() {
-		if err := a.client.Connect(a.config.Network.Host, a.config.Network.Port, a.config.Network.Token); err != nil {
+		if err := a.connectProfile(profile); err != nil {
 			a.logger.Error("自动连接失败: %v", err)
 		}
 	}()
+	a.connectParallelProfiles(profile.Name)
+}
+func (a *App) connectParallelProfiles(primary string) {
+	if a.storage == nil {
+		return
+	}
+	profiles, err := a.storage.ListAutoConnectProfiles()
+	if err != nil {
+		a.logger.Error("Failed to load parallel profiles: %v", err)
+		return
+	}
+	for _, profile := range profiles {
+		if profile.Name == primary || a.clients.Has(profile.Name) {
+			continue
+		}
+		client, err := a.clients.Add(profile.Name)
+		if err != nil {
+			a.logger.Error("Failed to add backend %s: %v", profile.Name, err)
+			continue
+		}
+		a.logger.Info("并行连接到 %s (%s:%d)", profile.Name, profile.Host, profile.Port)
+		go func(profile data.ConnectionProfile) {
+			if err := client.ConnectWithOptions(network.ProfileConnectOptions(profile)); err != nil {
+				a.logger.Error("并行连接 %s 失败: %v", profile.Name, err)
+				return
+			}
+			if err := network.RememberLegacy(a.storage, profile, client); err != nil {
+				a.logger.Warn("Failed to save legacy protocol flag: %v", err)
+			}
+		}(profile)
+	}
+}
+func (a *App) resolveAutoConnectProfile() *data.ConnectionProfile {
+	if a.storage != nil {
+		profile, err := a.storage.ResolveAutoConnectProfile()
+		if err == nil {
+			return profile
+		}
+		a.logger.Warn("未找到可用的连接配置，使用设置中的服务器: %v", err)
+	}
+	return &data.ConnectionProfile{
+		Name:  a.config.Network.Profile,
+		Host:  a.config.Network.Host,
+		Port:  a.config.Network.Port,
+		Token: a.config.Network.Token,
+	}
+}
+func (a *App) connectCurrentProfile() error {
+	profile := a.profile
+	if profile == nil {
+		profile = a.resolveAutoConnectProfile()
+	}
+	if profile.Host == "" || profile.Port == 0 {
+		return fmt.Errorf("连接配置不完整，请在设置页面配置服务器信息")
+	}
+	return a.connectProfile(profile)
+}
+func (a *App) connectProfile(profile *data.ConnectionProfile) error {
+	if err := a.clients.SetPrimaryServer(profile.Name); err != nil {
+		return err
+	}
+	if err := a.client.ConnectWithOptions(network.ProfileConnectOptions(*profile)); err != nil {
+		return err
+	}
+	if a.storage != nil && profile.Name != "" {
+		if err := a.storage.TouchConnectionProfile(profile.Name); err != nil {
+			a.logger.Warn("Failed to update profile usage: %v", err)
+		}
+	}
+	if err := network.RememberLegacy(a.storage, *profile, a.client); err != nil {
+		a.logger.Warn("Failed to save legacy protocol flag: %v", err)
+	}
+	return nil
 }
 func (a *App) setupTabChangeHandlers() {
 	var currentTabIndex int = 0
@@ -194,6 +396,8 @@ func (a *App) callPageEnter(pageIndex int) {
 	case 2:  
 	case 3:  
 		a.pluginPage.OnEnter()
+	case 4:
+		a.statsPage.OnEnter()
 	}
 }
 func (a *App) callPageLeave(pageIndex int) {
@@ -216,37 +420,56 @@ func (a *App) setupMobileNetworking() {
 			a.logger.Info("[Mobile] Network disconnected")
 		case mobile.NetworkStateConnecting:
 			a.logger.Info("[Mobile] Network connecting")
+		case mobile.NetworkStateBackoff:
+			a.logger.Info("[Mobile] Network waiting to reconnect")
+		case mobile.NetworkStateGaveUp:
+			a.logger.Warn("[Mobile] Network reconnection gave up")
 		}
 	})
 	a.networkManager.SetReconnectCallback(func() {
 		a.logger.Info("[Mobile] Attempting network reconnection")
+		if a.client.ReconnectNow() {
+			a.clients.ReconnectAll()
+			return
+		}
 		go func() {
-			if err := a.client.Connect(a.config.Network.Host, a.config.Network.Port, a.config.Network.Token); err != nil {
+			if err := a.connectCurrentProfile(); err != nil {
 				a.logger.Error("[Mobile] Reconnection failed: %v", err)
-				a.networkManager.OnConnectionLost()
-			} else {
-				a.logger.Info("[Mobile] Reconnection successful")
-				a.networkManager.OnConnectionEstablished()
 			}
 		}()
 	})
 	a.mobileLifecycle.SetBackgroundCallback(func() {
 		a.logger.Info("[Mobile] App going to background")
 		a.networkManager.HandleAppBackground()
+		policy := network.DefaultBackoffPolicy()
+		policy.Initial = 30 * time.Second
+		policy.Max = 5 * time.Minute
+		a.clients.SetBackoffPolicy(policy)
 	})
 	a.mobileLifecycle.SetForegroundCallback(func() {
 		a.logger.Info("[Mobile] App coming to foreground")
+		a.clients.SetBackoffPolicy(network.DefaultBackoffPolicy())
 		a.networkManager.HandleAppForeground()
 	})
 	a.mobileLifecycle.SetLowMemoryCallback(func() {
 		a.logger.Warn("[Mobile] Low memory warning")
 	})
-	a.client.OnConnectionChanged(func(connected bool) {
-		if connected {
-			a.networkManager.OnConnectionEstablished()
-		} else {
-			a.networkManager.OnConnectionLost()
+	states, _ := a.client.WatchState()
+	go func() {
+		for change := range states {
+			switch change.State {
+			case network.StateConnected:
+				a.networkManager.OnConnectionEstablished()
+			case network.StateConnecting:
+				a.networkManager.OnConnectionAttempt()
+			case network.StateBackoff:
+				a.networkManager.OnReconnectScheduled()
+			case network.StateGaveUp:
+				a.networkManager.OnReconnectGaveUp()
+			default:
+				a.networkManager.OnConnectionLost()
+			}
 		}
-	})
+	}()
 	a.logger.Info("[Mobile] Mobile networking configured")
 }
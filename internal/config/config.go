@@ -1,23 +1,61 @@
 package config
 import (
 	"encoding/json"
+	"lazytea-mobile/internal/data"
 	"log"
+	"time"
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/storage"
 )
 type Config struct {
 	Database DatabaseConfig `json:"database"`
 	Network  NetworkConfig  `json:"network"`
+	Retention RetentionConfig `json:"retention"`
 }
 type DatabaseConfig struct {
 	Path string `json:"path"`  
 }
 type NetworkConfig struct {
+	Profile      string `json:"profile"`
 	Host         string `json:"host"`
 	Port         int    `json:"port"`
 	Token        string `json:"token"`
 	AutoConnect  bool   `json:"auto_connect"`
 	RememberAuth bool   `json:"remember_auth"`
+	HeartbeatInterval  int `json:"heartbeat_interval"`
+	HeartbeatMaxMisses int `json:"heartbeat_max_misses"`
+	DemoMode           bool `json:"demo_mode"`
+}
+type RetentionConfig struct {
+	MaxAgeDays  int                   `json:"max_age_days"`
+	MaxMessages int                   `json:"max_messages"`
+	MaxSizeMB   int                   `json:"max_size_mb"`
+	Rules       []RetentionRuleConfig `json:"rules"`
+}
+type RetentionRuleConfig struct {
+	Bot         string `json:"bot"`
+	Group       string `json:"group"`
+	MaxAgeDays  int    `json:"max_age_days"`
+	MaxMessages int    `json:"max_messages"`
+}
+func (c RetentionConfig) Policy() data.RetentionPolicy {
+	policy := data.RetentionPolicy{
+		MaxAge:   time.Duration(c.MaxAgeDays) * 24 * time.Hour,
+		MaxRows:  c.MaxMessages,
+		MaxBytes: int64(c.MaxSizeMB) << 20,
+	}
+	for _, rule := range c.Rules {
+		if rule.Bot == "" && rule.Group == "" {
+			continue
+		}
+		policy.Rules = append(policy.Rules, data.RetentionRule{
+			Bot:     rule.Bot,
+			GroupID: rule.Group,
+			MaxAge:  time.Duration(rule.MaxAgeDays) * 24 * time.Hour,
+			MaxRows: rule.MaxMessages,
+		})
+	}
+	return policy
 }
 var (
 	globalConfig *Config
@@ -100,6 +138,13 @@ func GetDatabaseURI() (fyne.URI, error) {
 	rootURI := appInstance.Storage().RootURI()
 	return storage.Child(rootURI, "data.db")
 }
+func GetRecordingURI(name string) (fyne.URI, error) {
+	if err := checkAppInstance(); err != nil {
+		return nil, err
+	}
+	rootURI := appInstance.Storage().RootURI()
+	return storage.Child(rootURI, name)
+}
 func getDefaultConfig() *Config {
 	return &Config{
 		Database: DatabaseConfig{
@@ -113,11 +158,14 @@ func getDefaultConfig() *Config {
 			}(),
 		},
 		Network: NetworkConfig{
+			Profile:      "default",
 			Host:         "127.0.0.1",
 			Port:         8080,
 			Token:        "疯狂星期四V我50",
 			AutoConnect:  false,
 			RememberAuth: true,
+			HeartbeatInterval:  30,
+			HeartbeatMaxMisses: 3,
 		},
 	}
 }
@@ -0,0 +1,179 @@
+package data
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+const defaultMessagePageSize = 50
+
+type Conversation struct {
+	Server  string
+	Bot     string
+	GroupID string
+	UserID  string
+}
+
+func ConversationOf(msg Message) Conversation {
+	conversation := Conversation{Server: msg.Server, Bot: msg.Bot}
+	if conversation.Bot == "" {
+		conversation.Bot = msg.BotID
+	}
+	if msg.GroupID != nil && *msg.GroupID != "" {
+		conversation.GroupID = *msg.GroupID
+		return conversation
+	}
+	conversation.UserID = msg.User
+	if conversation.UserID == "" {
+		conversation.UserID = msg.UserID
+	}
+	return conversation
+}
+
+func (c Conversation) IsGroup() bool {
+	return c.GroupID != ""
+}
+
+func (c Conversation) Contains(msg Message) bool {
+	return ConversationOf(msg) == c
+}
+
+func (c Conversation) where() (string, []interface{}) {
+	if c.IsGroup() {
+		return "COALESCE(server, '') = ? AND bot = ? AND group_id = ?", []interface{}{c.Server, c.Bot, c.GroupID}
+	}
+	return "COALESCE(server, '') = ? AND bot = ? AND COALESCE(group_id, '') = '' AND user = ?", []interface{}{c.Server, c.Bot, c.UserID}
+}
+
+type ConversationSummary struct {
+	Conversation
+	Count          int
+	LastTimestamps int64
+}
+
+type MessageCursor struct {
+	Timestamps int64
+	ID         int64
+}
+
+func CursorOf(msg Message) MessageCursor {
+	return MessageCursor{Timestamps: msg.Timestamps, ID: msg.ID}
+}
+
+type MessageQuery struct {
+	Conversation *Conversation
+	Before       *MessageCursor
+	After        *MessageCursor
+	Limit        int
+}
+
+type MessagePage struct {
+	Messages []Message
+	HasMore  bool
+}
+
+func (p MessagePage) Oldest() (MessageCursor, bool) {
+	if len(p.Messages) == 0 {
+		return MessageCursor{}, false
+	}
+	return CursorOf(p.Messages[0]), true
+}
+
+func (p MessagePage) Newest() (MessageCursor, bool) {
+	if len(p.Messages) == 0 {
+		return MessageCursor{}, false
+	}
+	return CursorOf(p.Messages[len(p.Messages)-1]), true
+}
+
+func (s *Storage) QueryMessages(query MessageQuery) (MessagePage, error) {
+	if query.Before != nil && query.After != nil {
+		return MessagePage{}, fmt.Errorf("message query cannot page in both directions")
+	}
+	limit := query.Limit
+	if limit <= 0 {
+		limit = defaultMessagePageSize
+	}
+	clauses := []string{"1 = 1"}
+	var args []interface{}
+	if query.Conversation != nil {
+		clause, conversationArgs := query.Conversation.where()
+		clauses = append(clauses, clause)
+		args = append(args, conversationArgs...)
+	}
+	order := "DESC"
+	switch {
+	case query.Before != nil:
+		clauses = append(clauses, "(timestamps < ? OR (timestamps = ? AND id < ?))")
+		args = append(args, query.Before.Timestamps, query.Before.Timestamps, query.Before.ID)
+	case query.After != nil:
+		clauses = append(clauses, "(timestamps > ? OR (timestamps = ? AND id > ?))")
+		args = append(args, query.After.Timestamps, query.After.Timestamps, query.After.ID)
+		order = "ASC"
+	}
+	statement := fmt.Sprintf(`SELECT id, COALESCE(user, ''), COALESCE(group_id, ''), bot,
+        timestamps, content, COALESCE(meta, ''), COALESCE(plaintext, content), COALESCE(server, ''), COALESCE(from_bot, FALSE)
+        FROM Message WHERE %s ORDER BY timestamps %s, id %s LIMIT ?`, strings.Join(clauses, " AND "), order, order)
+	args = append(args, limit+1)
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	rows, err := s.db.Query(statement, args...)
+	if err != nil {
+		return MessagePage{}, fmt.Errorf("failed to query messages: %w", err)
+	}
+	defer rows.Close()
+	messages, err := s.scanMessageRows(rows)
+	if err != nil {
+		return MessagePage{}, err
+	}
+	page := MessagePage{Messages: messages}
+	if len(page.Messages) > limit {
+		page.Messages = page.Messages[:limit]
+		page.HasMore = true
+	}
+	if order == "DESC" {
+		for i, j := 0, len(page.Messages)-1; i < j; i, j = i+1, j-1 {
+			page.Messages[i], page.Messages[j] = page.Messages[j], page.Messages[i]
+		}
+	}
+	return page, nil
+}
+
+func (s *Storage) ListConversations(limit int) ([]ConversationSummary, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	rows, err := s.db.Query(`SELECT COALESCE(server, '') AS srv, bot, COALESCE(group_id, '') AS gid,
+        CASE WHEN COALESCE(group_id, '') = '' THEN COALESCE(user, '') ELSE '' END AS peer,
+        COUNT(*), MAX(timestamps) AS last
+        FROM Message GROUP BY srv, bot, gid, peer ORDER BY last DESC LIMIT ?`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query conversations: %w", err)
+	}
+	defer rows.Close()
+	var summaries []ConversationSummary
+	for rows.Next() {
+		var summary ConversationSummary
+		var bot sql.NullString
+		if err := rows.Scan(&summary.Server, &bot, &summary.GroupID, &summary.UserID, &summary.Count, &summary.LastTimestamps); err != nil {
+			return nil, fmt.Errorf("failed to scan conversation: %w", err)
+		}
+		summary.Bot = bot.String
+		summaries = append(summaries, summary)
+	}
+	return summaries, rows.Err()
+}
+
+func (s *Storage) migrateConversationIndexes(tx *sql.Tx) error {
+	queries := []string{
+		`CREATE INDEX IF NOT EXISTS idx_message_time ON Message (timestamps, id)`,
+		`CREATE INDEX IF NOT EXISTS idx_message_group ON Message (bot, group_id, timestamps, id)`,
+		`CREATE INDEX IF NOT EXISTS idx_message_private ON Message (bot, user, timestamps, id)`,
+	}
+	for _, query := range queries {
+		if _, err := tx.Exec(query); err != nil {
+			return fmt.Errorf("failed to create conversation index: %w", err)
+		}
+	}
+	return nil
+}
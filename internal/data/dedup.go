@@ -0,0 +1,141 @@
+package data
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type messageRow struct {
+	key        string
+	user       string
+	groupID    *string
+	bot        string
+	timestamps int64
+	content    string
+	meta       *string
+	plaintext  string
+	server     string
+	fromBot    bool
+}
+
+func newMessageRow(msg Message) messageRow {
+	row := messageRow{
+		user:       msg.User,
+		groupID:    msg.GroupID,
+		bot:        msg.Bot,
+		timestamps: msg.Timestamps,
+		content:    msg.Content,
+		meta:       msg.Meta,
+		plaintext:  msg.Plaintext,
+		server:     msg.Server,
+		fromBot:    msg.FromBot,
+	}
+	if row.timestamps == 0 {
+		row.timestamps = time.Now().UnixMilli()
+	}
+	if row.bot == "" {
+		row.bot = msg.BotID
+	}
+	if row.user == "" {
+		row.user = msg.UserID
+	}
+	if row.plaintext == "" {
+		row.plaintext = msg.Content
+	}
+	row.key = msg.Key
+	if row.key == "" && msg.RemoteID != "" {
+		row.key = RemoteMessageKey(msg.Server, msg.RemoteID)
+	}
+	if row.key == "" {
+		groupID := ""
+		if msg.GroupID != nil {
+			groupID = *msg.GroupID
+		}
+		// Key over the event time: a message without one is stored with the
+		// wall clock, which would give each redelivery a new key.
+		row.key = ContentMessageKey(row.server, row.bot, row.user, groupID, msg.Timestamps, row.plaintext)
+	}
+	return row
+}
+
+func RemoteMessageKey(server, remoteID string) string {
+	return hashKey("id", server, remoteID)
+}
+
+func ContentMessageKey(server, bot, user, groupID string, timestamps int64, plaintext string) string {
+	return hashKey("content", server, bot, user, groupID, fmt.Sprint(timestamps), plaintext)
+}
+
+func hashKey(parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+const upsertMessageQuery = `INSERT INTO Message
+        (user, group_id, bot, timestamps, content, meta, plaintext, server, message_key, from_bot)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT(message_key) DO UPDATE SET
+            content = excluded.content,
+            meta = COALESCE(excluded.meta, meta),
+            plaintext = excluded.plaintext`
+
+const insertMessageQuery = `INSERT INTO Message
+        (user, group_id, bot, timestamps, content, meta, plaintext, server, message_key, from_bot)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT(message_key) DO NOTHING`
+
+func (r messageRow) args() []interface{} {
+	return []interface{}{r.user, r.groupID, r.bot, r.timestamps, r.content, r.meta, r.plaintext, r.server, r.key, r.fromBot}
+}
+
+func (s *Storage) migrateMessageKeys(tx *sql.Tx) error {
+	if err := ensureColumn(tx, "Message", "message_key", "TEXT"); err != nil {
+		return err
+	}
+	rows, err := tx.Query(`SELECT id, COALESCE(server, ''), COALESCE(bot, ''), COALESCE(user, ''),
+        COALESCE(group_id, ''), COALESCE(timestamps, 0), COALESCE(NULLIF(plaintext, ''), content, '')
+        FROM Message WHERE message_key IS NULL OR message_key = ''`)
+	if err != nil {
+		return fmt.Errorf("failed to query unkeyed messages: %w", err)
+	}
+	keys := make(map[int64]string)
+	for rows.Next() {
+		var (
+			id                               int64
+			server, bot, user, groupID, text string
+			timestamps                       int64
+		)
+		if err := rows.Scan(&id, &server, &bot, &user, &groupID, &timestamps, &text); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan message: %w", err)
+		}
+		keys[id] = ContentMessageKey(server, bot, user, groupID, timestamps, text)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return fmt.Errorf("failed to read unkeyed messages: %w", err)
+	}
+	rows.Close()
+	update, err := tx.Prepare(`UPDATE Message SET message_key = ? WHERE id = ?`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare key update: %w", err)
+	}
+	defer update.Close()
+	for id, key := range keys {
+		if _, err := update.Exec(key, id); err != nil {
+			return fmt.Errorf("failed to set message key: %w", err)
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM Message WHERE id NOT IN
+        (SELECT MIN(id) FROM Message GROUP BY message_key)`); err != nil {
+		return fmt.Errorf("failed to collapse duplicate messages: %w", err)
+	}
+	if _, err := tx.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_message_key ON Message (message_key)`); err != nil {
+		return fmt.Errorf("failed to create message key index: %w", err)
+	}
+	return nil
+}
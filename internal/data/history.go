@@ -0,0 +1,98 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"lazytea-mobile/internal/protocol"
+)
+
+func MessageFromEvent(server string, event *protocol.Message) (Message, error) {
+	botID := string(event.Bot)
+	metadata := map[string]interface{}{
+		"bot":        []interface{}{botID, "color: {bot_color}; font-weight: bold;"},
+		"time":       []interface{}{event.Time, "color: #757575; font-size: 12px;"},
+		"session":    []interface{}{fmt.Sprintf("会话：%s", event.Session), "color: #616161; font-style: italic;"},
+		"avatar":     []interface{}{event.Avatar, 0},
+		"timestamps": []interface{}{event.Time, "hidden"},
+	}
+	metaBytes, err := json.Marshal(metadata)
+	if err != nil {
+		return Message{}, fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+	metaStr := string(metaBytes)
+	plaintext := event.Content.Plaintext()
+	msg := Message{
+		Bot:       botID,
+		BotID:     botID,
+		Content:   plaintext,
+		FromBot:   event.FromBot,
+		Timestamp: time.Now(),
+		User:      string(event.UserID),
+		UserID:    string(event.UserID),
+		UserName:  event.UserName,
+		Plaintext: plaintext,
+		Meta:      &metaStr,
+		Server:    server,
+		RemoteID:  string(event.MessageID),
+	}
+	if groupID := string(event.GroupID); groupID != "" {
+		msg.GroupID = &groupID
+		if groupName := event.GroupName; groupName != "" {
+			msg.GroupName = &groupName
+		}
+	}
+	if !event.Time.IsZero() {
+		msg.Timestamps = event.Time.UnixMilli()
+	}
+	return msg, nil
+}
+
+func (s *Storage) LatestMessageTimestamp(server string) (int64, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	var latest int64
+	err := s.db.QueryRow(`SELECT COALESCE(MAX(timestamps), 0) FROM Message WHERE server = ?`, server).Scan(&latest)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query latest message: %w", err)
+	}
+	return latest, nil
+}
+
+func (s *Storage) ImportMessages(messages []Message) (int, error) {
+	if len(messages) == 0 {
+		return 0, nil
+	}
+	sorted := append([]Message(nil), messages...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Timestamps < sorted[j].Timestamps
+	})
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	insert, err := tx.Prepare(insertMessageQuery)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prepare insert: %w", err)
+	}
+	defer insert.Close()
+	imported := 0
+	for _, msg := range sorted {
+		result, err := insert.Exec(newMessageRow(msg).args()...)
+		if err != nil {
+			return 0, fmt.Errorf("failed to import message: %w", err)
+		}
+		if affected, err := result.RowsAffected(); err == nil && affected > 0 {
+			imported++
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit history: %w", err)
+	}
+	return imported, nil
+}
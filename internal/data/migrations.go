@@ -0,0 +1,238 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+var ErrSchemaTooNew = errors.New("database was written by a newer version of the app")
+
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+type migration struct {
+	name  string
+	risky bool
+	up    func(s *Storage, tx *sql.Tx) error
+	post  func(s *Storage) error
+}
+
+var migrations = []migration{
+	{name: "base tables", up: (*Storage).migrateBaseTables},
+	{name: "server and profile columns", up: (*Storage).migrateServerColumns},
+	{name: "message history index", up: (*Storage).migrateHistoryIndex},
+	{name: "message keys", risky: true, up: (*Storage).migrateMessageKeys},
+	{name: "incremental auto vacuum", risky: true, post: (*Storage).enableIncrementalVacuum},
+	{name: "conversation indexes", up: (*Storage).migrateConversationIndexes},
+	{name: "message sender flag", up: (*Storage).migrateSenderFlag},
+	{name: "tokenized search index", up: (*Storage).migrateTokenizedSearch},
+	{name: "profile legacy flag", up: (*Storage).migrateProfileLegacy},
+}
+
+func SchemaVersion() int {
+	return len(migrations)
+}
+
+func (s *Storage) UserVersion() (int, error) {
+	return userVersion(s.db)
+}
+
+func (s *Storage) Backups() []string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return append([]string(nil), s.backups...)
+}
+
+func userVersion(db execer) (int, error) {
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return version, nil
+}
+
+func (s *Storage) migrate() error {
+	current, err := userVersion(s.db)
+	if err != nil {
+		return err
+	}
+	if current > len(migrations) {
+		return fmt.Errorf("%w: schema version %d, supported up to %d", ErrSchemaTooNew, current, len(migrations))
+	}
+	if current == len(migrations) {
+		return nil
+	}
+	var tables int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table'`).Scan(&tables); err != nil {
+		return fmt.Errorf("failed to inspect database: %w", err)
+	}
+	if tables == 0 {
+		if _, err := s.db.Exec(`PRAGMA auto_vacuum = INCREMENTAL`); err != nil {
+			return fmt.Errorf("failed to enable incremental vacuum: %w", err)
+		}
+	}
+	backedUp := false
+	for i := current; i < len(migrations); i++ {
+		m := migrations[i]
+		version := i + 1
+		if m.risky && tables > 0 && !backedUp {
+			if err := s.backup(current); err != nil {
+				return err
+			}
+			backedUp = true
+		}
+		if err := s.applyMigration(version, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Storage) applyMigration(version int, m migration) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin migration %d: %w", version, err)
+	}
+	defer tx.Rollback()
+	if m.up != nil {
+		if err := m.up(s, tx); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", version, m.name, err)
+		}
+	}
+	if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version)); err != nil {
+		return fmt.Errorf("failed to record schema version %d: %w", version, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit migration %d: %w", version, err)
+	}
+	if m.post != nil {
+		if err := m.post(s); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", version, m.name, err)
+		}
+	}
+	return nil
+}
+
+func (s *Storage) backup(version int) error {
+	if s.path == "" || s.path == ":memory:" {
+		return nil
+	}
+	target := fmt.Sprintf("%s.v%d-%s.bak", s.path, version, time.Now().Format("20060102-150405"))
+	if _, err := os.Stat(target); err == nil {
+		return fmt.Errorf("backup %s already exists", target)
+	}
+	if _, err := s.db.Exec(`VACUUM INTO ?`, target); err != nil {
+		return fmt.Errorf("failed to back up database before migration: %w", err)
+	}
+	s.backups = append(s.backups, target)
+	return nil
+}
+
+func (s *Storage) migrateBaseTables(tx *sql.Tx) error {
+	queries := []string{
+		`CREATE TABLE IF NOT EXISTS connection_config (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            host TEXT NOT NULL,
+            port INTEGER NOT NULL,
+            token TEXT NOT NULL,
+            remember BOOLEAN DEFAULT FALSE,
+            updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+        )`,
+		`CREATE TABLE IF NOT EXISTS Message (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            user TEXT,
+            group_id TEXT,
+            bot TEXT,
+            timestamps INTEGER,
+            content TEXT,
+            meta TEXT,
+            plaintext TEXT
+        )`,
+		`CREATE TABLE IF NOT EXISTS plugin_call_record (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            bot TEXT,
+            platform TEXT,
+            time_costed REAL,
+            group_id TEXT,
+            user_id TEXT,
+            plugin_name TEXT,
+            matcher_hash TEXT,
+            exception_name TEXT,
+            exception_detail TEXT,
+            timestamp INTEGER
+        )`,
+		`CREATE INDEX IF NOT EXISTS idx_bot_platform ON plugin_call_record (bot, platform)`,
+		`CREATE INDEX IF NOT EXISTS idx_timestamp ON plugin_call_record (timestamp)`,
+	}
+	for _, query := range queries {
+		if _, err := tx.Exec(query); err != nil {
+			return fmt.Errorf("failed to create table: %w", err)
+		}
+	}
+	if err := initProfileTable(tx); err != nil {
+		return err
+	}
+	return initOutboxTable(tx)
+}
+
+func (s *Storage) migrateServerColumns(tx *sql.Tx) error {
+	columns := []struct {
+		table      string
+		column     string
+		definition string
+	}{
+		{"Message", "server", "TEXT DEFAULT ''"},
+		{"plugin_call_record", "server", "TEXT DEFAULT ''"},
+		{"connection_profile", "auto_connect", "BOOLEAN DEFAULT FALSE"},
+		{"connection_profile", "ca_bundle", "TEXT DEFAULT ''"},
+		{"connection_profile", "pin_sha256", "TEXT DEFAULT ''"},
+		{"connection_profile", "allow_self_signed", "BOOLEAN DEFAULT FALSE"},
+		{"connection_profile", "auth_mode", "TEXT DEFAULT ''"},
+		{"connection_profile", "compression", "BOOLEAN DEFAULT FALSE"},
+		{"connection_profile", "encoding", "TEXT DEFAULT ''"},
+	}
+	for _, c := range columns {
+		if err := ensureColumn(tx, c.table, c.column, c.definition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Storage) migrateHistoryIndex(tx *sql.Tx) error {
+	if _, err := tx.Exec(`CREATE INDEX IF NOT EXISTS idx_message_server_time ON Message (server, timestamps)`); err != nil {
+		return fmt.Errorf("failed to create message index: %w", err)
+	}
+	return nil
+}
+
+func (s *Storage) migrateSenderFlag(tx *sql.Tx) error {
+	return ensureColumn(tx, "Message", "from_bot", "BOOLEAN DEFAULT FALSE")
+}
+
+func (s *Storage) migrateProfileLegacy(tx *sql.Tx) error {
+	return ensureColumn(tx, "connection_profile", "legacy_server", "BOOLEAN DEFAULT FALSE")
+}
+
+func (s *Storage) enableIncrementalVacuum() error {
+	var mode int
+	if err := s.db.QueryRow(`PRAGMA auto_vacuum`).Scan(&mode); err != nil {
+		return fmt.Errorf("failed to read auto_vacuum mode: %w", err)
+	}
+	if mode == 2 {
+		return nil
+	}
+	if _, err := s.db.Exec(`PRAGMA auto_vacuum = INCREMENTAL`); err != nil {
+		return fmt.Errorf("failed to enable incremental vacuum: %w", err)
+	}
+	if _, err := s.db.Exec(`VACUUM`); err != nil {
+		return fmt.Errorf("failed to rebuild database: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package data
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+type OutboxStatus string
+
+const (
+	OutboxPending  OutboxStatus = "pending"
+	OutboxConflict OutboxStatus = "conflict"
+	OutboxFailed   OutboxStatus = "failed"
+)
+
+type OutboxItem struct {
+	ID               int64                  `json:"id"`
+	Server           string                 `json:"server"`
+	Method           string                 `json:"method"`
+	Params           map[string]interface{} `json:"params"`
+	Summary          string                 `json:"summary"`
+	GuardMethod      string                 `json:"guard_method"`
+	GuardParams      map[string]interface{} `json:"guard_params"`
+	GuardFingerprint string                 `json:"guard_fingerprint"`
+	Position         int64                  `json:"position"`
+	Status           OutboxStatus           `json:"status"`
+	LastError        string                 `json:"last_error"`
+	CreatedAt        time.Time              `json:"created_at"`
+}
+
+func initOutboxTable(db execer) error {
+	queries := []string{
+		`CREATE TABLE IF NOT EXISTS request_outbox (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            server TEXT NOT NULL DEFAULT '',
+            method TEXT NOT NULL,
+            params TEXT NOT NULL DEFAULT '{}',
+            summary TEXT DEFAULT '',
+            guard_method TEXT DEFAULT '',
+            guard_params TEXT DEFAULT '{}',
+            guard_fingerprint TEXT DEFAULT '',
+            position INTEGER NOT NULL DEFAULT 0,
+            status TEXT NOT NULL DEFAULT 'pending',
+            last_error TEXT DEFAULT '',
+            created_at INTEGER DEFAULT 0
+        )`,
+		`CREATE INDEX IF NOT EXISTS idx_outbox_server_position ON request_outbox (server, position)`,
+	}
+	for _, query := range queries {
+		if _, err := db.Exec(query); err != nil {
+			return fmt.Errorf("failed to create request_outbox table: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *Storage) EnqueueOutboxItem(item OutboxItem) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	params, err := json.Marshal(item.Params)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode outbox params: %w", err)
+	}
+	guardParams, err := json.Marshal(item.GuardParams)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode outbox guard params: %w", err)
+	}
+	if item.Status == "" {
+		item.Status = OutboxPending
+	}
+	if item.CreatedAt.IsZero() {
+		item.CreatedAt = time.Now()
+	}
+	query := `INSERT INTO request_outbox (server, method, params, summary, guard_method, guard_params,
+            guard_fingerprint, position, status, last_error, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?,
+            (SELECT COALESCE(MAX(position), 0) + 1 FROM request_outbox WHERE server = ?),
+            ?, ?, ?)`
+	result, err := s.db.Exec(query, item.Server, item.Method, string(params), item.Summary, item.GuardMethod,
+		string(guardParams), item.GuardFingerprint, item.Server, string(item.Status), item.LastError,
+		item.CreatedAt.UnixMilli())
+	if err != nil {
+		return 0, fmt.Errorf("failed to enqueue request: %w", err)
+	}
+	return result.LastInsertId()
+}
+
+func (s *Storage) ListOutboxItems(server string) ([]OutboxItem, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	query := `SELECT id, server, method, params, COALESCE(summary, ''), COALESCE(guard_method, ''),
+            COALESCE(guard_params, '{}'), COALESCE(guard_fingerprint, ''), position, status,
+            COALESCE(last_error, ''), COALESCE(created_at, 0)
+        FROM request_outbox WHERE server = ? ORDER BY position ASC, id ASC`
+	rows, err := s.db.Query(query, server)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query outbox: %w", err)
+	}
+	defer rows.Close()
+	return scanOutboxRows(rows)
+}
+
+func (s *Storage) ListAllOutboxItems() ([]OutboxItem, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	query := `SELECT id, server, method, params, COALESCE(summary, ''), COALESCE(guard_method, ''),
+            COALESCE(guard_params, '{}'), COALESCE(guard_fingerprint, ''), position, status,
+            COALESCE(last_error, ''), COALESCE(created_at, 0)
+        FROM request_outbox ORDER BY server ASC, position ASC, id ASC`
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query outbox: %w", err)
+	}
+	defer rows.Close()
+	return scanOutboxRows(rows)
+}
+
+func (s *Storage) CountPendingOutboxItems(server string) (int, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	var count int
+	query := `SELECT COUNT(*) FROM request_outbox WHERE server = ? AND status = ?`
+	if err := s.db.QueryRow(query, server, string(OutboxPending)).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count outbox: %w", err)
+	}
+	return count, nil
+}
+
+func (s *Storage) UpdateOutboxStatus(id int64, status OutboxStatus, lastError string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	query := `UPDATE request_outbox SET status = ?, last_error = ? WHERE id = ?`
+	if _, err := s.db.Exec(query, string(status), lastError, id); err != nil {
+		return fmt.Errorf("failed to update outbox item: %w", err)
+	}
+	return nil
+}
+
+func (s *Storage) ClearOutboxGuard(id int64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	query := `UPDATE request_outbox SET guard_method = '', guard_params = '{}', guard_fingerprint = '' WHERE id = ?`
+	if _, err := s.db.Exec(query, id); err != nil {
+		return fmt.Errorf("failed to clear outbox guard: %w", err)
+	}
+	return nil
+}
+
+func (s *Storage) DeleteOutboxItem(id int64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, err := s.db.Exec(`DELETE FROM request_outbox WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("failed to delete outbox item: %w", err)
+	}
+	return nil
+}
+
+func (s *Storage) MoveOutboxItem(id int64, delta int) error {
+	if delta == 0 {
+		return nil
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	var server string
+	var position int64
+	if err := tx.QueryRow(`SELECT server, position FROM request_outbox WHERE id = ?`, id).Scan(&server, &position); err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("outbox item %d not found", id)
+		}
+		return fmt.Errorf("failed to load outbox item: %w", err)
+	}
+	neighbour := `SELECT id, position FROM request_outbox WHERE server = ? AND position > ? ORDER BY position ASC LIMIT 1`
+	if delta < 0 {
+		neighbour = `SELECT id, position FROM request_outbox WHERE server = ? AND position < ? ORDER BY position DESC LIMIT 1`
+	}
+	var otherID, otherPosition int64
+	if err := tx.QueryRow(neighbour, server, position).Scan(&otherID, &otherPosition); err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return fmt.Errorf("failed to load neighbouring outbox item: %w", err)
+	}
+	if _, err := tx.Exec(`UPDATE request_outbox SET position = ? WHERE id = ?`, otherPosition, id); err != nil {
+		return fmt.Errorf("failed to reorder outbox: %w", err)
+	}
+	if _, err := tx.Exec(`UPDATE request_outbox SET position = ? WHERE id = ?`, position, otherID); err != nil {
+		return fmt.Errorf("failed to reorder outbox: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+func scanOutboxRows(rows *sql.Rows) ([]OutboxItem, error) {
+	var items []OutboxItem
+	for rows.Next() {
+		var item OutboxItem
+		var params, guardParams, status string
+		var createdAt int64
+		err := rows.Scan(&item.ID, &item.Server, &item.Method, &params, &item.Summary, &item.GuardMethod,
+			&guardParams, &item.GuardFingerprint, &item.Position, &status, &item.LastError, &createdAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan outbox item: %w", err)
+		}
+		if err := json.Unmarshal([]byte(params), &item.Params); err != nil {
+			return nil, fmt.Errorf("failed to decode outbox params: %w", err)
+		}
+		if err := json.Unmarshal([]byte(guardParams), &item.GuardParams); err != nil {
+			return nil, fmt.Errorf("failed to decode outbox guard params: %w", err)
+		}
+		item.Status = OutboxStatus(status)
+		if createdAt > 0 {
+			item.CreatedAt = time.UnixMilli(createdAt)
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
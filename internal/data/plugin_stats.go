@@ -0,0 +1,232 @@
+package data
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+type PluginCallFilter struct {
+	Since   time.Time
+	Until   time.Time
+	Server  string
+	Bot     string
+	GroupID string
+	UserID  string
+	Plugin  string
+}
+
+func (f PluginCallFilter) where() (string, []interface{}) {
+	clauses := []string{"1 = 1"}
+	var args []interface{}
+	if !f.Since.IsZero() {
+		clauses = append(clauses, "timestamp >= ?")
+		args = append(args, f.Since.Unix())
+	}
+	if !f.Until.IsZero() {
+		clauses = append(clauses, "timestamp < ?")
+		args = append(args, f.Until.Unix())
+	}
+	if f.Server != "" {
+		clauses = append(clauses, "server = ?")
+		args = append(args, f.Server)
+	}
+	if f.Bot != "" {
+		clauses = append(clauses, "bot = ?")
+		args = append(args, f.Bot)
+	}
+	if f.GroupID != "" {
+		clauses = append(clauses, "group_id = ?")
+		args = append(args, f.GroupID)
+	}
+	if f.UserID != "" {
+		clauses = append(clauses, "user_id = ?")
+		args = append(args, f.UserID)
+	}
+	if f.Plugin != "" {
+		clauses = append(clauses, "plugin_name = ?")
+		args = append(args, f.Plugin)
+	}
+	return strings.Join(clauses, " AND "), args
+}
+
+type PluginCallStats struct {
+	Plugin      string
+	MatcherHash string
+	Calls       int
+	Errors      int
+	Mean        time.Duration
+	P50         time.Duration
+	P95         time.Duration
+	Max         time.Duration
+}
+
+func (s PluginCallStats) ErrorRate() float64 {
+	if s.Calls == 0 {
+		return 0
+	}
+	return float64(s.Errors) / float64(s.Calls)
+}
+
+type PluginCallDimension string
+
+const (
+	PluginCallByServer PluginCallDimension = "server"
+	PluginCallByBot    PluginCallDimension = "bot"
+	PluginCallByGroup  PluginCallDimension = "group_id"
+	PluginCallByUser   PluginCallDimension = "user_id"
+)
+
+type PluginCallBucket struct {
+	Value  string
+	Calls  int
+	Errors int
+}
+
+const pluginCallFailed = "(exception_name IS NOT NULL OR exception_detail IS NOT NULL)"
+
+// PluginCallStats aggregates calls per plugin, busiest first.
+func (s *Storage) PluginCallStats(filter PluginCallFilter) ([]PluginCallStats, error) {
+	stats, err := s.aggregatePluginCalls(filter, false)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(stats, func(i, j int) bool {
+		if stats[i].Calls != stats[j].Calls {
+			return stats[i].Calls > stats[j].Calls
+		}
+		return stats[i].Plugin < stats[j].Plugin
+	})
+	return stats, nil
+}
+
+// SlowestMatchers aggregates calls per plugin matcher, highest p95 first.
+func (s *Storage) SlowestMatchers(filter PluginCallFilter, limit int) ([]PluginCallStats, error) {
+	stats, err := s.aggregatePluginCalls(filter, true)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(stats, func(i, j int) bool {
+		if stats[i].P95 != stats[j].P95 {
+			return stats[i].P95 > stats[j].P95
+		}
+		return stats[i].Max > stats[j].Max
+	})
+	if limit > 0 && len(stats) > limit {
+		stats = stats[:limit]
+	}
+	return stats, nil
+}
+
+func (s *Storage) aggregatePluginCalls(filter PluginCallFilter, byMatcher bool) ([]PluginCallStats, error) {
+	where, args := filter.where()
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	rows, err := s.db.Query(fmt.Sprintf(`SELECT COALESCE(plugin_name, ''), COALESCE(matcher_hash, ''),
+        COALESCE(time_costed, 0), %s FROM plugin_call_record WHERE %s
+        ORDER BY plugin_name`, pluginCallFailed, where), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query plugin calls: %w", err)
+	}
+	defer rows.Close()
+	type group struct {
+		stats PluginCallStats
+		costs []float64
+	}
+	groups := make(map[[2]string]*group)
+	var order [][2]string
+	for rows.Next() {
+		var (
+			plugin, matcher string
+			cost            float64
+			failed          bool
+		)
+		if err := rows.Scan(&plugin, &matcher, &cost, &failed); err != nil {
+			return nil, fmt.Errorf("failed to scan plugin call: %w", err)
+		}
+		key := [2]string{plugin, ""}
+		if byMatcher {
+			key[1] = matcher
+		}
+		g, ok := groups[key]
+		if !ok {
+			g = &group{stats: PluginCallStats{Plugin: plugin, MatcherHash: key[1]}}
+			groups[key] = g
+			order = append(order, key)
+		}
+		g.stats.Calls++
+		if failed {
+			g.stats.Errors++
+		}
+		g.costs = append(g.costs, cost)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read plugin calls: %w", err)
+	}
+	stats := make([]PluginCallStats, 0, len(order))
+	for _, key := range order {
+		g := groups[key]
+		sort.Float64s(g.costs)
+		var total float64
+		for _, cost := range g.costs {
+			total += cost
+		}
+		g.stats.Mean = seconds(total / float64(len(g.costs)))
+		g.stats.P50 = seconds(percentile(g.costs, 0.50))
+		g.stats.P95 = seconds(percentile(g.costs, 0.95))
+		g.stats.Max = seconds(g.costs[len(g.costs)-1])
+		stats = append(stats, g.stats)
+	}
+	return stats, nil
+}
+
+// percentile uses the nearest-rank method on sorted values.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
+
+func seconds(value float64) time.Duration {
+	return time.Duration(value * float64(time.Second))
+}
+
+// PluginCallBreakdown counts calls per server, bot, group or user so a view can
+// offer drill-down choices; rows without a value are skipped.
+func (s *Storage) PluginCallBreakdown(filter PluginCallFilter, dimension PluginCallDimension, limit int) ([]PluginCallBucket, error) {
+	switch dimension {
+	case PluginCallByServer, PluginCallByBot, PluginCallByGroup, PluginCallByUser:
+	default:
+		return nil, fmt.Errorf("unknown plugin call dimension %q", dimension)
+	}
+	where, args := filter.where()
+	query := fmt.Sprintf(`SELECT %[1]s, COUNT(*), SUM(CASE WHEN %[2]s THEN 1 ELSE 0 END)
+        FROM plugin_call_record WHERE %[3]s AND COALESCE(%[1]s, '') != ''
+        GROUP BY %[1]s ORDER BY COUNT(*) DESC, %[1]s LIMIT ?`, dimension, pluginCallFailed, where)
+	if limit <= 0 {
+		limit = -1
+	}
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	rows, err := s.db.Query(query, append(args, limit)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query plugin call breakdown: %w", err)
+	}
+	defer rows.Close()
+	var buckets []PluginCallBucket
+	for rows.Next() {
+		var bucket PluginCallBucket
+		if err := rows.Scan(&bucket.Value, &bucket.Calls, &bucket.Errors); err != nil {
+			return nil, fmt.Errorf("failed to scan plugin call breakdown: %w", err)
+		}
+		buckets = append(buckets, bucket)
+	}
+	return buckets, rows.Err()
+}
@@ -0,0 +1,226 @@
+package data
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const DefaultProfileName = "default"
+
+type ConnectionProfile struct {
+	ID              int64     `json:"id"`
+	Name            string    `json:"name"`
+	Host            string    `json:"host"`
+	Port            int       `json:"port"`
+	Token           string    `json:"token"`
+	AuthMode        string    `json:"auth_mode"`
+	TLS             bool      `json:"tls"`
+	CABundle        string    `json:"ca_bundle"`
+	PinnedSHA256    string    `json:"pin_sha256"`
+	AllowSelfSigned bool      `json:"allow_self_signed"`
+	Compression     bool      `json:"compression"`
+	Encoding        string    `json:"encoding"`
+	Color           string    `json:"color"`
+	AutoConnect     bool      `json:"auto_connect"`
+	LegacyServer    bool      `json:"legacy_server"`
+	LastUsedAt      time.Time `json:"last_used_at"`
+}
+
+func initProfileTable(db execer) error {
+	query := `CREATE TABLE IF NOT EXISTS connection_profile (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            name TEXT NOT NULL UNIQUE,
+            host TEXT NOT NULL,
+            port INTEGER NOT NULL,
+            token TEXT NOT NULL DEFAULT '',
+            tls BOOLEAN DEFAULT FALSE,
+            color TEXT DEFAULT '',
+            last_used_at INTEGER DEFAULT 0,
+            created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+        )`
+	if _, err := db.Exec(query); err != nil {
+		return fmt.Errorf("failed to create connection_profile table: %w", err)
+	}
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM connection_profile`).Scan(&count); err != nil {
+		return fmt.Errorf("failed to count connection profiles: %w", err)
+	}
+	if count > 0 {
+		return nil
+	}
+	legacy := `INSERT INTO connection_profile (name, host, port, token)
+        SELECT ?, host, port, token FROM connection_config ORDER BY updated_at DESC LIMIT 1`
+	if _, err := db.Exec(legacy, DefaultProfileName); err != nil {
+		return fmt.Errorf("failed to import legacy connection config: %w", err)
+	}
+	return nil
+}
+
+func (s *Storage) SaveConnectionProfile(profile ConnectionProfile) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	name := strings.TrimSpace(profile.Name)
+	if name == "" {
+		return fmt.Errorf("profile name is empty")
+	}
+	query := `INSERT INTO connection_profile (name, host, port, token, tls, color, auto_connect,
+            ca_bundle, pin_sha256, allow_self_signed, auth_mode, compression, encoding)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT(name) DO UPDATE SET
+            host = excluded.host,
+            port = excluded.port,
+            token = excluded.token,
+            tls = excluded.tls,
+            color = excluded.color,
+            auto_connect = excluded.auto_connect,
+            ca_bundle = excluded.ca_bundle,
+            pin_sha256 = excluded.pin_sha256,
+            allow_self_signed = excluded.allow_self_signed,
+            auth_mode = excluded.auth_mode,
+            compression = excluded.compression,
+            encoding = excluded.encoding,
+            legacy_server = CASE WHEN connection_profile.host = excluded.host AND connection_profile.port = excluded.port
+                THEN connection_profile.legacy_server ELSE FALSE END`
+	_, err := s.db.Exec(query, name, profile.Host, profile.Port, profile.Token, profile.TLS, profile.Color, profile.AutoConnect,
+		profile.CABundle, profile.PinnedSHA256, profile.AllowSelfSigned, profile.AuthMode,
+		profile.Compression, profile.Encoding)
+	if err != nil {
+		return fmt.Errorf("failed to save connection profile: %w", err)
+	}
+	return nil
+}
+
+func (s *Storage) ListConnectionProfiles() ([]ConnectionProfile, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	query := `SELECT id, name, host, port, token, tls, COALESCE(color, ''), COALESCE(auto_connect, 0),
+            COALESCE(ca_bundle, ''), COALESCE(pin_sha256, ''), COALESCE(allow_self_signed, 0),
+            COALESCE(auth_mode, ''), COALESCE(compression, 0), COALESCE(encoding, ''),
+            COALESCE(legacy_server, 0), COALESCE(last_used_at, 0)
+        FROM connection_profile ORDER BY last_used_at DESC, name ASC`
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query connection profiles: %w", err)
+	}
+	defer rows.Close()
+	var profiles []ConnectionProfile
+	for rows.Next() {
+		profile, err := scanConnectionProfile(rows)
+		if err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, *profile)
+	}
+	return profiles, rows.Err()
+}
+
+func (s *Storage) ListAutoConnectProfiles() ([]ConnectionProfile, error) {
+	profiles, err := s.ListConnectionProfiles()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]ConnectionProfile, 0, len(profiles))
+	for _, profile := range profiles {
+		if profile.AutoConnect {
+			result = append(result, profile)
+		}
+	}
+	return result, nil
+}
+
+func (s *Storage) GetConnectionProfile(name string) (*ConnectionProfile, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	query := `SELECT id, name, host, port, token, tls, COALESCE(color, ''), COALESCE(auto_connect, 0),
+            COALESCE(ca_bundle, ''), COALESCE(pin_sha256, ''), COALESCE(allow_self_signed, 0),
+            COALESCE(auth_mode, ''), COALESCE(compression, 0), COALESCE(encoding, ''),
+            COALESCE(legacy_server, 0), COALESCE(last_used_at, 0)
+        FROM connection_profile WHERE name = ?`
+	profile, err := scanConnectionProfile(s.db.QueryRow(query, name))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("profile %q not found", name)
+		}
+		return nil, err
+	}
+	return profile, nil
+}
+
+func (s *Storage) DeleteConnectionProfile(name string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, err := s.db.Exec(`DELETE FROM connection_profile WHERE name = ?`, name); err != nil {
+		return fmt.Errorf("failed to delete connection profile: %w", err)
+	}
+	return nil
+}
+
+func (s *Storage) TouchConnectionProfile(name string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	query := `UPDATE connection_profile SET last_used_at = ? WHERE name = ?`
+	if _, err := s.db.Exec(query, time.Now().UnixMilli(), name); err != nil {
+		return fmt.Errorf("failed to update profile usage: %w", err)
+	}
+	return nil
+}
+
+// SetProfileLegacy remembers whether the profile's server only speaks the
+// pre-handshake protocol, so later connects can skip the probe.
+func (s *Storage) SetProfileLegacy(name string, legacy bool) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	query := `UPDATE connection_profile SET legacy_server = ? WHERE name = ?`
+	if _, err := s.db.Exec(query, legacy, name); err != nil {
+		return fmt.Errorf("failed to update profile legacy flag: %w", err)
+	}
+	return nil
+}
+
+func (s *Storage) ResolveAutoConnectProfile() (*ConnectionProfile, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	query := `SELECT id, name, host, port, token, tls, COALESCE(color, ''), COALESCE(auto_connect, 0),
+            COALESCE(ca_bundle, ''), COALESCE(pin_sha256, ''), COALESCE(allow_self_signed, 0),
+            COALESCE(auth_mode, ''), COALESCE(compression, 0), COALESCE(encoding, ''),
+            COALESCE(legacy_server, 0), COALESCE(last_used_at, 0)
+        FROM connection_profile
+        ORDER BY last_used_at > 0 DESC, last_used_at DESC, name = ? DESC
+        LIMIT 1`
+	profile, err := scanConnectionProfile(s.db.QueryRow(query, DefaultProfileName))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("no profile found")
+		}
+		return nil, err
+	}
+	if profile.LastUsedAt.IsZero() && profile.Name != DefaultProfileName {
+		return nil, fmt.Errorf("no profile found")
+	}
+	return profile, nil
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanConnectionProfile(row rowScanner) (*ConnectionProfile, error) {
+	var profile ConnectionProfile
+	var lastUsed int64
+	err := row.Scan(&profile.ID, &profile.Name, &profile.Host, &profile.Port,
+		&profile.Token, &profile.TLS, &profile.Color, &profile.AutoConnect,
+		&profile.CABundle, &profile.PinnedSHA256, &profile.AllowSelfSigned, &profile.AuthMode,
+		&profile.Compression, &profile.Encoding, &profile.LegacyServer, &lastUsed)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to scan connection profile: %w", err)
+	}
+	if lastUsed > 0 {
+		profile.LastUsedAt = time.UnixMilli(lastUsed)
+	}
+	return &profile, nil
+}
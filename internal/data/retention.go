@@ -0,0 +1,393 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPruneBatch = 500
+	pruneBatchPause   = 20 * time.Millisecond
+)
+
+type RetentionRule struct {
+	Bot     string
+	GroupID string
+	MaxAge  time.Duration
+	MaxRows int
+}
+
+func (r RetentionRule) scope() (string, []interface{}) {
+	var clauses []string
+	var args []interface{}
+	if r.Bot != "" {
+		clauses = append(clauses, "COALESCE(bot, '') = ?")
+		args = append(args, r.Bot)
+	}
+	if r.GroupID != "" {
+		clauses = append(clauses, "COALESCE(group_id, '') = ?")
+		args = append(args, r.GroupID)
+	}
+	if len(clauses) == 0 {
+		return "1 = 1", nil
+	}
+	return strings.Join(clauses, " AND "), args
+}
+
+type RetentionPolicy struct {
+	MaxAge   time.Duration
+	MaxRows  int
+	MaxBytes int64
+	Rules    []RetentionRule
+}
+
+func (p RetentionPolicy) IsZero() bool {
+	if p.MaxAge > 0 || p.MaxRows > 0 || p.MaxBytes > 0 {
+		return false
+	}
+	for _, rule := range p.Rules {
+		if rule.MaxAge > 0 || rule.MaxRows > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (p RetentionPolicy) remainder(covered func(RetentionRule) bool) (string, []interface{}) {
+	clauses := []string{"1 = 1"}
+	var args []interface{}
+	for _, rule := range p.Rules {
+		if !covered(rule) {
+			continue
+		}
+		clause, ruleArgs := rule.scope()
+		clauses = append(clauses, fmt.Sprintf("NOT (%s)", clause))
+		args = append(args, ruleArgs...)
+	}
+	return strings.Join(clauses, " AND "), args
+}
+
+type PruneResult struct {
+	Messages    int64
+	PluginCalls int64
+	Reclaimed   int64
+	Size        int64
+}
+
+func (r *PruneResult) add(table pruneTable, deleted int64) {
+	if table.name == "Message" {
+		r.Messages += deleted
+	} else {
+		r.PluginCalls += deleted
+	}
+}
+
+type pruneTable struct {
+	name   string
+	column string
+	unit   time.Duration
+}
+
+var pruneTables = []pruneTable{
+	{name: "Message", column: "timestamps", unit: time.Millisecond},
+	{name: "plugin_call_record", column: "timestamp", unit: time.Second},
+}
+
+func (t pruneTable) cutoff(age time.Duration) int64 {
+	return time.Now().Add(-age).UnixNano() / int64(t.unit)
+}
+
+func (s *Storage) DatabaseSize() (int64, error) {
+	var pages, pageSize int64
+	if err := s.db.QueryRow(`PRAGMA page_count`).Scan(&pages); err != nil {
+		return 0, fmt.Errorf("failed to read page count: %w", err)
+	}
+	if err := s.db.QueryRow(`PRAGMA page_size`).Scan(&pageSize); err != nil {
+		return 0, fmt.Errorf("failed to read page size: %w", err)
+	}
+	return pages * pageSize, nil
+}
+
+func (s *Storage) usedSize() (int64, error) {
+	size, err := s.DatabaseSize()
+	if err != nil {
+		return 0, err
+	}
+	var free, pageSize int64
+	if err := s.db.QueryRow(`PRAGMA freelist_count`).Scan(&free); err != nil {
+		return 0, fmt.Errorf("failed to read freelist: %w", err)
+	}
+	if err := s.db.QueryRow(`PRAGMA page_size`).Scan(&pageSize); err != nil {
+		return 0, fmt.Errorf("failed to read page size: %w", err)
+	}
+	return size - free*pageSize, nil
+}
+
+func (s *Storage) deleteBatch(query string, args ...interface{}) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	result, err := s.db.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (s *Storage) pruneWhere(ctx context.Context, table pruneTable, where string, args []interface{}, batch int, result *PruneResult) error {
+	query := fmt.Sprintf(`DELETE FROM %[1]s WHERE id IN
+        (SELECT id FROM %[1]s WHERE %[2]s ORDER BY id LIMIT ?)`, table.name, where)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		deleted, err := s.deleteBatch(query, append(append([]interface{}(nil), args...), batch)...)
+		if err != nil {
+			return fmt.Errorf("failed to prune %s: %w", table.name, err)
+		}
+		result.add(table, deleted)
+		if deleted < int64(batch) {
+			return nil
+		}
+		time.Sleep(pruneBatchPause)
+	}
+}
+
+func (s *Storage) pruneBeyond(ctx context.Context, table pruneTable, where string, args []interface{}, keep, batch int, result *PruneResult) error {
+	query := fmt.Sprintf(`DELETE FROM %[1]s WHERE id IN
+        (SELECT id FROM %[1]s WHERE %[2]s ORDER BY %[3]s DESC, id DESC LIMIT ? OFFSET ?)`, table.name, where, table.column)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		deleted, err := s.deleteBatch(query, append(append([]interface{}(nil), args...), batch, keep)...)
+		if err != nil {
+			return fmt.Errorf("failed to prune %s: %w", table.name, err)
+		}
+		result.add(table, deleted)
+		if deleted == 0 {
+			return nil
+		}
+		time.Sleep(pruneBatchPause)
+	}
+}
+
+func (s *Storage) Prune(ctx context.Context, policy RetentionPolicy, batch int) (PruneResult, error) {
+	var result PruneResult
+	if batch <= 0 {
+		batch = defaultPruneBatch
+	}
+	byAge := func(rule RetentionRule) bool { return rule.MaxAge > 0 }
+	byRows := func(rule RetentionRule) bool { return rule.MaxRows > 0 }
+	for _, table := range pruneTables {
+		for _, rule := range policy.Rules {
+			if rule.MaxAge <= 0 {
+				continue
+			}
+			where, args := rule.scope()
+			where = fmt.Sprintf("(%s) AND %s < ?", where, table.column)
+			if err := s.pruneWhere(ctx, table, where, append(args, table.cutoff(rule.MaxAge)), batch, &result); err != nil {
+				return result, err
+			}
+		}
+		if policy.MaxAge > 0 {
+			where, args := policy.remainder(byAge)
+			where = fmt.Sprintf("%s AND %s < ?", where, table.column)
+			if err := s.pruneWhere(ctx, table, where, append(args, table.cutoff(policy.MaxAge)), batch, &result); err != nil {
+				return result, err
+			}
+		}
+		for _, rule := range policy.Rules {
+			if rule.MaxRows <= 0 {
+				continue
+			}
+			where, args := rule.scope()
+			if err := s.pruneBeyond(ctx, table, where, args, rule.MaxRows, batch, &result); err != nil {
+				return result, err
+			}
+		}
+		if policy.MaxRows > 0 {
+			where, args := policy.remainder(byRows)
+			if err := s.pruneBeyond(ctx, table, where, args, policy.MaxRows, batch, &result); err != nil {
+				return result, err
+			}
+		}
+	}
+	if policy.MaxBytes > 0 {
+		if err := s.pruneToSize(ctx, policy.MaxBytes, batch, &result); err != nil {
+			return result, err
+		}
+	}
+	reclaimed, err := s.IncrementalVacuum()
+	if err != nil {
+		return result, err
+	}
+	result.Reclaimed = reclaimed
+	result.Size, err = s.DatabaseSize()
+	return result, err
+}
+
+func (s *Storage) pruneToSize(ctx context.Context, maxBytes int64, batch int, result *PruneResult) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		used, err := s.usedSize()
+		if err != nil {
+			return err
+		}
+		if used <= maxBytes {
+			return nil
+		}
+		var deleted int64
+		for _, table := range pruneTables {
+			query := fmt.Sprintf(`DELETE FROM %[1]s WHERE id IN
+                (SELECT id FROM %[1]s ORDER BY %[2]s, id LIMIT ?)`, table.name, table.column)
+			n, err := s.deleteBatch(query, batch)
+			if err != nil {
+				return fmt.Errorf("failed to prune %s: %w", table.name, err)
+			}
+			result.add(table, n)
+			deleted += n
+		}
+		if deleted == 0 {
+			return nil
+		}
+		time.Sleep(pruneBatchPause)
+	}
+}
+
+func (s *Storage) IncrementalVacuum() (int64, error) {
+	before, err := s.DatabaseSize()
+	if err != nil {
+		return 0, err
+	}
+	s.mutex.Lock()
+	err = s.vacuumFreePages()
+	s.mutex.Unlock()
+	if err != nil {
+		return 0, fmt.Errorf("failed to vacuum database: %w", err)
+	}
+	after, err := s.DatabaseSize()
+	if err != nil {
+		return 0, err
+	}
+	return before - after, nil
+}
+
+func (s *Storage) vacuumFreePages() error {
+	rows, err := s.db.Query(`PRAGMA incremental_vacuum`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+	}
+	return rows.Err()
+}
+
+type Pruner struct {
+	mutex    sync.Mutex
+	storage  *Storage
+	policy   RetentionPolicy
+	batch    int
+	kick     chan struct{}
+	cancel   context.CancelFunc
+	done     chan struct{}
+	onResult func(result PruneResult, err error)
+}
+
+func NewPruner(storage *Storage, policy RetentionPolicy) *Pruner {
+	return &Pruner{
+		storage: storage,
+		policy:  policy,
+		batch:   defaultPruneBatch,
+		kick:    make(chan struct{}, 1),
+	}
+}
+
+func (p *Pruner) SetPolicy(policy RetentionPolicy) {
+	p.mutex.Lock()
+	p.policy = policy
+	p.mutex.Unlock()
+	p.RunNow()
+}
+
+func (p *Pruner) Policy() RetentionPolicy {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.policy
+}
+
+func (p *Pruner) OnResult(callback func(result PruneResult, err error)) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.onResult = callback
+}
+
+func (p *Pruner) Run(ctx context.Context) (PruneResult, error) {
+	p.mutex.Lock()
+	policy, batch := p.policy, p.batch
+	p.mutex.Unlock()
+	if policy.IsZero() {
+		size, err := p.storage.DatabaseSize()
+		return PruneResult{Size: size}, err
+	}
+	return p.storage.Prune(ctx, policy, batch)
+}
+
+func (p *Pruner) Start(interval time.Duration) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.cancel != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+	p.done = make(chan struct{})
+	go p.loop(ctx, interval, p.done)
+}
+
+func (p *Pruner) Stop() {
+	p.mutex.Lock()
+	cancel, done := p.cancel, p.done
+	p.cancel = nil
+	p.mutex.Unlock()
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
+
+func (p *Pruner) RunNow() {
+	select {
+	case p.kick <- struct{}{}:
+	default:
+	}
+}
+
+func (p *Pruner) loop(ctx context.Context, interval time.Duration, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		result, err := p.Run(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		p.mutex.Lock()
+		callback := p.onResult
+		p.mutex.Unlock()
+		if callback != nil {
+			callback(result, err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-p.kick:
+		}
+	}
+}
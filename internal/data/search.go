@@ -0,0 +1,395 @@
+package data
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	HighlightStart = "\x02"
+	HighlightEnd   = "\x03"
+
+	defaultSearchLimit = 100
+	snippetRunes       = 48
+	snippetEllipsis    = "…"
+)
+
+type SearchQuery struct {
+	Text    string
+	Bot     string
+	UserID  string
+	GroupID string
+	Since   time.Time
+	Until   time.Time
+	FromBot *bool
+	Limit   int
+}
+
+func (q SearchQuery) Terms() []string {
+	return ParseSearchTerms(q.Text)
+}
+
+func (q SearchQuery) IsZero() bool {
+	return strings.TrimSpace(q.Text) == "" && !q.HasFilters()
+}
+
+func (q SearchQuery) HasFilters() bool {
+	return q.Bot != "" || q.UserID != "" || q.GroupID != "" || !q.Since.IsZero() || !q.Until.IsZero() || q.FromBot != nil
+}
+
+func (q SearchQuery) filters() ([]string, []interface{}) {
+	clauses := []string{"1 = 1"}
+	var args []interface{}
+	if q.Bot != "" {
+		clauses = append(clauses, "m.bot = ?")
+		args = append(args, q.Bot)
+	}
+	if q.UserID != "" {
+		clauses = append(clauses, "m.user = ?")
+		args = append(args, q.UserID)
+	}
+	if q.GroupID != "" {
+		clauses = append(clauses, "m.group_id = ?")
+		args = append(args, q.GroupID)
+	}
+	if !q.Since.IsZero() {
+		clauses = append(clauses, "m.timestamps >= ?")
+		args = append(args, q.Since.UnixMilli())
+	}
+	if !q.Until.IsZero() {
+		clauses = append(clauses, "m.timestamps < ?")
+		args = append(args, q.Until.UnixMilli())
+	}
+	if q.FromBot != nil {
+		clauses = append(clauses, "COALESCE(m.from_bot, FALSE) = ?")
+		args = append(args, *q.FromBot)
+	}
+	return clauses, args
+}
+
+type SearchResult struct {
+	Message
+	Highlight string
+	Snippet   string
+	Rank      float64
+}
+
+// ParseSearchTerms splits a query on whitespace, keeping double-quoted
+// phrases together. Every returned term must match; the last word of a
+// term also matches as a prefix.
+func ParseSearchTerms(text string) []string {
+	var terms []string
+	var current strings.Builder
+	quoted := false
+	flush := func() {
+		if term := strings.TrimSpace(current.String()); term != "" {
+			terms = append(terms, term)
+		}
+		current.Reset()
+	}
+	for _, r := range text {
+		switch {
+		case r == '"':
+			flush()
+			quoted = !quoted
+		case unicode.IsSpace(r) && !quoted:
+			flush()
+		default:
+			current.WriteRune(r)
+		}
+	}
+	flush()
+	return terms
+}
+
+// ftsPhrase converts a term into the same bigram tokens the index holds.
+// A leading lone Han character can sit at the end of an indexed bigram, so
+// such terms fall back to substring matching.
+func ftsPhrase(term string) (string, bool) {
+	tokens := ftsQueryTokens(term)
+	if len(tokens) == 0 {
+		return "", false
+	}
+	if first := []rune(tokens[0]); len(first) == 1 && isHan(first[0]) {
+		return "", false
+	}
+	return `"` + strings.ReplaceAll(strings.Join(tokens, " "), `"`, `""`) + `"*`, true
+}
+
+func ftsMatchExpression(terms []string) (string, bool) {
+	phrases := make([]string, 0, len(terms))
+	for _, term := range terms {
+		phrase, ok := ftsPhrase(term)
+		if !ok {
+			return "", false
+		}
+		phrases = append(phrases, phrase)
+	}
+	return strings.Join(phrases, " AND "), true
+}
+
+func escapeLike(term string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(term)
+}
+
+const searchColumns = `m.id, COALESCE(m.user, ''), COALESCE(m.group_id, ''), m.bot,
+        m.timestamps, m.content, COALESCE(m.meta, ''), COALESCE(m.plaintext, m.content), COALESCE(m.server, ''),
+        COALESCE(m.from_bot, FALSE)`
+
+func (s *Storage) Search(query SearchQuery) ([]SearchResult, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if query.Limit <= 0 {
+		query.Limit = defaultSearchLimit
+	}
+	terms := query.Terms()
+	if len(terms) == 0 && !query.HasFilters() {
+		return []SearchResult{}, nil
+	}
+	if match, ok := ftsMatchExpression(terms); ok && len(terms) > 0 && s.ftsEnabled {
+		isFTS5, err := isFTS5Table(s.db)
+		if err != nil {
+			return nil, fmt.Errorf("failed to detect FTS type: %w", err)
+		}
+		if isFTS5 {
+			return s.searchRanked(query, terms, match)
+		}
+	}
+	return s.searchFiltered(query, terms)
+}
+
+// searchRanked ranks with bm25 but highlights in Go: the index holds
+// tokenized text, so highlight() and snippet() would return bigrams.
+func (s *Storage) searchRanked(query SearchQuery, terms []string, match string) ([]SearchResult, error) {
+	clauses, filterArgs := query.filters()
+	statement := fmt.Sprintf(`SELECT %s, bm25(message_for_fts) AS rank
+        FROM message_for_fts f
+        JOIN Message m ON f.rowid = m.id
+        WHERE message_for_fts MATCH ? AND %s
+        ORDER BY rank, m.timestamps DESC
+        LIMIT ?`, searchColumns, strings.Join(clauses, " AND "))
+	args := []interface{}{match}
+	args = append(args, filterArgs...)
+	args = append(args, query.Limit)
+	rows, err := s.db.Query(statement, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search messages with FTS5: %w", err)
+	}
+	defer rows.Close()
+	var results []SearchResult
+	for rows.Next() {
+		var result SearchResult
+		if err := rows.Scan(append(result.scanTargets(), &result.Rank)...); err != nil {
+			return nil, fmt.Errorf("failed to scan search result: %w", err)
+		}
+		result.finish()
+		result.highlight(terms)
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+func (s *Storage) searchFiltered(query SearchQuery, terms []string) ([]SearchResult, error) {
+	clauses, args := query.filters()
+	for _, term := range terms {
+		clauses = append(clauses, `COALESCE(m.plaintext, m.content) LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+escapeLike(term)+"%")
+	}
+	statement := fmt.Sprintf(`SELECT %s FROM Message m WHERE %s
+        ORDER BY m.timestamps DESC, m.id DESC LIMIT ?`, searchColumns, strings.Join(clauses, " AND "))
+	args = append(args, query.Limit)
+	rows, err := s.db.Query(statement, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search messages with LIKE: %w", err)
+	}
+	defer rows.Close()
+	var results []SearchResult
+	for rows.Next() {
+		var result SearchResult
+		if err := rows.Scan(result.scanTargets()...); err != nil {
+			return nil, fmt.Errorf("failed to scan search result: %w", err)
+		}
+		result.finish()
+		result.highlight(terms)
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+func (r *SearchResult) scanTargets() []interface{} {
+	r.GroupID = new(string)
+	r.Meta = new(string)
+	return []interface{}{&r.ID, &r.User, r.GroupID, &r.Bot, &r.Timestamps, &r.Content, r.Meta, &r.Plaintext, &r.Server, &r.FromBot}
+}
+
+func (r *SearchResult) finish() {
+	r.BotID = r.Bot
+	r.UserID = r.User
+	r.Timestamp = time.UnixMilli(r.Timestamps)
+	if *r.GroupID == "" {
+		r.GroupID = nil
+	}
+	if *r.Meta == "" {
+		r.Meta = nil
+	}
+}
+
+func (r *SearchResult) highlight(terms []string) {
+	r.Highlight = HighlightTerms(r.Plaintext, terms)
+	r.Snippet = snippetOf(r.Plaintext, terms)
+}
+
+// HighlightTerms wraps every case-insensitive occurrence of the terms in
+// HighlightStart/HighlightEnd markers.
+func HighlightTerms(text string, terms []string) string {
+	source := []rune(text)
+	folded := make([]rune, len(source))
+	for i, r := range source {
+		folded[i] = unicode.ToLower(r)
+	}
+	marked := make([]bool, len(source))
+	for _, term := range terms {
+		needle := []rune(strings.ToLower(term))
+		if len(needle) == 0 {
+			continue
+		}
+		for i := 0; i+len(needle) <= len(folded); i++ {
+			if string(folded[i:i+len(needle)]) == string(needle) {
+				for j := i; j < i+len(needle); j++ {
+					marked[j] = true
+				}
+			}
+		}
+	}
+	var b strings.Builder
+	for i, r := range source {
+		if marked[i] && (i == 0 || !marked[i-1]) {
+			b.WriteString(HighlightStart)
+		}
+		b.WriteRune(r)
+		if marked[i] && (i == len(source)-1 || !marked[i+1]) {
+			b.WriteString(HighlightEnd)
+		}
+	}
+	return b.String()
+}
+
+func snippetOf(text string, terms []string) string {
+	runes := []rune(text)
+	if len(runes) <= snippetRunes {
+		return HighlightTerms(text, terms)
+	}
+	first := -1
+	folded := strings.ToLower(text)
+	for _, term := range terms {
+		if i := strings.Index(folded, strings.ToLower(term)); i >= 0 && (first < 0 || i < first) {
+			first = i
+		}
+	}
+	start := 0
+	if first > 0 {
+		start = len([]rune(folded[:first])) - snippetRunes/4
+	}
+	if start < 0 {
+		start = 0
+	}
+	end := start + snippetRunes
+	if end > len(runes) {
+		end = len(runes)
+		start = end - snippetRunes
+	}
+	snippet := HighlightTerms(string(runes[start:end]), terms)
+	if start > 0 {
+		snippet = snippetEllipsis + snippet
+	}
+	if end < len(runes) {
+		snippet += snippetEllipsis
+	}
+	return snippet
+}
+
+// SplitHighlights breaks highlighted text into alternating plain and
+// matched segments; matched reports whether each segment was marked.
+func SplitHighlights(text string) (segments []string, matched []bool) {
+	inside := false
+	for text != "" {
+		marker := HighlightStart
+		if inside {
+			marker = HighlightEnd
+		}
+		i := strings.Index(text, marker)
+		if i < 0 {
+			i = len(text)
+		}
+		if i > 0 {
+			segments = append(segments, text[:i])
+			matched = append(matched, inside)
+		}
+		if i == len(text) {
+			break
+		}
+		text = text[i+len(marker):]
+		inside = !inside
+	}
+	return segments, matched
+}
+
+func (s *Storage) RebuildSearchIndex() (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin index rebuild: %w", err)
+	}
+	defer tx.Rollback()
+	indexed, err := rebuildSearchIndex(tx)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit index rebuild: %w", err)
+	}
+	return indexed, nil
+}
+
+func rebuildSearchIndex(db execer) (int64, error) {
+	isFTS5, err := isFTS5Table(db)
+	if err != nil {
+		return 0, err
+	}
+	source := "plaintext"
+	if isFTS5 {
+		source = ftsTokenizeFunction + "(plaintext)"
+	}
+	if _, err := db.Exec(`DELETE FROM message_for_fts`); err != nil {
+		return 0, fmt.Errorf("failed to clear search index: %w", err)
+	}
+	result, err := db.Exec(fmt.Sprintf(`INSERT INTO message_for_fts(rowid, plaintext)
+        SELECT id, %s FROM Message`, source))
+	if err != nil {
+		return 0, fmt.Errorf("failed to rebuild search index: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+func (s *Storage) migrateTokenizedSearch(tx *sql.Tx) error {
+	isFTS5, err := isFTS5Table(tx)
+	if err != nil || !isFTS5 {
+		return err
+	}
+	for _, trigger := range []string{"trigger_message_insert", "trigger_message_update", "trigger_message_delete"} {
+		if _, err := tx.Exec("DROP TRIGGER IF EXISTS " + trigger); err != nil {
+			return fmt.Errorf("failed to drop search trigger: %w", err)
+		}
+	}
+	for _, trigger := range ftsTriggers {
+		if _, err := tx.Exec(trigger); err != nil {
+			return fmt.Errorf("failed to create search trigger: %w", err)
+		}
+	}
+	_, err = rebuildSearchIndex(tx)
+	return err
+}
@@ -1,13 +1,21 @@
 package data
 import (
+	"database/sql/driver"
 	"fmt"
 	"regexp"
 	"strings"
-	_ "github.com/glebarez/go-sqlite"
+	"unicode"
+	sqlite "github.com/glebarez/go-sqlite"
 )
+const ftsTokenizeFunction = "tokenize_for_fts"
 var (
 	tokenRegex = regexp.MustCompile(`[\p{Han}]+|[A-Za-z]+|\d+|[^\s\p{Han}A-Za-z\d]+`)
 )
+func init() {
+	sqlite.MustRegisterDeterministicScalarFunction(ftsTokenizeFunction, 1, func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		return goTokenizeForFts(args[0])
+	})
+}
 func goTokenizeForFts(s interface{}) (string, error) {
 	if s == nil {
 		return "", nil
@@ -47,6 +55,16 @@ func goTokenizeForFts(s interface{}) (string, error) {
 	}
 	return strings.Join(tokens, " "), nil
 }
+func ftsQueryTokens(term string) []string {
+	tokenized, _ := goTokenizeForFts(term)
+	var tokens []string
+	for _, token := range strings.Fields(tokenized) {
+		if strings.IndexFunc(token, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }) >= 0 {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
 func toString(v interface{}) string {
 	switch t := v.(type) {
 	case string:
@@ -14,6 +14,7 @@ type ConnectionConfig struct {
 }
 type BotInfo struct {
 	ID          string    `json:"id"`
+	Server      string    `json:"server"`
 	AdapterName string    `json:"adapter_name"`
 	Platform    string    `json:"platform"`
 	IsOnline    bool      `json:"is_online"`
@@ -21,6 +22,19 @@ type BotInfo struct {
 	DisplayName string    `json:"display_name"`
 	Avatar      string    `json:"avatar"`
 }
+type BotKey struct {
+	Server string
+	ID     string
+}
+func (b BotInfo) Key() BotKey {
+	return BotKey{Server: b.Server, ID: b.ID}
+}
+func (k BotKey) String() string {
+	if k.Server == "" {
+		return k.ID
+	}
+	return k.Server + "/" + k.ID
+}
 type Message struct {
 	ID         int64   `json:"id"`                  
 	User       string  `json:"user"`                
@@ -30,6 +44,9 @@ type Message struct {
 	Content    string  `json:"content"`             
 	Meta       *string `json:"meta,omitempty"`      
 	Plaintext  string  `json:"plaintext"`           
+	Server     string  `json:"server"`
+	Key        string  `json:"message_key,omitempty"`
+	RemoteID   string  `json:"remote_id,omitempty"`
 	BotID     string    `json:"bot_id"`                
 	FromBot   bool      `json:"from_bot"`              
 	Timestamp time.Time `json:"timestamp"`             
@@ -55,9 +72,12 @@ type Storage struct {
 	db    *sql.DB
 	mutex sync.RWMutex  
 	ftsEnabled bool
-	botInfos map[string]BotInfo
+	botInfos map[BotKey]BotInfo
+	path     string
+	backups  []string
 }
 type PluginCallRecord struct {
+	Server          string
 	Bot             string
 	Platform        string
 	TimeCosted      float64
@@ -70,6 +90,7 @@ type PluginCallRecord struct {
 	Timestamp       int64
 }
 func NewStorage(dbPath string) (*Storage, error) {
+	path := dbPath
 	dbPath += "?cache=shared&mode=rwc&_journal_mode=WAL&_synchronous=NORMAL&_cache_size=1000&_foreign_keys=1"
 	db, err := sql.Open("sqlite", dbPath)
 	if err != nil {
@@ -84,7 +105,8 @@ func NewStorage(dbPath string) (*Storage, error) {
 	}
 	storage := &Storage{
 		db:       db,
-		botInfos: make(map[string]BotInfo),
+		botInfos: make(map[BotKey]BotInfo),
+		path:     path,
 	}
 	if err := storage.initTables(); err != nil {
 		db.Close()
@@ -96,45 +118,8 @@ func (s *Storage) Close() error {
 	return s.db.Close()
 }
 func (s *Storage) initTables() error {
-	queries := []string{
-		`CREATE TABLE IF NOT EXISTS connection_config (
-            id INTEGER PRIMARY KEY AUTOINCREMENT,
-            host TEXT NOT NULL,
-            port INTEGER NOT NULL,
-            token TEXT NOT NULL,
-            remember BOOLEAN DEFAULT FALSE,
-            updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
-        )`,
-		`CREATE TABLE IF NOT EXISTS Message (
-            id INTEGER PRIMARY KEY AUTOINCREMENT,
-            user TEXT,
-            group_id TEXT,
-            bot TEXT,
-            timestamps INTEGER,
-            content TEXT,
-            meta TEXT,
-            plaintext TEXT
-        )`,
-		`CREATE TABLE IF NOT EXISTS plugin_call_record (
-            id INTEGER PRIMARY KEY AUTOINCREMENT,
-            bot TEXT,
-            platform TEXT,
-            time_costed REAL,
-            group_id TEXT,
-            user_id TEXT,
-            plugin_name TEXT,
-            matcher_hash TEXT,
-            exception_name TEXT,
-            exception_detail TEXT,
-            timestamp INTEGER
-        )`,
-		`CREATE INDEX IF NOT EXISTS idx_bot_platform ON plugin_call_record (bot, platform)`,
-		`CREATE INDEX IF NOT EXISTS idx_timestamp ON plugin_call_record (timestamp)`,
-	}
-	for _, query := range queries {
-		if _, err := s.db.Exec(query); err != nil {
-			return fmt.Errorf("failed to create table: %w", err)
-		}
+	if err := s.migrate(); err != nil {
+		return err
 	}
 	if err := s.enableFTS5(); err != nil {
 		if err := s.enablePlainIndex(); err != nil {
@@ -147,25 +132,59 @@ func (s *Storage) initTables() error {
 	_, _ = s.db.Exec("REINDEX;")
 	return nil
 }
-func (s *Storage) enableFTS5() error {
-	stmts := []string{
-		`CREATE VIRTUAL TABLE IF NOT EXISTS message_for_fts USING fts5(plaintext)`,
-		`CREATE TRIGGER IF NOT EXISTS trigger_message_insert AFTER INSERT ON Message
+func ensureColumn(db execer, table, column, definition string) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return fmt.Errorf("failed to inspect table %s: %w", table, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   int
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return fmt.Errorf("failed to scan table info for %s: %w", table, err)
+		}
+		if strings.EqualFold(name, column) {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to inspect table %s: %w", table, err)
+	}
+	rows.Close()
+	query := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)
+	if _, err := db.Exec(query); err != nil {
+		return fmt.Errorf("failed to add column %s.%s: %w", table, column, err)
+	}
+	return nil
+}
+var ftsTriggers = []string{
+	`CREATE TRIGGER IF NOT EXISTS trigger_message_insert AFTER INSERT ON Message
          BEGIN
              INSERT INTO message_for_fts(rowid, plaintext)
-             VALUES (NEW.id, NEW.plaintext);
+             VALUES (NEW.id, tokenize_for_fts(NEW.plaintext));
          END;`,
-		`CREATE TRIGGER IF NOT EXISTS trigger_message_update AFTER UPDATE ON Message
+	`CREATE TRIGGER IF NOT EXISTS trigger_message_update AFTER UPDATE ON Message
          BEGIN
              UPDATE message_for_fts
-             SET plaintext = NEW.plaintext
+             SET plaintext = tokenize_for_fts(NEW.plaintext)
              WHERE rowid = NEW.id;
          END;`,
-		`CREATE TRIGGER IF NOT EXISTS trigger_message_delete AFTER DELETE ON Message
+	`CREATE TRIGGER IF NOT EXISTS trigger_message_delete AFTER DELETE ON Message
          BEGIN
              DELETE FROM message_for_fts WHERE rowid = OLD.id;
          END;`,
-	}
+}
+func (s *Storage) enableFTS5() error {
+	stmts := append([]string{
+		`CREATE VIRTUAL TABLE IF NOT EXISTS message_for_fts USING fts5(plaintext)`,
+	}, ftsTriggers...)
 	for _, q := range stmts {
 		if _, err := s.db.Exec(q); err != nil {
 			return err
@@ -232,7 +251,7 @@ func (s *Storage) LoadConnectionConfig() (*ConnectionConfig, error) {
 func (s *Storage) SaveBotInfo(bot BotInfo) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
-	s.botInfos[bot.ID] = bot
+	s.botInfos[bot.Key()] = bot
 	return nil
 }
 func (s *Storage) GetBotInfoList() ([]BotInfo, error) {
@@ -244,41 +263,32 @@ func (s *Storage) GetBotInfoList() ([]BotInfo, error) {
 	}
 	return result, nil
 }
-func (s *Storage) UpdateBotOnlineStatus(botID string, isOnline bool) error {
+func (s *Storage) MarkServerBotsOffline(server string) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
-	if bot, exists := s.botInfos[botID]; exists {
+	for key, bot := range s.botInfos {
+		if key.Server == server && bot.IsOnline {
+			bot.IsOnline = false
+			bot.LastSeen = time.Now()
+			s.botInfos[key] = bot
+		}
+	}
+}
+func (s *Storage) UpdateBotOnlineStatus(key BotKey, isOnline bool) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if bot, exists := s.botInfos[key]; exists {
 		bot.IsOnline = isOnline
 		bot.LastSeen = time.Now()
-		s.botInfos[botID] = bot
+		s.botInfos[key] = bot
 	}
 	return nil
 }
 func (s *Storage) SaveMessage(msg Message) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
-	query := `INSERT INTO Message 
-        (user, group_id, bot, timestamps, content, meta, plaintext) 
-        VALUES (?, ?, ?, ?, ?, ?, ?)`
-	timestamps := msg.Timestamps
-	if timestamps == 0 {
-		timestamps = time.Now().UnixMilli()
-	}
-	bot := msg.Bot
-	if bot == "" {
-		bot = msg.BotID
-	}
-	user := msg.User
-	if user == "" {
-		user = msg.UserID
-	}
-	plaintext := msg.Plaintext
-	if plaintext == "" {
-		plaintext = msg.Content
-	}
-	_, err := s.db.Exec(query, user, msg.GroupID, bot, timestamps,
-		msg.Content, msg.Meta, plaintext)
-	if err != nil {
+	row := newMessageRow(msg)
+	if _, err := s.db.Exec(upsertMessageQuery, row.args()...); err != nil {
 		return fmt.Errorf("failed to save message: %w", err)
 	}
 	return nil
@@ -287,159 +297,43 @@ func (s *Storage) GetMessages(limit int, offset int) ([]Message, error) {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
 	query := `SELECT id, COALESCE(user, ''), COALESCE(group_id, ''), bot, 
-        timestamps, content, COALESCE(meta, ''), COALESCE(plaintext, content) 
+        timestamps, content, COALESCE(meta, ''), COALESCE(plaintext, content), COALESCE(server, ''), COALESCE(from_bot, FALSE) 
         FROM Message ORDER BY id ASC LIMIT ? OFFSET ?`
 	rows, err := s.db.Query(query, limit, offset)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query messages: %w", err)
 	}
 	defer rows.Close()
-	var messages []Message
-	for rows.Next() {
-		var msg Message
-		var groupID string
-		var meta string
-		err := rows.Scan(&msg.ID, &msg.User, &groupID, &msg.Bot,
-			&msg.Timestamps, &msg.Content, &meta, &msg.Plaintext)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan message: %w", err)
-		}
-		msg.BotID = msg.Bot
-		msg.UserID = msg.User
-		msg.Timestamp = time.UnixMilli(msg.Timestamps)
-		if groupID != "" {
-			msg.GroupID = &groupID
-		}
-		if meta != "" {
-			msg.Meta = &meta
-		}
-		messages = append(messages, msg)
-	}
-	return messages, nil
+	return s.scanMessageRows(rows)
 }
 func (s *Storage) SearchMessages(query string, limit int) ([]Message, error) {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
-	if s.ftsEnabled {
-		isFTS5, err := s.detectFTSType()
-		if err != nil {
-			return nil, fmt.Errorf("failed to detect FTS type: %w", err)
-		}
-		if isFTS5 {
-			return s.searchWithFTS5(query, limit)
-		} else {
-			return s.searchWithLikeQuery(query, limit)
-		}
-	}
-	return s.searchWithLikeQuery(query, limit)
-}
-func (s *Storage) tokenizeSearchQuery(query string) (string, error) {
-	tokenizedStr, err := goTokenizeForFts(query)
+	results, err := s.Search(SearchQuery{Text: query, Limit: limit})
 	if err != nil {
-		return "", fmt.Errorf("failed to tokenize query: %w", err)
-	}
-	if tokenizedStr == "" {
-		return "", nil
-	}
-	tokens := strings.Fields(tokenizedStr)
-	if len(tokens) == 0 {
-		return "", nil
+		return nil, err
 	}
-	if len(tokens) == 0 {
-		return "", nil
+	messages := make([]Message, 0, len(results))
+	for _, result := range results {
+		messages = append(messages, result.Message)
 	}
-	finalQuery := strings.Join(tokens, " OR ")
-	return finalQuery, nil
+	return messages, nil
 }
 func (s *Storage) IsFTSEnabled() bool {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
 	return s.ftsEnabled
 }
-func (s *Storage) detectFTSType() (bool, error) {
+func isFTS5Table(db execer) (bool, error) {
 	var sql_text string
-	err := s.db.QueryRow("SELECT sql FROM sqlite_master WHERE type='table' AND name='message_for_fts'").Scan(&sql_text)
+	err := db.QueryRow("SELECT sql FROM sqlite_master WHERE type='table' AND name='message_for_fts'").Scan(&sql_text)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
 	if err != nil {
 		return false, fmt.Errorf("failed to get table definition: %w", err)
 	}
 	isFTS5 := strings.Contains(strings.ToLower(sql_text), "fts5")
 	return isFTS5, nil
 }
-func (s *Storage) searchWithFTS5(query string, limit int) ([]Message, error) {
-	tokenizedQuery, err := s.tokenizeSearchQuery(query)
-	if err != nil {
-		return nil, fmt.Errorf("failed to tokenize search query: %w", err)
-	}
-	if tokenizedQuery == "" {
-		return []Message{}, nil
-	}
-	sqlQuery := `
-        SELECT 
-            m.id,
-            COALESCE(m.user, ''),
-            COALESCE(m.group_id, ''),
-            m.bot,
-            m.timestamps,
-            m.content,
-            COALESCE(m.meta, ''),
-            COALESCE(m.plaintext, m.content)
-        FROM
-            message_for_fts f
-        JOIN
-            Message m ON f.rowid = m.id
-        WHERE
-            f.plaintext MATCH ?
-        ORDER BY
-            m.timestamps DESC
-        LIMIT ?`
-	rows, err := s.db.Query(sqlQuery, tokenizedQuery, limit)
-	if err != nil {
-		return nil, fmt.Errorf("failed to search messages with FTS5: %w", err)
-	}
-	defer rows.Close()
-	return s.scanMessageRows(rows)
-}
-func (s *Storage) searchWithLikeQuery(query string, limit int) ([]Message, error) {
-	tokenizedStr, err := goTokenizeForFts(query)
-	if err != nil {
-		return nil, fmt.Errorf("failed to tokenize query: %w", err)
-	}
-	tokens := strings.Fields(tokenizedStr)
-	if len(tokens) == 0 {
-		return []Message{}, nil
-	}
-	var whereConditions []string
-	var args []interface{}
-	for _, token := range tokens {
-		whereConditions = append(whereConditions, "f.plaintext LIKE ?")
-		args = append(args, "%"+token+"%")
-	}
-	args = append(args, limit)
-	sqlQuery := `
-        SELECT 
-            m.id,
-            COALESCE(m.user, ''),
-            COALESCE(m.group_id, ''),
-            m.bot,
-            m.timestamps,
-            m.content,
-            COALESCE(m.meta, ''),
-            COALESCE(m.plaintext, m.content)
-        FROM
-            message_for_fts f
-        JOIN
-            Message m ON f.rowid = m.id
-        WHERE ` + strings.Join(whereConditions, " AND ") + `
-        ORDER BY
-            m.timestamps DESC
-        LIMIT ?`
-	rows, err := s.db.Query(sqlQuery, args...)
-	if err != nil {
-		return nil, fmt.Errorf("failed to search messages with LIKE: %w", err)
-	}
-	defer rows.Close()
-	return s.scanMessageRows(rows)
-}
 func (s *Storage) scanMessageRows(rows *sql.Rows) ([]Message, error) {
 	var messages []Message
 	rowCount := 0
@@ -448,7 +342,7 @@ func (s *Storage) scanMessageRows(rows *sql.Rows) ([]Message, error) {
 		var msg Message
 		var groupID string
 		var meta string
-		err := rows.Scan(&msg.ID, &msg.User, &groupID, &msg.Bot, &msg.Timestamps, &msg.Content, &meta, &msg.Plaintext)
+		err := rows.Scan(&msg.ID, &msg.User, &groupID, &msg.Bot, &msg.Timestamps, &msg.Content, &meta, &msg.Plaintext, &msg.Server, &msg.FromBot)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan message: %w", err)
 		}
@@ -469,7 +363,7 @@ func (s *Storage) GetMessagesByBot(botID string, limit int, offset int) ([]Messa
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
 	query := `SELECT id, COALESCE(user, ''), COALESCE(group_id, ''), bot, 
-        timestamps, content, COALESCE(meta, ''), COALESCE(plaintext, content) 
+        timestamps, content, COALESCE(meta, ''), COALESCE(plaintext, content), COALESCE(server, ''), COALESCE(from_bot, FALSE) 
         FROM Message WHERE bot = ? 
         ORDER BY timestamps DESC LIMIT ? OFFSET ?`
 	rows, err := s.db.Query(query, botID, limit, offset)
@@ -477,28 +371,7 @@ func (s *Storage) GetMessagesByBot(botID string, limit int, offset int) ([]Messa
 		return nil, fmt.Errorf("failed to query messages by bot: %w", err)
 	}
 	defer rows.Close()
-	var messages []Message
-	for rows.Next() {
-		var msg Message
-		var groupID string
-		var meta string
-		err := rows.Scan(&msg.ID, &msg.User, &groupID, &msg.Bot,
-			&msg.Timestamps, &msg.Content, &meta, &msg.Plaintext)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan message: %w", err)
-		}
-		msg.BotID = msg.Bot
-		msg.UserID = msg.User
-		msg.Timestamp = time.UnixMilli(msg.Timestamps)
-		if groupID != "" {
-			msg.GroupID = &groupID
-		}
-		if meta != "" {
-			msg.Meta = &meta
-		}
-		messages = append(messages, msg)
-	}
-	return messages, nil
+	return s.scanMessageRows(rows)
 }
 func (s *Storage) GetBotMessageCount(botID string) (int64, error) {
 	s.mutex.RLock()
@@ -539,11 +412,11 @@ func (s *Storage) SavePluginCall(rec PluginCallRecord) error {
 	defer s.mutex.Unlock()
 	query := `INSERT INTO plugin_call_record(
         bot, platform, time_costed, group_id, user_id, plugin_name,
-        matcher_hash, exception_name, exception_detail, timestamp
-    ) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+        matcher_hash, exception_name, exception_detail, timestamp, server
+    ) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 	_, err := s.db.Exec(query,
 		rec.Bot, rec.Platform, rec.TimeCosted, rec.GroupID, rec.UserID, rec.PluginName,
-		rec.MatcherHash, rec.ExceptionName, rec.ExceptionDetail, rec.Timestamp,
+		rec.MatcherHash, rec.ExceptionName, rec.ExceptionDetail, rec.Timestamp, rec.Server,
 	)
 	if err != nil {
 		return fmt.Errorf("failed to save plugin_call_record: %w", err)
@@ -560,6 +433,8 @@ func (s *Storage) ClearAllData() error {
 		"message_for_fts",
 		"plugin_call_record", 
 		"connection_config",
+		"connection_profile",
+		"request_outbox",
 	}
 	tx, err := s.db.Begin()
 	if err != nil {
@@ -574,7 +449,7 @@ func (s *Storage) ClearAllData() error {
 			}
 		}
 	}
-	resetQuery := "DELETE FROM sqlite_sequence WHERE name IN ('Message', 'plugin_call_record', 'connection_config')"
+	resetQuery := "DELETE FROM sqlite_sequence WHERE name IN ('Message', 'plugin_call_record', 'connection_config', 'connection_profile', 'request_outbox')"
 	if _, err := tx.Exec(resetQuery); err != nil {
 		if !strings.Contains(err.Error(), "no such table") {
 			return fmt.Errorf("failed to reset sequence: %w", err)
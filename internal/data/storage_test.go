@@ -0,0 +1,615 @@
+package data_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"lazytea-mobile/internal/data"
+	"lazytea-mobile/internal/network"
+	"lazytea-mobile/internal/utils"
+)
+
+func quietLogger() *utils.Logger {
+	logger := utils.NewLogger()
+	logger.SetLevel(utils.ERROR)
+	return logger
+}
+
+func openStorage(t *testing.T) *data.Storage {
+	t.Helper()
+	storage, err := data.NewStorage(filepath.Join(t.TempDir(), "data.db"))
+	if err != nil {
+		t.Fatalf("open storage: %v", err)
+	}
+	t.Cleanup(func() { storage.Close() })
+	return storage
+}
+
+func TestMessageKeysCollapseDuplicates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.db")
+	legacy, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("open legacy database: %v", err)
+	}
+	for _, query := range []string{
+		`CREATE TABLE Message (id INTEGER PRIMARY KEY AUTOINCREMENT, user TEXT, group_id TEXT, bot TEXT,
+            timestamps INTEGER, content TEXT, meta TEXT, plaintext TEXT, server TEXT DEFAULT '')`,
+		`INSERT INTO Message (user, bot, timestamps, content, plaintext, server) VALUES ('42', '10001', 1000, 'hi', 'hi', 'mock')`,
+		`INSERT INTO Message (user, bot, timestamps, content, plaintext, server) VALUES ('42', '10001', 1000, 'hi', 'hi', 'mock')`,
+		`INSERT INTO Message (user, bot, timestamps, content, plaintext, server) VALUES ('42', '10001', 2000, 'hi', 'hi', 'mock')`,
+		`INSERT INTO Message (user, bot, timestamps, content, plaintext, server) VALUES ('42', '10001', 2500, 'yo', '', 'mock')`,
+	} {
+		if _, err := legacy.Exec(query); err != nil {
+			t.Fatalf("seed legacy database: %v", err)
+		}
+	}
+	legacy.Close()
+
+	storage, err := data.NewStorage(path)
+	if err != nil {
+		t.Fatalf("open storage: %v", err)
+	}
+	defer storage.Close()
+	if count, err := storage.GetTotalMessageCount(); err != nil || count != 3 {
+		t.Fatalf("expected duplicates to collapse to 3 messages, got %d (%v)", count, err)
+	}
+	if version, err := storage.UserVersion(); err != nil || version != data.SchemaVersion() {
+		t.Fatalf("expected schema version %d, got %d (%v)", data.SchemaVersion(), version, err)
+	}
+	backups := storage.Backups()
+	if len(backups) != 1 {
+		t.Fatalf("expected a backup before collapsing duplicates, got %v", backups)
+	}
+	backup, err := sql.Open("sqlite", backups[0])
+	if err != nil {
+		t.Fatalf("open backup: %v", err)
+	}
+	defer backup.Close()
+	var backedUp int
+	if err := backup.QueryRow(`SELECT COUNT(*) FROM Message`).Scan(&backedUp); err != nil || backedUp != 4 {
+		t.Fatalf("expected the backup to keep all 4 original rows, got %d (%v)", backedUp, err)
+	}
+
+	for _, duplicate := range []data.Message{
+		{User: "42", Bot: "10001", Timestamps: 1000, Content: "hi", Server: "mock"},
+		{User: "42", Bot: "10001", Timestamps: 2500, Content: "yo", Server: "mock"},
+	} {
+		if err := storage.SaveMessage(duplicate); err != nil {
+			t.Fatalf("save duplicate: %v", err)
+		}
+	}
+	if count, err := storage.GetTotalMessageCount(); err != nil || count != 3 {
+		t.Fatalf("duplicate message was stored again: %d (%v)", count, err)
+	}
+
+	untimed := data.Message{User: "42", Bot: "10001", Content: "no time", Server: "mock"}
+	for i := 0; i < 2; i++ {
+		if err := storage.SaveMessage(untimed); err != nil {
+			t.Fatalf("save message without time: %v", err)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+	if count, err := storage.GetTotalMessageCount(); err != nil || count != 4 {
+		t.Fatalf("redelivered message without time was stored again: %d (%v)", count, err)
+	}
+
+	remote := data.Message{User: "42", Bot: "10001", Timestamps: 3000, Content: "draft", Server: "mock", RemoteID: "m-1"}
+	if err := storage.SaveMessage(remote); err != nil {
+		t.Fatalf("save remote message: %v", err)
+	}
+	remote.Content = "edited"
+	remote.Plaintext = "edited"
+	if err := storage.SaveMessage(remote); err != nil {
+		t.Fatalf("upsert remote message: %v", err)
+	}
+	messages, err := storage.GetMessages(10, 0)
+	if err != nil {
+		t.Fatalf("get messages: %v", err)
+	}
+	edited := 0
+	for _, msg := range messages {
+		if msg.Content == "edited" {
+			edited++
+		}
+	}
+	if len(messages) != 5 || edited != 1 {
+		t.Fatalf("expected the remote message to be updated in place: %+v", messages)
+	}
+}
+
+func TestRefusesNewerSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.db")
+	storage, err := data.NewStorage(path)
+	if err != nil {
+		t.Fatalf("open storage: %v", err)
+	}
+	if len(storage.Backups()) != 0 {
+		t.Fatalf("a fresh database should not be backed up: %v", storage.Backups())
+	}
+	storage.Close()
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	if _, err := db.Exec(fmt.Sprintf("PRAGMA user_version = %d", data.SchemaVersion()+1)); err != nil {
+		t.Fatalf("bump schema version: %v", err)
+	}
+	db.Close()
+
+	if _, err := data.NewStorage(path); !errors.Is(err, data.ErrSchemaTooNew) {
+		t.Fatalf("expected ErrSchemaTooNew, got %v", err)
+	}
+}
+
+func TestPruneAppliesRetentionRules(t *testing.T) {
+	storage := openStorage(t)
+	now := time.Now()
+	group := func(id string) *string { return &id }
+	save := func(bot string, groupID *string, age time.Duration, text string) {
+		t.Helper()
+		msg := data.Message{Bot: bot, User: "42", GroupID: groupID, Content: text, Server: "mock",
+			Timestamps: now.Add(-age).UnixMilli()}
+		if err := storage.SaveMessage(msg); err != nil {
+			t.Fatalf("save message: %v", err)
+		}
+	}
+	save("10001", nil, 10*24*time.Hour, "expired")
+	save("10001", nil, time.Hour, "recent")
+	save("10001", group("keep"), 10*24*time.Hour, "kept by group rule")
+	save("10001", group("keep"), 40*24*time.Hour, "expired by group rule")
+	for i := 0; i < 6; i++ {
+		save("chatty", nil, time.Duration(i)*time.Minute, fmt.Sprintf("chatty %d", i))
+	}
+	for _, age := range []time.Duration{time.Hour, 10 * 24 * time.Hour} {
+		if err := storage.SavePluginCall(data.PluginCallRecord{Bot: "10001", PluginName: "echo", Timestamp: now.Add(-age).Unix()}); err != nil {
+			t.Fatalf("save plugin call: %v", err)
+		}
+	}
+
+	policy := data.RetentionPolicy{
+		MaxAge: 7 * 24 * time.Hour,
+		Rules: []data.RetentionRule{
+			{GroupID: "keep", MaxAge: 30 * 24 * time.Hour},
+			{Bot: "chatty", MaxRows: 3},
+		},
+	}
+	result, err := storage.Prune(context.Background(), policy, 2)
+	if err != nil {
+		t.Fatalf("prune: %v", err)
+	}
+	if result.Messages != 5 || result.PluginCalls != 1 {
+		t.Fatalf("unexpected prune result: %+v", result)
+	}
+	for text, want := range map[string]int{
+		"expired": 0, "recent": 1, "kept by group rule": 1, "expired by group rule": 0,
+		"chatty 0": 1, "chatty 2": 1, "chatty 3": 0, "chatty 5": 0,
+	} {
+		found, err := storage.SearchMessages(text, 10)
+		if err != nil {
+			t.Fatalf("search %q: %v", text, err)
+		}
+		matched := 0
+		for _, msg := range found {
+			if msg.Content == text {
+				matched++
+			}
+		}
+		if matched != want {
+			t.Fatalf("expected %d rows for %q after pruning, got %d", want, text, matched)
+		}
+	}
+}
+
+func TestPruneBySizeReclaimsSpace(t *testing.T) {
+	storage := openStorage(t)
+	payload := strings.Repeat("茶", 2048)
+	for i := 0; i < 200; i++ {
+		msg := data.Message{Bot: "10001", Content: fmt.Sprintf("%d %s", i, payload), Server: "mock", Timestamps: int64(i + 1)}
+		if err := storage.SaveMessage(msg); err != nil {
+			t.Fatalf("save message: %v", err)
+		}
+	}
+	before, err := storage.DatabaseSize()
+	if err != nil {
+		t.Fatalf("database size: %v", err)
+	}
+	result, err := storage.Prune(context.Background(), data.RetentionPolicy{MaxBytes: before / 2}, 10)
+	if err != nil {
+		t.Fatalf("prune: %v", err)
+	}
+	if result.Messages == 0 || result.Reclaimed <= 0 || result.Size >= before {
+		t.Fatalf("expected size-based pruning to reclaim space (before %d): %+v", before, result)
+	}
+	messages, err := storage.GetMessages(1, 0)
+	if err != nil || len(messages) != 1 || messages[0].Timestamps != result.Messages+1 {
+		t.Fatalf("expected the oldest messages to be pruned first: %+v (%v)", messages, err)
+	}
+}
+
+func TestConversationKeysetPagination(t *testing.T) {
+	storage := openStorage(t)
+	group := "123"
+	for i := 1; i <= 7; i++ {
+		msg := data.Message{Bot: "10001", User: "42", GroupID: &group, Content: fmt.Sprintf("group %d", i),
+			Server: "mock", Timestamps: int64(1000 + i/2)}
+		if err := storage.SaveMessage(msg); err != nil {
+			t.Fatalf("save message: %v", err)
+		}
+	}
+	for i := 1; i <= 2; i++ {
+		msg := data.Message{Bot: "10001", User: "7", Content: fmt.Sprintf("private %d", i), Server: "mock", Timestamps: int64(2000 + i)}
+		if err := storage.SaveMessage(msg); err != nil {
+			t.Fatalf("save message: %v", err)
+		}
+	}
+	other := data.Message{Bot: "10001", User: "42", GroupID: &group, Content: "other server", Server: "other", Timestamps: 1500}
+	if err := storage.SaveMessage(other); err != nil {
+		t.Fatalf("save message: %v", err)
+	}
+	contents := func(page data.MessagePage) string {
+		var parts []string
+		for _, msg := range page.Messages {
+			parts = append(parts, msg.Content)
+		}
+		return strings.Join(parts, ",")
+	}
+
+	conversation := data.Conversation{Server: "mock", Bot: "10001", GroupID: group}
+	query := data.MessageQuery{Conversation: &conversation, Limit: 3}
+	var pages []string
+	for {
+		page, err := storage.QueryMessages(query)
+		if err != nil {
+			t.Fatalf("query messages: %v", err)
+		}
+		pages = append(pages, contents(page))
+		if !page.HasMore {
+			break
+		}
+		oldest, _ := page.Oldest()
+		query.Before = &oldest
+	}
+	want := []string{"group 5,group 6,group 7", "group 2,group 3,group 4", "group 1"}
+	if strings.Join(pages, "|") != strings.Join(want, "|") {
+		t.Fatalf("unexpected backward pages: %q", pages)
+	}
+
+	first, err := storage.QueryMessages(data.MessageQuery{Conversation: &conversation, Limit: 2})
+	if err != nil {
+		t.Fatalf("query messages: %v", err)
+	}
+	oldest, _ := first.Oldest()
+	after, err := storage.QueryMessages(data.MessageQuery{Conversation: &conversation, After: &data.MessageCursor{Timestamps: 1001, ID: 1}, Limit: 2})
+	if err != nil {
+		t.Fatalf("query messages: %v", err)
+	}
+	if contents(after) != "group 2,group 3" || !after.HasMore || oldest.Timestamps != 1003 {
+		t.Fatalf("unexpected forward page: %q (more %v), oldest %+v", contents(after), after.HasMore, oldest)
+	}
+
+	private := data.Conversation{Server: "mock", Bot: "10001", UserID: "7"}
+	page, err := storage.QueryMessages(data.MessageQuery{Conversation: &private})
+	if err != nil || contents(page) != "private 1,private 2" || page.HasMore {
+		t.Fatalf("unexpected private conversation page: %q (%v)", contents(page), err)
+	}
+	if !private.Contains(page.Messages[0]) || conversation.Contains(page.Messages[0]) || conversation.Contains(other) {
+		t.Fatalf("conversation membership mismatch for %+v", page.Messages[0])
+	}
+
+	summaries, err := storage.ListConversations(10)
+	if err != nil {
+		t.Fatalf("list conversations: %v", err)
+	}
+	if len(summaries) != 3 || summaries[0].Conversation != private || summaries[1].Count != 1 ||
+		summaries[1].Server != "other" || summaries[2].Count != 7 {
+		t.Fatalf("unexpected conversations: %+v", summaries)
+	}
+}
+
+func TestStructuredSearchRanksAndHighlights(t *testing.T) {
+	storage := openStorage(t)
+	group := "123"
+	messages := []data.Message{
+		{Bot: "10001", User: "42", GroupID: &group, Content: "the weather report says rain", Timestamps: 1000},
+		{Bot: "10001", User: "42", GroupID: &group, Content: "weather weather report, weather report again", Timestamps: 2000},
+		{Bot: "10001", User: "7", Content: "report the weather please", Timestamps: 3000},
+		{Bot: "10001", Content: "weather report: sunny", Timestamps: 4000, FromBot: true},
+		{Bot: "20002", User: "42", Content: "weather report from another bot", Timestamps: 5000},
+	}
+	for _, msg := range messages {
+		msg.Server = "mock"
+		if err := storage.SaveMessage(msg); err != nil {
+			t.Fatalf("save message: %v", err)
+		}
+	}
+	search := func(query data.SearchQuery) []data.SearchResult {
+		t.Helper()
+		results, err := storage.Search(query)
+		if err != nil {
+			t.Fatalf("search %+v: %v", query, err)
+		}
+		return results
+	}
+
+	if terms := data.ParseSearchTerms(`"weather report"  rain`); strings.Join(terms, "|") != "weather report|rain" {
+		t.Fatalf("unexpected terms: %q", terms)
+	}
+	results := search(data.SearchQuery{Text: `"weather report"`, Bot: "10001"})
+	if len(results) != 3 {
+		t.Fatalf("phrase should skip reordered words and other bots, got %d results", len(results))
+	}
+	if results[0].Timestamps != 2000 {
+		t.Fatalf("expected the densest match first, got %q", results[0].Content)
+	}
+	for i := 1; i < len(results); i++ {
+		if results[i].Rank < results[i-1].Rank {
+			t.Fatalf("results not ordered by rank: %v then %v", results[i-1].Rank, results[i].Rank)
+		}
+	}
+	if want := data.HighlightStart + "weather report" + data.HighlightEnd + ": sunny"; results[1].Highlight != want && results[2].Highlight != want {
+		t.Fatalf("expected highlighted phrase, got %q and %q", results[1].Highlight, results[2].Highlight)
+	}
+
+	if results := search(data.SearchQuery{Text: "weather rain"}); len(results) != 1 || results[0].Timestamps != 1000 {
+		t.Fatalf("terms should be ANDed, got %d results", len(results))
+	}
+	fromBot := true
+	if results := search(data.SearchQuery{Text: "weather", FromBot: &fromBot}); len(results) != 1 || !results[0].FromBot {
+		t.Fatalf("expected only the bot reply, got %d results", len(results))
+	}
+	results = search(data.SearchQuery{Text: "weather", UserID: "42", GroupID: group,
+		Since: time.UnixMilli(1500), Until: time.UnixMilli(2500)})
+	if len(results) != 1 || results[0].Timestamps != 2000 {
+		t.Fatalf("expected one message in range, got %d results", len(results))
+	}
+	segments, matched := data.SplitHighlights(results[0].Snippet)
+	var marked []string
+	for i, segment := range segments {
+		if matched[i] {
+			marked = append(marked, segment)
+		}
+	}
+	if len(marked) != 3 || marked[0] != "weather" {
+		t.Fatalf("unexpected snippet highlights %q in %q", marked, results[0].Snippet)
+	}
+
+	results = search(data.SearchQuery{Text: "ra", GroupID: group})
+	if len(results) != 1 || results[0].Highlight != "the weather report says "+data.HighlightStart+"ra"+data.HighlightEnd+"in" {
+		t.Fatalf("unexpected prefix result %+v", results)
+	}
+	if results := search(data.SearchQuery{Bot: "20002"}); len(results) != 1 {
+		t.Fatalf("filters alone should return matches, got %d", len(results))
+	}
+}
+
+func TestSearchMatchesChineseBigrams(t *testing.T) {
+	storage := openStorage(t)
+	for i, text := range []string{"今天天气真好", "天气预报说明天下雨", "好天气 hello", "明天见"} {
+		msg := data.Message{Bot: "10001", User: "42", Content: text, Server: "mock", Timestamps: int64(1000 + i)}
+		if err := storage.SaveMessage(msg); err != nil {
+			t.Fatalf("save message: %v", err)
+		}
+	}
+	count := func(text string) int {
+		t.Helper()
+		results, err := storage.Search(data.SearchQuery{Text: text})
+		if err != nil {
+			t.Fatalf("search %q: %v", text, err)
+		}
+		return len(results)
+	}
+	cases := map[string]int{"天气": 3, "天气真": 1, "明天 下雨": 1, "天": 4, "气 hello": 1, "HELLO": 1, "晴天": 0}
+	for text, want := range cases {
+		if got := count(text); got != want {
+			t.Errorf("search %q: expected %d results, got %d", text, want, got)
+		}
+	}
+	results, err := storage.Search(data.SearchQuery{Text: "天气真"})
+	if err != nil || len(results) != 1 {
+		t.Fatalf("search: %v", err)
+	}
+	if want := "今天" + data.HighlightStart + "天气真" + data.HighlightEnd + "好"; results[0].Highlight != want {
+		t.Fatalf("expected highlight on the original text, got %q", results[0].Highlight)
+	}
+	indexed, err := storage.RebuildSearchIndex()
+	if err != nil || indexed != 4 {
+		t.Fatalf("expected to re-index 4 messages, got %d (%v)", indexed, err)
+	}
+	if got := count("天气"); got != 3 {
+		t.Fatalf("expected 3 results after rebuild, got %d", got)
+	}
+}
+
+func TestTokenizedSearchMigrationReindexes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.db")
+	storage, err := data.NewStorage(path)
+	if err != nil {
+		t.Fatalf("open storage: %v", err)
+	}
+	if err := storage.SaveMessage(data.Message{Bot: "10001", User: "42", Content: "今天天气真好", Server: "mock", Timestamps: 1000}); err != nil {
+		t.Fatalf("save message: %v", err)
+	}
+	storage.Close()
+
+	legacy, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("open legacy database: %v", err)
+	}
+	for _, query := range []string{
+		`DROP TRIGGER trigger_message_insert`,
+		`CREATE TRIGGER trigger_message_insert AFTER INSERT ON Message BEGIN
+            INSERT INTO message_for_fts(rowid, plaintext) VALUES (NEW.id, NEW.plaintext); END`,
+		`UPDATE message_for_fts SET plaintext = '今天天气真好'`,
+		// Schema version 7 predates the tokenized search index migration.
+		"PRAGMA user_version = 7",
+	} {
+		if _, err := legacy.Exec(query); err != nil {
+			t.Fatalf("downgrade search index: %v", err)
+		}
+	}
+	legacy.Close()
+
+	storage, err = data.NewStorage(path)
+	if err != nil {
+		t.Fatalf("reopen storage: %v", err)
+	}
+	defer storage.Close()
+	if err := storage.SaveMessage(data.Message{Bot: "10001", User: "42", Content: "天气预报", Server: "mock", Timestamps: 2000}); err != nil {
+		t.Fatalf("save message: %v", err)
+	}
+	results, err := storage.Search(data.SearchQuery{Text: "天气"})
+	if err != nil || len(results) != 2 {
+		t.Fatalf("expected both messages after migration, got %d (%v)", len(results), err)
+	}
+}
+
+func TestPluginCallStats(t *testing.T) {
+	storage := openStorage(t)
+	now := time.Now()
+	group := "123"
+	user := "42"
+	failure := "ValueError"
+	for i := 1; i <= 20; i++ {
+		rec := data.PluginCallRecord{Server: "mock", Bot: "10001", PluginName: "weather", MatcherHash: "fast",
+			TimeCosted: float64(i) / 100, GroupID: &group, UserID: &user, Timestamp: now.Unix()}
+		if i > 18 {
+			rec.MatcherHash = "slow"
+			rec.TimeCosted = float64(i)
+			rec.ExceptionName = &failure
+		}
+		if err := storage.SavePluginCall(rec); err != nil {
+			t.Fatalf("save plugin call: %v", err)
+		}
+	}
+	for _, rec := range []data.PluginCallRecord{
+		{Server: "mock", Bot: "10001", PluginName: "echo", TimeCosted: 0.002, UserID: &user, Timestamp: now.Unix()},
+		{Server: "mock", Bot: "20002", PluginName: "echo", TimeCosted: 0.004, Timestamp: now.Unix()},
+		{Server: "mock", Bot: "10001", PluginName: "echo", TimeCosted: 9, Timestamp: now.Add(-48 * time.Hour).Unix()},
+	} {
+		if err := storage.SavePluginCall(rec); err != nil {
+			t.Fatalf("save plugin call: %v", err)
+		}
+	}
+
+	window := data.PluginCallFilter{Since: now.Add(-24 * time.Hour)}
+	stats, err := storage.PluginCallStats(window)
+	if err != nil {
+		t.Fatalf("plugin call stats: %v", err)
+	}
+	if len(stats) != 2 || stats[0].Plugin != "weather" || stats[1].Plugin != "echo" {
+		t.Fatalf("unexpected plugins %+v", stats)
+	}
+	weather := stats[0]
+	if weather.Calls != 20 || weather.Errors != 2 || weather.ErrorRate() != 0.1 {
+		t.Fatalf("unexpected weather counts %+v", weather)
+	}
+	if weather.P50 != 100*time.Millisecond || weather.P95 != 19*time.Second || weather.Max != 20*time.Second {
+		t.Fatalf("unexpected weather latency p50=%v p95=%v max=%v", weather.P50, weather.P95, weather.Max)
+	}
+	if stats[1].Calls != 2 || stats[1].Max != 4*time.Millisecond {
+		t.Fatalf("old calls should fall outside the window: %+v", stats[1])
+	}
+
+	matchers, err := storage.SlowestMatchers(window, 1)
+	if err != nil {
+		t.Fatalf("slowest matchers: %v", err)
+	}
+	if len(matchers) != 1 || matchers[0].MatcherHash != "slow" || matchers[0].ErrorRate() != 1 {
+		t.Fatalf("unexpected slowest matcher %+v", matchers)
+	}
+
+	bots, err := storage.PluginCallBreakdown(window, data.PluginCallByBot, 0)
+	if err != nil {
+		t.Fatalf("breakdown by bot: %v", err)
+	}
+	if len(bots) != 2 || bots[0].Value != "10001" || bots[0].Calls != 21 || bots[0].Errors != 2 {
+		t.Fatalf("unexpected bot breakdown %+v", bots)
+	}
+	window.Bot = "10001"
+	groups, err := storage.PluginCallBreakdown(window, data.PluginCallByGroup, 0)
+	if err != nil || len(groups) != 1 || groups[0].Value != group || groups[0].Calls != 20 {
+		t.Fatalf("unexpected group breakdown %+v (%v)", groups, err)
+	}
+	window.GroupID = group
+	stats, err = storage.PluginCallStats(window)
+	if err != nil || len(stats) != 1 || stats[0].Plugin != "weather" {
+		t.Fatalf("expected only group calls, got %+v (%v)", stats, err)
+	}
+	if _, err := storage.PluginCallBreakdown(window, "platform; DROP TABLE Message", 0); err == nil {
+		t.Fatalf("expected unknown dimension to be rejected")
+	}
+
+	if err := storage.SavePluginCall(data.PluginCallRecord{Server: "work", Bot: "10001", PluginName: "echo", TimeCosted: 0.001, Timestamp: now.Unix()}); err != nil {
+		t.Fatalf("save plugin call: %v", err)
+	}
+	servers, err := storage.PluginCallBreakdown(data.PluginCallFilter{}, data.PluginCallByServer, 0)
+	if err != nil || len(servers) != 2 || servers[0].Value != "mock" || servers[1].Value != "work" || servers[1].Calls != 1 {
+		t.Fatalf("unexpected server breakdown %+v (%v)", servers, err)
+	}
+	stats, err = storage.PluginCallStats(data.PluginCallFilter{Server: "work", Bot: "10001"})
+	if err != nil || len(stats) != 1 || stats[0].Plugin != "echo" || stats[0].Calls != 1 {
+		t.Fatalf("expected only calls from the work server, got %+v (%v)", stats, err)
+	}
+}
+
+func TestBotInfoKeyedByServer(t *testing.T) {
+	storage := openStorage(t)
+	for _, server := range []string{"home", "work"} {
+		if err := storage.SaveBotInfo(data.BotInfo{ID: "10001", Server: server, IsOnline: true}); err != nil {
+			t.Fatalf("save bot on %s: %v", server, err)
+		}
+	}
+	storage.MarkServerBotsOffline("home")
+	if err := storage.UpdateBotOnlineStatus(data.BotKey{Server: "work", ID: "10001"}, true); err != nil {
+		t.Fatalf("update bot status: %v", err)
+	}
+
+	bots, err := storage.GetBotInfoList()
+	if err != nil {
+		t.Fatalf("list bots: %v", err)
+	}
+	online := make(map[string]bool)
+	for _, bot := range bots {
+		online[bot.Server] = bot.IsOnline
+	}
+	if len(bots) != 2 || online["home"] || !online["work"] {
+		t.Fatalf("expected one bot per server with only work online, got %+v", bots)
+	}
+}
+
+func TestProfileRemembersLegacyServer(t *testing.T) {
+	storage := openStorage(t)
+	profile := data.ConnectionProfile{Name: "home", Host: "127.0.0.1", Port: 8080}
+	if err := storage.SaveConnectionProfile(profile); err != nil {
+		t.Fatalf("save profile: %v", err)
+	}
+	if err := storage.SetProfileLegacy("home", true); err != nil {
+		t.Fatalf("set legacy flag: %v", err)
+	}
+	profile.Token = "secret"
+	if err := storage.SaveConnectionProfile(profile); err != nil {
+		t.Fatalf("resave profile: %v", err)
+	}
+	stored, err := storage.GetConnectionProfile("home")
+	if err != nil {
+		t.Fatalf("get profile: %v", err)
+	}
+	if !stored.LegacyServer || !network.ProfileConnectOptions(*stored).Legacy {
+		t.Fatal("expected the legacy flag to survive a save for the same server")
+	}
+
+	profile.Port = 9090
+	if err := storage.SaveConnectionProfile(profile); err != nil {
+		t.Fatalf("save moved profile: %v", err)
+	}
+	if stored, err = storage.GetConnectionProfile("home"); err != nil || stored.LegacyServer {
+		t.Fatalf("expected pointing the profile at a new server to clear the flag, got %+v (%v)", stored, err)
+	}
+}
@@ -10,24 +10,21 @@ const (
 	NetworkStateConnected
 	NetworkStateDisconnected
 	NetworkStateConnecting
+	NetworkStateBackoff
+	NetworkStateGaveUp
 )
 type MobileNetworkManager struct {
 	mutex               sync.RWMutex
 	state               NetworkState
 	lastConnectionTime  time.Time
-	reconnectAttempts   int
-	maxReconnectAttempts int
-	reconnectDelay      time.Duration
 	onStateChange       func(NetworkState)
 	onReconnectNeeded   func()
 	backgroundReconnect bool
 }
 func NewMobileNetworkManager() *MobileNetworkManager {
 	return &MobileNetworkManager{
-		state:                NetworkStateUnknown,
-		maxReconnectAttempts: 5,
-		reconnectDelay:       5 * time.Second,
-		backgroundReconnect:  true,
+		state:               NetworkStateUnknown,
+		backgroundReconnect: true,
 	}
 }
 func (nm *MobileNetworkManager) SetStateChangeCallback(callback func(NetworkState)) {
@@ -51,7 +48,6 @@ func (nm *MobileNetworkManager) SetState(state NetworkState) {
 	nm.state = state
 	if state == NetworkStateConnected {
 		nm.lastConnectionTime = time.Now()
-		nm.reconnectAttempts = 0
 	}
 	nm.mutex.Unlock()
 	if oldState != state {
@@ -64,9 +60,6 @@ func (nm *MobileNetworkManager) SetState(state NetworkState) {
 }
 func (nm *MobileNetworkManager) OnConnectionLost() {
 	nm.SetState(NetworkStateDisconnected)
-	if IsMobile() {
-		go nm.handleReconnection()
-	}
 }
 func (nm *MobileNetworkManager) OnConnectionEstablished() {
 	nm.SetState(NetworkStateConnected)
@@ -74,42 +67,11 @@ func (nm *MobileNetworkManager) OnConnectionEstablished() {
 func (nm *MobileNetworkManager) OnConnectionAttempt() {
 	nm.SetState(NetworkStateConnecting)
 }
-func (nm *MobileNetworkManager) handleReconnection() {
-	nm.mutex.Lock()
-	if nm.reconnectAttempts >= nm.maxReconnectAttempts {
-		nm.mutex.Unlock()
-		log.Printf("[Mobile] Max reconnection attempts (%d) reached", nm.maxReconnectAttempts)
-		return
-	}
-	nm.reconnectAttempts++
-	attempts := nm.reconnectAttempts
-	delay := nm.getReconnectDelay()
-	nm.mutex.Unlock()
-	log.Printf("[Mobile] Scheduling reconnection attempt %d/%d in %v", 
-		attempts, nm.maxReconnectAttempts, delay)
-	time.Sleep(delay)
-	nm.mutex.RLock()
-	shouldReconnect := nm.state == NetworkStateDisconnected && 
-		nm.reconnectAttempts <= nm.maxReconnectAttempts
-	nm.mutex.RUnlock()
-	if shouldReconnect && nm.onReconnectNeeded != nil {
-		log.Printf("[Mobile] Triggering reconnection attempt %d", attempts)
-		nm.onReconnectNeeded()
-	}
-}
-func (nm *MobileNetworkManager) getReconnectDelay() time.Duration {
-	baseDelay := nm.reconnectDelay
-	multiplier := time.Duration(1 << uint(nm.reconnectAttempts-1))
-	delay := baseDelay * multiplier
-	if delay > 60*time.Second {
-		delay = 60 * time.Second
-	}
-	return delay
+func (nm *MobileNetworkManager) OnReconnectScheduled() {
+	nm.SetState(NetworkStateBackoff)
 }
-func (nm *MobileNetworkManager) ResetReconnectionAttempts() {
-	nm.mutex.Lock()
-	defer nm.mutex.Unlock()
-	nm.reconnectAttempts = 0
+func (nm *MobileNetworkManager) OnReconnectGaveUp() {
+	nm.SetState(NetworkStateGaveUp)
 }
 func (nm *MobileNetworkManager) ShouldReconnectInBackground() bool {
 	nm.mutex.RLock()
@@ -125,9 +87,7 @@ func (nm *MobileNetworkManager) GetConnectionInfo() map[string]interface{} {
 	nm.mutex.RLock()
 	defer nm.mutex.RUnlock()
 	info := map[string]interface{}{
-		"state":             nm.stateString(nm.state),
-		"reconnect_attempts": nm.reconnectAttempts,
-		"max_attempts":      nm.maxReconnectAttempts,
+		"state":                nm.stateString(nm.state),
 		"background_reconnect": nm.backgroundReconnect,
 	}
 	if !nm.lastConnectionTime.IsZero() {
@@ -144,6 +104,10 @@ func (nm *MobileNetworkManager) stateString(state NetworkState) string {
 		return "Disconnected"
 	case NetworkStateConnecting:
 		return "Connecting"
+	case NetworkStateBackoff:
+		return "Backoff"
+	case NetworkStateGaveUp:
+		return "GaveUp"
 	default:
 		return "Unknown"
 	}
@@ -153,22 +117,20 @@ func (nm *MobileNetworkManager) HandleAppBackground() {
 		return
 	}
 	log.Println("[Mobile] App went to background, adjusting network behavior")
-	nm.mutex.Lock()
-	nm.reconnectDelay = 30 * time.Second
-	nm.mutex.Unlock()
 }
 func (nm *MobileNetworkManager) HandleAppForeground() {
 	if !IsMobile() {
 		return
 	}
 	log.Println("[Mobile] App returned to foreground, adjusting network behavior")
-	nm.mutex.Lock()
-	nm.reconnectDelay = 5 * time.Second
-	nm.mutex.Unlock()
-	if nm.state == NetworkStateDisconnected {
+	nm.mutex.RLock()
+	state := nm.state
+	callback := nm.onReconnectNeeded
+	nm.mutex.RUnlock()
+	if state != NetworkStateConnected && state != NetworkStateConnecting {
 		log.Println("[Mobile] Triggering immediate reconnection check")
-		if nm.onReconnectNeeded != nil {
-			go nm.onReconnectNeeded()
+		if callback != nil {
+			go callback()
 		}
 	}
 }
\ No newline at end of file
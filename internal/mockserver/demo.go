@@ -0,0 +1,161 @@
+package mockserver
+
+import (
+	"time"
+
+	"lazytea-mobile/internal/network"
+	"lazytea-mobile/internal/protocol"
+	"lazytea-mobile/internal/utils"
+)
+
+const DemoProfileName = "演示服务端"
+
+func DemoFixtures() Fixtures {
+	return Fixtures{
+		Plugins: []protocol.PluginInfo{
+			{
+				Name:   "echo",
+				Module: "nonebot_plugin_echo",
+				Meta: protocol.PluginMeta{
+					Name:        "复读",
+					Description: "把收到的消息原样发回",
+					ConfigExist: true,
+					Author:      "LazyTea",
+					Version:     "0.1.0",
+				},
+			},
+			{
+				Name:   "weather",
+				Module: "nonebot_plugin_weather",
+				Meta: protocol.PluginMeta{
+					Name:        "天气",
+					Description: "查询城市天气",
+					Author:      "LazyTea",
+					Version:     "1.2.0",
+				},
+			},
+		},
+		Matchers: map[string]interface{}{
+			"bots": map[string]interface{}{
+				"10001": map[string]interface{}{
+					"plugins": map[string]interface{}{
+						"echo": map[string]interface{}{
+							"matchers": []interface{}{
+								map[string]interface{}{
+									"rule":  map[string]interface{}{"commands": []interface{}{"echo"}},
+									"is_on": true,
+									"permission": map[string]interface{}{
+										"white_list": map[string]interface{}{"user": []interface{}{}, "group": []interface{}{}},
+										"ban_list":   map[string]interface{}{"user": []interface{}{}, "group": []interface{}{}},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		PluginConfigs: map[string]PluginConfig{
+			"nonebot_plugin_echo": {
+				Schema: map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"echo_prefix": map[string]interface{}{
+							"type":        "string",
+							"title":       "复读前缀",
+							"description": "回复消息前添加的文字",
+						},
+					},
+				},
+				Data: map[string]interface{}{"echo_prefix": "🍵 "},
+			},
+		},
+		History: DemoHistory(),
+	}
+}
+
+func DemoHistory() []map[string]interface{} {
+	day := time.Now().Truncate(24 * time.Hour)
+	entries := []struct {
+		offset   time.Duration
+		bot      string
+		platform string
+		text     string
+		fromBot  bool
+	}{
+		{8 * time.Hour, "10001", "qq", "早上好", false},
+		{8*time.Hour + time.Second, "10001", "qq", "🍵 早上好", true},
+		{9 * time.Hour, "20002", "telegram", "/weather 上海", false},
+	}
+	history := make([]map[string]interface{}, 0, len(entries))
+	for _, entry := range entries {
+		message := demoMessage(entry.bot, entry.platform, entry.text, entry.fromBot)().(map[string]interface{})
+		message["time"] = float64(day.Add(entry.offset).Unix())
+		history = append(history, message)
+	}
+	return history
+}
+
+func DemoScript() []Event {
+	return []Event{
+		{Type: "bot_connect", Payload: map[string]interface{}{"bot": "10001", "adapter": "OneBot V11", "platform": "qq"}},
+		{After: 500 * time.Millisecond, Type: "bot_connect", Payload: map[string]interface{}{"bot": "20002", "adapter": "Telegram", "platform": "telegram"}},
+	}
+}
+
+func DemoRepeat() []Event {
+	return []Event{
+		{After: 3 * time.Second, Type: "message", Payload: demoMessage("10001", "qq", "echo 你好", false)},
+		{After: 200 * time.Millisecond, Type: "plugin_call", Payload: func() interface{} {
+			return map[string]interface{}{
+				"bot": "10001", "platform": "qq", "plugin": "echo", "matcher_hash": []interface{}{"demo-echo"},
+				"time_costed": 0.012, "time": nowSeconds(), "groupid": "123456", "userid": "42",
+			}
+		}},
+		{After: 300 * time.Millisecond, Type: "message", Payload: demoMessage("10001", "qq", "🍵 你好", true)},
+		{After: 4 * time.Second, Type: "message", Payload: demoMessage("20002", "telegram", "/weather 杭州", false)},
+		{After: 200 * time.Millisecond, Type: "plugin_call", Payload: func() interface{} {
+			return map[string]interface{}{
+				"bot": "20002", "platform": "telegram", "plugin": "weather", "matcher_hash": []interface{}{"demo-weather"},
+				"time_costed": 0.35, "time": nowSeconds(), "userid": "7",
+				"exception": map[string]interface{}{"name": "TimeoutError", "detail": "天气接口响应超时"},
+			}
+		}},
+	}
+}
+
+func StartDemo(logger *utils.Logger) (*Server, error) {
+	server := New(Options{
+		Name:     DemoProfileName,
+		Encoding: network.EncodingMsgPack,
+		Fixtures: DemoFixtures(),
+		Script:   DemoScript(),
+		Repeat:   DemoRepeat(),
+		Logger:   logger,
+	})
+	if err := server.Start(); err != nil {
+		return nil, err
+	}
+	return server, nil
+}
+
+func demoMessage(bot, platform, text string, fromBot bool) func() interface{} {
+	return func() interface{} {
+		return map[string]interface{}{
+			"bot":       bot,
+			"platform":  platform,
+			"content":   []interface{}{[]interface{}{"text", text}},
+			"from_bot":  fromBot,
+			"userid":    "42",
+			"username":  "演示用户",
+			"groupid":   "123456",
+			"groupname": "LazyTea 演示群",
+			"session":   "demo",
+			"time":      nowSeconds(),
+		}
+	}
+}
+
+func nowSeconds() float64 {
+	return float64(time.Now().UnixNano()) / 1e9
+}
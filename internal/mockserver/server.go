@@ -0,0 +1,652 @@
+package mockserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"lazytea-mobile/internal/network"
+	"lazytea-mobile/internal/protocol"
+	"lazytea-mobile/internal/utils"
+
+	"github.com/gorilla/websocket"
+)
+
+type Handler func(params map[string]interface{}) (interface{}, error)
+
+type Event struct {
+	After   time.Duration
+	Type    string
+	Payload interface{}
+}
+
+func (e Event) payload() interface{} {
+	if build, ok := e.Payload.(func() interface{}); ok {
+		return build()
+	}
+	return e.Payload
+}
+
+type Request struct {
+	Method string
+	Params map[string]interface{}
+	At     time.Time
+}
+
+type PluginConfig struct {
+	Schema map[string]interface{}
+	Data   map[string]interface{}
+}
+
+type Fixtures struct {
+	Plugins       []protocol.PluginInfo
+	Matchers      map[string]interface{}
+	PluginConfigs map[string]PluginConfig
+	History       []map[string]interface{}
+}
+
+type Options struct {
+	Addr         string
+	Name         string
+	Version      string
+	Token        string
+	Encoding     network.Encoding
+	Capabilities []string
+	Fixtures     Fixtures
+	Script       []Event
+	Repeat       []Event
+	// Greeting is sent as soon as a socket opens, before auth or handshake.
+	Greeting []Event
+	// DropHandshake closes the socket instead of answering the handshake.
+	DropHandshake bool
+	Logger        *utils.Logger
+}
+
+type Server struct {
+	options  Options
+	logger   *utils.Logger
+	upgrader websocket.Upgrader
+	listener net.Listener
+	http     *http.Server
+
+	mutex    sync.RWMutex
+	handlers map[string]Handler
+	conns    map[*conn]struct{}
+	requests []Request
+	plugins  []protocol.PluginInfo
+	matchers map[string]interface{}
+	configs  map[string]PluginConfig
+	history  []map[string]interface{}
+	offline  map[string]bool
+	silent   atomic.Bool
+}
+
+type conn struct {
+	ws         *websocket.Conn
+	writeMutex sync.Mutex
+	encoding   network.Encoding
+	authed     bool
+	done       chan struct{}
+}
+
+func New(options Options) *Server {
+	if options.Name == "" {
+		options.Name = "lazytea-mock"
+	}
+	if options.Version == "" {
+		options.Version = protocol.CurrentVersion
+	}
+	if options.Capabilities == nil {
+		options.Capabilities = append([]string(nil), protocol.ClientCapabilities...)
+	}
+	logger := options.Logger
+	if logger == nil {
+		logger = utils.NewLogger()
+	}
+	s := &Server{
+		options: options,
+		logger:  logger,
+		upgrader: websocket.Upgrader{
+			EnableCompression: true,
+			CheckOrigin:       func(r *http.Request) bool { return true },
+		},
+		handlers: make(map[string]Handler),
+		conns:    make(map[*conn]struct{}),
+		plugins:  append([]protocol.PluginInfo(nil), options.Fixtures.Plugins...),
+		matchers: copyMap(options.Fixtures.Matchers),
+		configs:  make(map[string]PluginConfig),
+		history:  append([]map[string]interface{}(nil), options.Fixtures.History...),
+		offline:  make(map[string]bool),
+	}
+	if s.matchers == nil {
+		s.matchers = map[string]interface{}{"bots": map[string]interface{}{}}
+	}
+	for module, config := range options.Fixtures.PluginConfigs {
+		s.configs[module] = PluginConfig{Schema: config.Schema, Data: copyMap(config.Data)}
+	}
+	s.handlers["get_plugins"] = s.getPlugins
+	s.handlers["get_matchers"] = s.getMatchers
+	s.handlers["get_plugin_config"] = s.getPluginConfig
+	s.handlers["save_env"] = s.saveEnv
+	s.handlers["sync_matchers"] = s.syncMatchers
+	s.handlers["bot_switch"] = s.botSwitch
+	s.handlers["get_bot_status"] = s.getBotStatus
+	s.handlers["get_history"] = s.getHistory
+	return s
+}
+
+func (s *Server) Start() error {
+	addr := s.options.Addr
+	if addr == "" {
+		addr = "127.0.0.1:0"
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/plugin_GUI", s.serveWS)
+	s.listener = listener
+	s.http = &http.Server{Handler: mux}
+	go s.http.Serve(listener)
+	s.logger.Info("模拟服务端已启动: %s", listener.Addr())
+	return nil
+}
+
+func (s *Server) Close() error {
+	s.DropConnections()
+	if s.http == nil {
+		return nil
+	}
+	return s.http.Close()
+}
+
+func (s *Server) Host() string {
+	host, _, _ := net.SplitHostPort(s.listener.Addr().String())
+	return host
+}
+
+func (s *Server) Port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *Server) ConnectOptions() network.ConnectOptions {
+	return network.ConnectOptions{
+		Host:     s.Host(),
+		Port:     s.Port(),
+		Token:    s.options.Token,
+		Encoding: s.options.Encoding,
+	}
+}
+
+func (s *Server) Handle(method string, handler Handler) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.handlers[method] = handler
+}
+
+func (s *Server) Requests(method string) []Request {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	var result []Request
+	for _, request := range s.requests {
+		if method == "" || request.Method == method {
+			result = append(result, request)
+		}
+	}
+	return result
+}
+
+func (s *Server) Matchers() map[string]interface{} {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return copyMap(s.matchers)
+}
+
+func (s *Server) Env(module string) map[string]interface{} {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return copyMap(s.configs[module].Data)
+}
+
+func (s *Server) Connections() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return len(s.conns)
+}
+
+// SetHeartbeats controls whether the server answers heartbeats and pings.
+// A silent server keeps the socket open, like a link that died quietly.
+func (s *Server) SetHeartbeats(answer bool) {
+	s.silent.Store(!answer)
+}
+
+// SetBotOnline changes a bot's state behind the client's back, like a
+// switch made from another device. Bots are online until switched off.
+func (s *Server) SetBotOnline(botID string, online bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.offline[botID] = !online
+}
+
+func (s *Server) DropConnections() {
+	s.mutex.RLock()
+	conns := make([]*conn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.mutex.RUnlock()
+	for _, c := range conns {
+		c.ws.Close()
+	}
+}
+
+func (s *Server) Emit(msgType string, payload interface{}) int {
+	s.mutex.RLock()
+	conns := make([]*conn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.mutex.RUnlock()
+	sent := 0
+	for _, c := range conns {
+		if err := c.send(eventHeader(msgType), payload); err != nil {
+			s.logger.Warn("模拟事件 %s 发送失败: %v", msgType, err)
+			continue
+		}
+		sent++
+	}
+	return sent
+}
+
+func (s *Server) serveWS(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimSpace(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer"))
+	if token == "" {
+		token = r.URL.Query().Get("token")
+	}
+	if s.options.Token != "" && token != "" && token != s.options.Token {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+	ws, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		s.logger.Error("模拟服务端升级连接失败: %v", err)
+		return
+	}
+	c := &conn{
+		ws:       ws,
+		encoding: network.EncodingJSON,
+		authed:   s.options.Token == "" || token != "",
+		done:     make(chan struct{}),
+	}
+	ws.SetPingHandler(func(data string) error {
+		if s.silent.Load() {
+			return nil
+		}
+		err := ws.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+		if err == websocket.ErrCloseSent {
+			return nil
+		}
+		return err
+	})
+	s.mutex.Lock()
+	s.conns[c] = struct{}{}
+	s.mutex.Unlock()
+	defer func() {
+		s.mutex.Lock()
+		delete(s.conns, c)
+		s.mutex.Unlock()
+		close(c.done)
+		ws.Close()
+	}()
+	if !c.playEvents(s.options.Greeting) {
+		return
+	}
+	decoder := network.NewStreamDecoder()
+	for {
+		messageType, raw, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		messages, errs := decoder.Feed(messageType, raw)
+		for _, err := range errs {
+			s.logger.Warn("模拟服务端解码失败: %v", err)
+		}
+		for _, message := range messages {
+			if !s.handleMessage(c, message.Header, message.Payload) {
+				return
+			}
+		}
+	}
+}
+
+func (s *Server) handleMessage(c *conn, header *network.MessageHeader, payload interface{}) bool {
+	switch header.MsgType {
+	case "auth":
+		var auth network.AuthPayload
+		network.DecodeData(payload, &auth)
+		if auth.Token != s.options.Token {
+			msg := "invalid token"
+			c.send(network.NewResponseHeader(network.NewMessageID("resp"), header.MsgID), network.ResponsePayload{Code: http.StatusUnauthorized, Error: &msg})
+			return false
+		}
+		c.authed = true
+		c.send(network.NewResponseHeader(network.NewMessageID("resp"), header.MsgID), network.ResponsePayload{Code: http.StatusOK, Time: time.Now().UnixMilli()})
+		return true
+	case "heartbeat":
+		if s.silent.Load() {
+			return true
+		}
+		correlationID := header.MsgID
+		c.send(network.MessageHeader{
+			MsgID:         network.NewMessageID("hb"),
+			MsgType:       "heartbeat_ack",
+			CorrelationID: &correlationID,
+			Timestamp:     float64(time.Now().UnixNano()) / 1e9,
+		}, network.HeartbeatPayload{Status: "alive"})
+		return true
+	case "request":
+	default:
+		return true
+	}
+	if !c.authed {
+		s.logger.Warn("模拟服务端收到未认证请求，断开连接")
+		return false
+	}
+	var request network.RequestPayload
+	if err := network.DecodeData(payload, &request); err != nil {
+		s.logger.Warn("模拟服务端请求无法解析: %v", err)
+		return true
+	}
+	s.mutex.Lock()
+	s.requests = append(s.requests, Request{Method: request.Method, Params: request.Params, At: time.Now()})
+	handler := s.handlers[request.Method]
+	s.mutex.Unlock()
+	responseHeader := network.NewResponseHeader(network.NewMessageID("resp"), header.MsgID)
+	if request.Method == "handshake" {
+		if s.options.DropHandshake {
+			return false
+		}
+		encoding := s.chooseEncoding(request.Params)
+		c.send(responseHeader, network.ResponsePayload{
+			Code: http.StatusOK,
+			Time: time.Now().UnixMilli(),
+			Data: protocol.ServerInfo{
+				Version:      s.options.Version,
+				Name:         s.options.Name,
+				Capabilities: s.options.Capabilities,
+				Encoding:     string(encoding),
+			},
+		})
+		c.writeMutex.Lock()
+		c.encoding = encoding
+		c.writeMutex.Unlock()
+		if len(s.options.Script) > 0 || len(s.options.Repeat) > 0 {
+			go s.play(c)
+		}
+		return true
+	}
+	response := network.ResponsePayload{Code: http.StatusOK, Time: time.Now().UnixMilli()}
+	if handler == nil {
+		msg := fmt.Sprintf("unknown method %s", request.Method)
+		response.Code = http.StatusNotFound
+		response.Error = &msg
+	} else if data, err := handler(request.Params); err != nil {
+		msg := err.Error()
+		response.Code = http.StatusInternalServerError
+		var serverErr *network.ServerError
+		if errors.As(err, &serverErr) {
+			response.Code = serverErr.Code
+			msg = serverErr.Message
+		}
+		response.Error = &msg
+	} else {
+		response.Data = data
+	}
+	if err := c.send(responseHeader, response); err != nil {
+		s.logger.Warn("模拟服务端响应 %s 失败: %v", request.Method, err)
+	}
+	return true
+}
+
+func (s *Server) chooseEncoding(params map[string]interface{}) network.Encoding {
+	if s.options.Encoding != network.EncodingMsgPack {
+		return network.EncodingJSON
+	}
+	offers, _ := params["encodings"].([]interface{})
+	for _, offer := range offers {
+		if offer == string(network.EncodingMsgPack) {
+			return network.EncodingMsgPack
+		}
+	}
+	return network.EncodingJSON
+}
+
+func (s *Server) play(c *conn) {
+	if !c.playEvents(s.options.Script) {
+		return
+	}
+	if len(s.options.Repeat) == 0 {
+		return
+	}
+	for c.playEvents(s.options.Repeat) {
+	}
+}
+
+func (c *conn) playEvents(events []Event) bool {
+	for _, event := range events {
+		select {
+		case <-c.done:
+			return false
+		case <-time.After(event.After):
+		}
+		if err := c.send(eventHeader(event.Type), event.payload()); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *conn) send(header network.MessageHeader, payload interface{}) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	messageType, frame, err := network.EncodeFrame(c.encoding, header, payload)
+	if err != nil {
+		return err
+	}
+	return c.ws.WriteMessage(messageType, frame)
+}
+
+func eventHeader(msgType string) network.MessageHeader {
+	return network.MessageHeader{
+		MsgID:     network.NewMessageID("evt"),
+		MsgType:   msgType,
+		Timestamp: float64(time.Now().UnixNano()) / 1e9,
+	}
+}
+
+func (s *Server) getPlugins(params map[string]interface{}) (interface{}, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return append([]protocol.PluginInfo(nil), s.plugins...), nil
+}
+
+func (s *Server) getMatchers(params map[string]interface{}) (interface{}, error) {
+	return s.Matchers(), nil
+}
+
+func (s *Server) getPluginConfig(params map[string]interface{}) (interface{}, error) {
+	name, _ := params["name"].(string)
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	module := name
+	for _, plugin := range s.plugins {
+		if plugin.Name == name && plugin.Module != "" {
+			module = plugin.Module
+		}
+	}
+	config, ok := s.configs[module]
+	if !ok {
+		return nil, &network.ServerError{Code: http.StatusNotFound, Message: fmt.Sprintf("plugin %s has no config", name)}
+	}
+	var raw bytes.Buffer
+	encoder := json.NewEncoder(&raw)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(config.Data); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"schema": config.Schema,
+		"data":   strings.TrimSpace(raw.String()),
+	}, nil
+}
+
+func (s *Server) getHistory(params map[string]interface{}) (interface{}, error) {
+	since := int64(numberParam(params["since"]))
+	limit := int(numberParam(params["limit"]))
+	if limit <= 0 {
+		limit = 100
+	}
+	offset := 0
+	if cursor, _ := params["cursor"].(string); cursor != "" {
+		value, err := strconv.Atoi(cursor)
+		if err != nil || value < 0 {
+			return nil, &network.ServerError{Code: http.StatusBadRequest, Message: fmt.Sprintf("invalid cursor %q", cursor)}
+		}
+		offset = value
+	}
+	s.mutex.RLock()
+	var matched []map[string]interface{}
+	for _, message := range s.history {
+		if protocol.Timestamp(numberParam(message["time"])).UnixMilli() >= since {
+			matched = append(matched, message)
+		}
+	}
+	s.mutex.RUnlock()
+	sort.SliceStable(matched, func(i, j int) bool {
+		return numberParam(matched[i]["time"]) < numberParam(matched[j]["time"])
+	})
+	if offset > len(matched) {
+		offset = len(matched)
+	}
+	end := offset + limit
+	if end > len(matched) {
+		end = len(matched)
+	}
+	page := map[string]interface{}{
+		"messages": matched[offset:end],
+		"has_more": end < len(matched),
+	}
+	if end < len(matched) {
+		page["next_cursor"] = strconv.Itoa(end)
+	}
+	return page, nil
+}
+
+func (s *Server) saveEnv(params map[string]interface{}) (interface{}, error) {
+	module, _ := params["module_name"].(string)
+	values, ok := params["data"].(map[string]interface{})
+	if module == "" || !ok {
+		return nil, &network.ServerError{Code: http.StatusBadRequest, Message: "module_name and data are required"}
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	config := s.configs[module]
+	if config.Data == nil {
+		config.Data = make(map[string]interface{})
+	}
+	for key, value := range values {
+		config.Data[key] = value
+	}
+	s.configs[module] = config
+	return nil, nil
+}
+
+func (s *Server) syncMatchers(params map[string]interface{}) (interface{}, error) {
+	raw, _ := params["new_roster"].(string)
+	var roster map[string]interface{}
+	if err := json.Unmarshal([]byte(raw), &roster); err != nil {
+		return nil, &network.ServerError{Code: http.StatusBadRequest, Message: fmt.Sprintf("invalid new_roster: %v", err)}
+	}
+	s.mutex.Lock()
+	s.matchers = roster
+	s.mutex.Unlock()
+	return nil, nil
+}
+
+func (s *Server) botSwitch(params map[string]interface{}) (interface{}, error) {
+	botID := fmt.Sprint(params["bot_id"])
+	online, ok := params["is_online_now"].(bool)
+	if params["bot_id"] == nil || !ok {
+		return nil, &network.ServerError{Code: http.StatusBadRequest, Message: "bot_id and is_online_now are required"}
+	}
+	s.SetBotOnline(botID, online)
+	return nil, nil
+}
+
+func (s *Server) getBotStatus(params map[string]interface{}) (interface{}, error) {
+	if params["bot_id"] == nil {
+		return nil, &network.ServerError{Code: http.StatusBadRequest, Message: "bot_id is required"}
+	}
+	botID := fmt.Sprint(params["bot_id"])
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return map[string]interface{}{
+		"bot_id":    botID,
+		"platform":  params["platform"],
+		"is_online": !s.offline[botID],
+	}, nil
+}
+
+func copyMap(source map[string]interface{}) map[string]interface{} {
+	if source == nil {
+		return nil
+	}
+	raw, err := json.Marshal(source)
+	if err != nil {
+		return nil
+	}
+	var copied map[string]interface{}
+	json.Unmarshal(raw, &copied)
+	return copied
+}
+
+func numberParam(value interface{}) float64 {
+	switch v := value.(type) {
+	case float64:
+		return v
+	case float32:
+		return float64(v)
+	case int:
+		return float64(v)
+	case int8:
+		return float64(v)
+	case int16:
+		return float64(v)
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case uint8:
+		return float64(v)
+	case uint16:
+		return float64(v)
+	case uint32:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	case string:
+		number, _ := strconv.ParseFloat(v, 64)
+		return number
+	}
+	return 0
+}
@@ -0,0 +1,142 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type AuthMode string
+
+const (
+	AuthModeAuto   AuthMode = "auto"
+	AuthModeHeader AuthMode = "header"
+	AuthModeFrame  AuthMode = "frame"
+	AuthModeQuery  AuthMode = "query"
+)
+
+const authFrameTimeout = 10 * time.Second
+
+// rawFrame is a frame read off the socket before messageLoop took over.
+type rawFrame struct {
+	messageType int
+	data        []byte
+}
+
+type AuthPayload struct {
+	Token string `json:"token"`
+}
+
+func (m AuthMode) normalize() AuthMode {
+	switch m {
+	case AuthModeHeader, AuthModeFrame, AuthModeQuery:
+		return m
+	default:
+		return AuthModeAuto
+	}
+}
+
+func (m AuthMode) candidates() []AuthMode {
+	if m == AuthModeAuto {
+		return []AuthMode{AuthModeHeader, AuthModeQuery}
+	}
+	return []AuthMode{m}
+}
+
+func (c *Client) dial(dialer *websocket.Dialer, u url.URL, options ConnectOptions) (*websocket.Conn, *http.Response, AuthMode, []rawFrame, error) {
+	modes := options.Auth.normalize().candidates()
+	if cached := c.negotiatedAuth; options.Auth.normalize() == AuthModeAuto && cached != "" {
+		ordered := []AuthMode{cached}
+		for _, mode := range modes {
+			if mode != cached {
+				ordered = append(ordered, mode)
+			}
+		}
+		modes = ordered
+	}
+	var lastErr error
+	for i, mode := range modes {
+		target := u
+		header := http.Header{}
+		switch mode {
+		case AuthModeHeader:
+			header.Set("Authorization", "Bearer "+options.Token)
+		case AuthModeQuery:
+			query := target.Query()
+			query.Set("token", options.Token)
+			target.RawQuery = query.Encode()
+		}
+		conn, resp, err := dialer.Dial(target.String(), header)
+		if err != nil {
+			lastErr = describeDialError(err, resp)
+			if i < len(modes)-1 && isAuthRejected(err, resp) {
+				c.logger.Warn("认证方式 %s 被拒绝，回退到下一种方式", mode)
+				continue
+			}
+			return nil, nil, "", nil, lastErr
+		}
+		var early []rawFrame
+		if mode == AuthModeFrame {
+			if early, err = sendAuthFrame(conn, options.Token); err != nil {
+				conn.Close()
+				return nil, nil, "", nil, err
+			}
+		}
+		return conn, resp, mode, early, nil
+	}
+	return nil, nil, "", nil, lastErr
+}
+
+func isAuthRejected(err error, resp *http.Response) bool {
+	if !errors.Is(err, websocket.ErrBadHandshake) || resp == nil {
+		return false
+	}
+	return resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden
+}
+
+// sendAuthFrame authenticates over the socket and returns any frames the
+// server sent ahead of the auth response so messageLoop can deliver them.
+func sendAuthFrame(conn *websocket.Conn, token string) ([]rawFrame, error) {
+	msgID := NewMessageID("auth")
+	header := MessageHeader{
+		MsgID:     msgID,
+		MsgType:   "auth",
+		Timestamp: float64(time.Now().UnixNano()) / 1e9,
+	}
+	message, err := EncodeMessage(header, AuthPayload{Token: token})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode auth frame: %w", err)
+	}
+	conn.SetWriteDeadline(time.Now().Add(authFrameTimeout))
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(message)); err != nil {
+		return nil, fmt.Errorf("发送认证帧失败: %w", err)
+	}
+	conn.SetWriteDeadline(time.Time{})
+	conn.SetReadDeadline(time.Now().Add(authFrameTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+	var early []rawFrame
+	for {
+		messageType, raw, err := conn.ReadMessage()
+		if err != nil {
+			return nil, fmt.Errorf("等待认证结果失败: %w", err)
+		}
+		respHeader, payload, err := DecodeFrame(messageType, raw)
+		if err != nil || respHeader.MsgType != "response" || respHeader.CorrelationID == nil || *respHeader.CorrelationID != msgID {
+			early = append(early, rawFrame{messageType: messageType, data: raw})
+			continue
+		}
+		if payloadMap, ok := payload.(map[string]interface{}); ok {
+			if errMsg, hasError := payloadMap["error"]; hasError && errMsg != nil {
+				return nil, fmt.Errorf("认证失败: %v", errMsg)
+			}
+			if code, ok := payloadMap["code"].(float64); ok && code != 0 && code != http.StatusOK {
+				return nil, fmt.Errorf("认证失败: code %d", int(code))
+			}
+		}
+		return early, nil
+	}
+}
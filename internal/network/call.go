@@ -0,0 +1,177 @@
+package network
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrNotConnected = errors.New("not connected")
+
+type ServerError struct {
+	Code    int
+	Message string
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("server error: %s", e.Message)
+}
+
+type callResult struct {
+	payload interface{}
+	err     error
+}
+
+type pendingCall struct {
+	id         string
+	method     string
+	params     map[string]interface{}
+	idempotent bool
+	resend     bool
+	started    time.Time
+	result     chan callResult
+}
+
+func (c *Client) Call(ctx context.Context, method string, params map[string]interface{}) (*ResponsePayload, error) {
+	call, err := c.startCall(method, params)
+	if err != nil {
+		return nil, err
+	}
+	result := c.awaitCall(ctx, call)
+	if result.err != nil {
+		return nil, result.err
+	}
+	return decodeResponse(result.payload)
+}
+
+func CallAs[T any](ctx context.Context, c *Client, method string, params map[string]interface{}) (T, error) {
+	var value T
+	response, err := c.Call(ctx, method, params)
+	if err != nil {
+		return value, err
+	}
+	if err := DecodeData(response.Data, &value); err != nil {
+		return value, fmt.Errorf("failed to decode %s response: %w", method, err)
+	}
+	return value, nil
+}
+
+func DecodeData(data interface{}, target interface{}) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, target)
+}
+
+func decodeResponse(payload interface{}) (*ResponsePayload, error) {
+	var response ResponsePayload
+	if err := DecodeData(payload, &response); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &response, nil
+}
+
+func (c *Client) startCall(method string, params map[string]interface{}) (*pendingCall, error) {
+	if !c.IsConnected() {
+		return nil, ErrNotConnected
+	}
+	call := &pendingCall{
+		id:      NewMessageID("req"),
+		method:  method,
+		params:  params,
+		started: time.Now(),
+		result:  make(chan callResult, 1),
+	}
+	c.stats.begin(method)
+	c.requestMutex.Lock()
+	call.idempotent = c.idempotent[method]
+	c.pendingRequests[call.id] = call
+	c.requestMutex.Unlock()
+	c.logger.Debug("Sending request: %s (%s)", method, call.id)
+	if err := c.sendMessage(NewRequestHeader(call.id, nil), RequestPayload{Method: method, Params: params}); err != nil {
+		c.releasePending(call.id)
+		c.stats.finish(method, time.Since(call.started), err, false)
+		return nil, err
+	}
+	return call, nil
+}
+
+func (c *Client) awaitCall(ctx context.Context, call *pendingCall) callResult {
+	select {
+	case result := <-call.result:
+		c.stats.finish(call.method, time.Since(call.started), result.err, false)
+		return result
+	case <-ctx.Done():
+		c.releasePending(call.id)
+		timedOut := errors.Is(ctx.Err(), context.DeadlineExceeded)
+		c.stats.finish(call.method, time.Since(call.started), ctx.Err(), timedOut)
+		if timedOut {
+			return callResult{err: fmt.Errorf("request %s (%s) timed out: %w", call.method, call.id, ctx.Err())}
+		}
+		return callResult{err: ctx.Err()}
+	}
+}
+
+func (c *Client) releasePending(msgID string) {
+	c.requestMutex.Lock()
+	delete(c.pendingRequests, msgID)
+	c.requestMutex.Unlock()
+}
+
+func (c *Client) failPending(err error, keepIdempotent bool) {
+	c.requestMutex.Lock()
+	failed := make([]*pendingCall, 0, len(c.pendingRequests))
+	for id, call := range c.pendingRequests {
+		if keepIdempotent && call.idempotent {
+			call.resend = true
+			continue
+		}
+		failed = append(failed, call)
+		delete(c.pendingRequests, id)
+	}
+	c.requestMutex.Unlock()
+	for _, call := range failed {
+		call.result <- callResult{err: err}
+	}
+}
+
+func (c *Client) resendPending() {
+	c.requestMutex.Lock()
+	calls := make([]*pendingCall, 0)
+	for _, call := range c.pendingRequests {
+		if call.resend {
+			call.resend = false
+			calls = append(calls, call)
+		}
+	}
+	c.requestMutex.Unlock()
+	for _, call := range calls {
+		c.logger.Info("重连后重新发送请求: %s (%s)", call.method, call.id)
+		if err := c.sendMessage(NewRequestHeader(call.id, nil), RequestPayload{Method: call.method, Params: call.params}); err != nil {
+			c.logger.Error("重新发送请求失败: %v", err)
+		}
+	}
+}
+
+func (c *Client) InFlight() int {
+	c.requestMutex.RLock()
+	defer c.requestMutex.RUnlock()
+	return len(c.pendingRequests)
+}
+
+func (c *Client) InFlightByMethod() map[string]int {
+	c.requestMutex.RLock()
+	defer c.requestMutex.RUnlock()
+	counts := make(map[string]int)
+	for _, call := range c.pendingRequests {
+		counts[call.method]++
+	}
+	return counts
+}
+
+func (c *Client) RequestStats() []MethodStats {
+	return c.stats.snapshot()
+}
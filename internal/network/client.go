@@ -1,9 +1,14 @@
 package network
 import (
+	"context"
 	"fmt"
+	"net"
+	"net/http"
 	"net/url"
+	"strconv"
 	"sync"
 	"time"
+	"lazytea-mobile/internal/protocol"
 	"lazytea-mobile/internal/utils"
 	"github.com/gorilla/websocket"
 )
@@ -19,74 +24,161 @@ type Client struct {
 	mutex      sync.RWMutex
 	writeMutex sync.Mutex  
 	logger     *utils.Logger
-	host       string
-	port       int
-	token      string
-	connectionCallbacks []ConnectionCallback
-	messageCallbacks    map[string][]MessageCallback
-	pendingRequests map[string]*RequestCallback
+	server         string
+	options        ConnectOptions
+	negotiatedAuth AuthMode
+	serverInfo     *protocol.ServerInfo
+	legacyServer   bool
+	announced      bool
+	encoding       Encoding
+	compressed     bool
+	connectionCallbacks []connectionSubscription
+	nextCallbackID      uint64
+	dispatcher          *dispatcher
+	pendingRequests map[string]*pendingCall
+	stats           *requestStats
 	requestMutex    sync.RWMutex
 	stopCh chan struct{}
 	doneCh chan struct{}
-	lastPongTime             time.Time
-	reconnectEnabled         bool           
-	reconnectInterval        time.Duration  
-	maxReconnectAttempts     int            
-	currentReconnectAttempts int            
+	liveness     *liveness
+	reconnect    *reconnector
+	idempotent   map[string]bool
+	outbox       *Outbox
+	recorder     *Recorder
+	history      *HistorySync
 }
 func NewClient(logger *utils.Logger) *Client {
 	return &Client{
 		logger:              logger,
-		messageCallbacks:    make(map[string][]MessageCallback),
-		connectionCallbacks: make([]ConnectionCallback, 0),
-		pendingRequests:     make(map[string]*RequestCallback),
+		dispatcher:          newDispatcher(),
+		connectionCallbacks: make([]connectionSubscription, 0),
+		pendingRequests:     make(map[string]*pendingCall),
+		stats:               newRequestStats(),
 		stopCh:              make(chan struct{}),
 		doneCh:              make(chan struct{}),
-		reconnectEnabled:         true,
-		reconnectInterval:        5 * time.Second,  
-		maxReconnectAttempts:     10,               
-		currentReconnectAttempts: 0,
+		liveness:            newLiveness(),
+		reconnect:           newReconnector(),
+		idempotent: map[string]bool{
+			"get_plugins":       true,
+			"get_matchers":      true,
+			"get_plugin_config": true,
+			"get_history":       true,
+		},
 	}
 }
 func (c *Client) Connect(host string, port int, token string) error {
+	return c.ConnectWithOptions(ConnectOptions{Host: host, Port: port, Token: token})
+}
+func (c *Client) ConnectWithOptions(options ConnectOptions) error {
+	c.stopReconnect()
+	if err := c.connect(options, 0); err != nil {
+		c.stopReconnect()
+		c.failPending(err, false)
+		c.setState(StateChange{State: StateIdle, Err: err})
+		return err
+	}
+	return nil
+}
+func (c *Client) connect(options ConnectOptions, attempt int) error {
+	c.setState(StateChange{State: StateConnecting, Attempt: attempt})
+	if err := c.open(options); err != nil {
+		return err
+	}
+	info, err := c.handshake()
+	if err != nil {
+		c.logger.Error("协议握手失败: %v", err)
+		if doneCh, ok, _ := c.closeConnection(); ok {
+			<-doneCh
+		}
+		return err
+	}
+	c.mutex.Lock()
+	c.serverInfo = info
+	if options.Encoding.accepts(info.Encoding) {
+		c.encoding = Encoding(info.Encoding)
+	}
+	transport := TransportInfo{Encoding: c.encoding, Compression: c.compressed}
+	c.mutex.Unlock()
+	c.resetAttempts()
+	c.logger.Info("连接成功 (协议 %s, 编码 %s, 压缩 %v)", info.Version, transport.Encoding, transport.Compression)
+	c.setState(StateChange{State: StateConnected, Attempt: attempt})
+	c.mutex.Lock()
+	c.announced = true
+	c.notifyConnectionChange(true)
+	c.mutex.Unlock()
+	c.resendPending()
+	go c.replayOutbox()
+	go c.syncHistory()
+	return nil
+}
+func (c *Client) open(options ConnectOptions) error {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 	if c.connected {
 		return fmt.Errorf("already connected")
 	}
-	c.host = host
-	c.port = port
-	c.token = token
-	c.currentReconnectAttempts = 0  
+	if options.Host != c.options.Host || options.Port != c.options.Port || options.Auth != c.options.Auth {
+		c.negotiatedAuth = ""
+		c.legacyServer = false
+	}
+	if options.Legacy {
+		c.legacyServer = true
+	}
+	c.options = options
+	dialer := &websocket.Dialer{
+		Proxy:             http.ProxyFromEnvironment,
+		HandshakeTimeout:  15 * time.Second,
+		EnableCompression: options.Compression,
+	}
+	if options.TLS.Enabled {
+		tlsConfig, err := options.TLS.buildConfig(options.Host)
+		if err != nil {
+			return err
+		}
+		dialer.TLSClientConfig = tlsConfig
+	}
 	u := url.URL{
-		Scheme: "ws",
-		Host:   fmt.Sprintf("%s:%d", host, port),
+		Scheme: options.scheme(),
+		Host:   net.JoinHostPort(options.Host, strconv.Itoa(options.Port)),
 		Path:   "/plugin_GUI",
 	}
-	query := u.Query()
-	query.Add("token", token)
-	u.RawQuery = query.Encode()
 	c.logger.Info("正在连接到: %s", u.String())
-	conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	conn, resp, authMode, early, err := c.dial(dialer, u, options)
 	if err != nil {
-		return fmt.Errorf("连接失败: %w", err)
+		return err
 	}
+	c.negotiatedAuth = authMode
+	c.logger.Info("认证方式: %s", authMode)
 	c.conn = conn
 	c.connected = true
-	c.lastPongTime = time.Now()
+	c.serverInfo = nil
+	c.encoding = EncodingJSON
+	c.compressed = options.Compression && deflateNegotiated(resp)
+	c.liveness.reset()
+	conn.SetPongHandler(c.handlePong)
+	if c.history != nil {
+		c.history.mark(c.server)
+	}
 	c.stopCh = make(chan struct{})
 	c.doneCh = make(chan struct{})
-	go c.messageLoop()
-	go c.heartbeatLoop()
-	c.logger.Info("连接成功")
-	c.notifyConnectionChange(true)
+	go c.messageLoop(c.stopCh, c.doneCh, early)
+	go c.heartbeatLoop(c.stopCh)
 	return nil
 }
 func (c *Client) Disconnect() {
+	c.stopReconnect()
+	doneCh, wasConnected, _ := c.closeConnection()
+	c.failPending(fmt.Errorf("client is shutting down"), false)
+	if wasConnected {
+		<-doneCh
+	}
+	c.setState(StateChange{State: StateIdle})
+}
+func (c *Client) closeConnection() (chan struct{}, bool, bool) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 	if !c.connected {
-		return
+		return nil, false, false
 	}
 	close(c.stopCh)
 	if c.conn != nil {
@@ -95,16 +187,27 @@ func (c *Client) Disconnect() {
 	}
 	c.connected = false
 	c.logger.Info("连接已断开")
-	c.notifyConnectionChange(false)
-	c.requestMutex.Lock()
-	for msgID, callback := range c.pendingRequests {
-		if callback.Error != nil {
-			callback.Error(fmt.Errorf("client is shutting down"))
-		}
-		delete(c.pendingRequests, msgID)
+	announced := c.announced
+	if announced {
+		c.announced = false
+		c.notifyConnectionChange(false)
 	}
-	c.requestMutex.Unlock()
-	<-c.doneCh
+	return c.doneCh, true, announced
+}
+func (c *Client) SetServerName(name string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.server = name
+}
+func (c *Client) ServerName() string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.server
+}
+func (c *Client) AuthMode() AuthMode {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.negotiatedAuth
 }
 func (c *Client) IsConnected() bool {
 	c.mutex.RLock()
@@ -118,41 +221,39 @@ func (c *Client) SendRequestWithCallback(method string, params map[string]interf
 	return c.SendRequestWithCallbackTimeout(method, params, callback, 3*time.Second)
 }
 func (c *Client) SendRequestWithCallbackTimeout(method string, params map[string]interface{}, callback *RequestCallback, timeout time.Duration) error {
-	if !c.IsConnected() {
-		return fmt.Errorf("not connected")
+	if callback == nil {
+		if !c.IsConnected() {
+			return fmt.Errorf("not connected")
+		}
+		msgID := NewMessageID("req")
+		c.logger.Debug("Sending request: %s", method)
+		return c.sendMessage(NewRequestHeader(msgID, nil), RequestPayload{Method: method, Params: params})
 	}
-	msgID := fmt.Sprintf("req-%d", time.Now().UnixNano())
-	header := NewRequestHeader(msgID, nil)
-	payload := RequestPayload{
-		Method: method,
-		Params: params,
-	}
-	if callback != nil {
-		c.requestMutex.Lock()
-		c.pendingRequests[msgID] = callback
-		c.requestMutex.Unlock()
-		go func() {
-			time.Sleep(timeout)
-			c.requestMutex.Lock()
-			if cb, exists := c.pendingRequests[msgID]; exists {
-				delete(c.pendingRequests, msgID)
-				c.requestMutex.Unlock()
-				if cb.Error != nil {
-					cb.Error(fmt.Errorf("request timeout for %s", msgID))
-				}
-			} else {
-				c.requestMutex.Unlock()
-			}
-		}()
+	call, err := c.startCall(method, params)
+	if err != nil {
+		return err
 	}
-	c.logger.Debug("Sending request: %s", method)
-	return c.sendMessage(header, payload)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		result := c.awaitCall(ctx, call)
+		if result.err != nil {
+			if callback.Error != nil {
+				callback.Error(result.err)
+			}
+			return
+		}
+		if callback.Success != nil {
+			callback.Success(result.payload)
+		}
+	}()
+	return nil
 }
 func (c *Client) SendResponse(correlationID string, code int, data interface{}, errMsg *string) error {
 	if !c.IsConnected() {
 		return fmt.Errorf("not connected")
 	}
-	msgID := fmt.Sprintf("resp-%d", time.Now().UnixNano())
+	msgID := NewMessageID("resp")
 	header := NewResponseHeader(msgID, correlationID)
 	payload := ResponsePayload{
 		Code:  code,
@@ -165,29 +266,39 @@ func (c *Client) SendResponse(correlationID string, code int, data interface{},
 func (c *Client) sendMessage(header MessageHeader, payload interface{}) error {
 	c.mutex.RLock()
 	conn := c.conn
+	encoding := c.encoding
+	recorder := c.recorder
+	header.Server = c.server
 	c.mutex.RUnlock()
 	if conn == nil {
 		return fmt.Errorf("connection is nil")
 	}
-	message, err := EncodeMessage(header, payload)
+	messageType, message, err := EncodeFrame(encoding, header, payload)
 	if err != nil {
 		return fmt.Errorf("failed to encode message: %w", err)
 	}
 	c.writeMutex.Lock()
 	defer c.writeMutex.Unlock()
-	if err := conn.WriteMessage(websocket.TextMessage, []byte(message)); err != nil {
+	if err := conn.WriteMessage(messageType, message); err != nil {
 		return fmt.Errorf("failed to send message: %w", err)
 	}
+	if recorder != nil {
+		recorder.record(RecordOutbound, header, payload)
+	}
 	c.logger.Debug("发送消息: %s", header.MsgType)
 	return nil
 }
-func (c *Client) messageLoop() {
+func (c *Client) messageLoop(stopCh <-chan struct{}, doneCh chan struct{}, early []rawFrame) {
 	defer func() {
-		close(c.doneCh)
+		close(doneCh)
 	}()
+	decoder := NewStreamDecoder()
+	for _, frame := range early {
+		c.deliverFrame(decoder, frame.messageType, frame.data)
+	}
 	for {
 		select {
-		case <-c.stopCh:
+		case <-stopCh:
 			return
 		default:
 			c.mutex.RLock()
@@ -196,7 +307,7 @@ func (c *Client) messageLoop() {
 			if conn == nil {
 				return
 			}
-			_, message, err := conn.ReadMessage()
+			messageType, message, err := conn.ReadMessage()
 			if err != nil {
 				if websocket.IsCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
 					c.logger.Info("连接正常关闭")
@@ -206,135 +317,125 @@ func (c *Client) messageLoop() {
 				c.handleDisconnection()
 				return
 			}
-			c.handleMessage(string(message))
+			c.deliverFrame(decoder, messageType, message)
 		}
 	}
 }
-func (c *Client) handleMessage(rawData string) {
-	header, payload, err := DecodeMessage(rawData)
-	if err != nil {
+func (c *Client) deliverFrame(decoder *StreamDecoder, messageType int, message []byte) {
+	c.deliverMessages(decoder, messageType, message, false)
+}
+// deliverMessages decodes a frame and hands each message to handleMessage,
+// returning how many reached subscribers. Replayed messages are marked
+// instead of recorded again.
+func (c *Client) deliverMessages(decoder *StreamDecoder, messageType int, message []byte, replayed bool) int {
+	messages, errs := decoder.Feed(messageType, message)
+	for _, err := range errs {
 		c.logger.Error("解码消息失败: %v", err)
-		return
 	}
+	dispatched := 0
+	for _, decoded := range messages {
+		if replayed {
+			decoded.Header.Replayed = true
+		} else {
+			c.recordMessage(RecordInbound, *decoded.Header, decoded.Payload)
+		}
+		if c.handleMessage(decoded.Header, decoded.Payload) {
+			dispatched++
+		}
+	}
+	return dispatched
+}
+func (c *Client) handleMessage(header *MessageHeader, payload interface{}) bool {
 	c.logger.Debug("收到消息: %s", header.MsgType)
+	header.Server = c.ServerName()
+	if c.handleHeartbeatAck(header) {
+		return false
+	}
 	if header.MsgType == "response" && header.CorrelationID != nil {
+		if header.Replayed {
+			// 录制会话的响应不属于当前任何等待中的请求
+			return false
+		}
 		c.handleResponse(*header.CorrelationID, payload)
-		return
-	}
-	c.mutex.RLock()
-	callbacks := c.messageCallbacks[header.MsgType]
-	c.mutex.RUnlock()
-	for _, callback := range callbacks {
-		go callback(*header, payload)
+		return false
 	}
+	c.dispatcher.dispatch(*header, payload)
+	return true
 }
 func (c *Client) handleResponse(msgID string, payload interface{}) {
 	c.requestMutex.Lock()
-	callback, exists := c.pendingRequests[msgID]
+	call, exists := c.pendingRequests[msgID]
 	if exists {
 		delete(c.pendingRequests, msgID)
 	}
 	c.requestMutex.Unlock()
-	if exists && callback != nil {
-		if payloadMap, ok := payload.(map[string]interface{}); ok {
-			if errMsg, hasError := payloadMap["error"]; hasError && errMsg != nil && errMsg != "<nil>" {
-				if callback.Error != nil {
-					callback.Error(fmt.Errorf("server error: %v", errMsg))
-				}
-			} else if callback.Success != nil {
-				callback.Success(payload)
-			}
-		} else if callback.Success != nil {
-			callback.Success(payload)
-		}
+	if !exists {
+		c.logger.Debug("收到未知请求的响应: %s", msgID)
+		return
 	}
-}
-func (c *Client) heartbeatLoop() {
-	ticker := time.NewTicker(30 * time.Second)
-	defer ticker.Stop()
-	for {
-		select {
-		case <-c.stopCh:
-			return
-		case <-ticker.C:
-			if !c.IsConnected() {
-				return
-			}
-			msgID := fmt.Sprintf("hb-%d", time.Now().UnixNano())
-			header := MessageHeader{
-				MsgID:     msgID,
-				MsgType:   "heartbeat",
-				Timestamp: float64(time.Now().UnixNano()) / 1e9,
-			}
-			payload := map[string]interface{}{"status": "alive"}
-			if err := c.sendMessage(header, payload); err != nil {
-				c.logger.Error("发送心跳失败: %v", err)
-				c.handleDisconnection()
-				return
-			}
+	result := callResult{payload: payload}
+	if payloadMap, ok := payload.(map[string]interface{}); ok {
+		if errMsg, hasError := payloadMap["error"]; hasError && errMsg != nil && errMsg != "<nil>" {
+			code, _ := payloadMap["code"].(float64)
+			result.err = &ServerError{Code: int(code), Message: fmt.Sprintf("%v", errMsg)}
 		}
 	}
+	call.result <- result
 }
 func (c *Client) handleDisconnection() {
-	c.mutex.Lock()
-	wasConnected := c.connected
-	if c.connected {
-		c.connected = false
-		if c.conn != nil {
-			c.conn.Close()
-			c.conn = nil
-		}
-		c.logger.Info("连接已断开")
-		c.notifyConnectionChange(false)
-	}
-	c.mutex.Unlock()
-	c.requestMutex.Lock()
-	for msgID, callback := range c.pendingRequests {
-		if callback.Error != nil {
-			callback.Error(fmt.Errorf("connection lost"))
-		}
-		delete(c.pendingRequests, msgID)
+	_, wasConnected, announced := c.closeConnection()
+	if !wasConnected {
+		return
 	}
-	c.requestMutex.Unlock()
-	if wasConnected && c.reconnectEnabled && c.currentReconnectAttempts < c.maxReconnectAttempts {
-		c.currentReconnectAttempts++
-		c.logger.Info("尝试自动重连 (%d/%d)...", c.currentReconnectAttempts, c.maxReconnectAttempts)
-		go func() {
-			time.Sleep(c.reconnectInterval)
-			if err := c.Connect(c.host, c.port, c.token); err != nil {
-				c.logger.Error("自动重连失败: %v", err)
-			}
-		}()
+	c.failPending(fmt.Errorf("connection lost"), true)
+	if !announced {
+		// 握手尚未完成，由 connect 返回错误并决定是否重连
+		return
 	}
+	c.scheduleReconnect(fmt.Errorf("connection lost"))
 }
-func (c *Client) OnConnectionChanged(callback ConnectionCallback) {
+func (c *Client) OnConnectionChanged(callback ConnectionCallback) *Subscription {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
-	c.connectionCallbacks = append(c.connectionCallbacks, callback)
+	c.nextCallbackID++
+	id := c.nextCallbackID
+	c.connectionCallbacks = append(c.connectionCallbacks, connectionSubscription{id: id, callback: callback})
+	return newSubscription(func() {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		for i, sub := range c.connectionCallbacks {
+			if sub.id == id {
+				c.connectionCallbacks = append(c.connectionCallbacks[:i:i], c.connectionCallbacks[i+1:]...)
+				return
+			}
+		}
+	})
 }
-func (c *Client) OnMessage(msgType string, callback MessageCallback) {
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
-	c.messageCallbacks[msgType] = append(c.messageCallbacks[msgType], callback)
+func (c *Client) OnMessage(msgType string, callback MessageCallback) *Subscription {
+	return c.OnMessageWithOptions(msgType, callback, DefaultSubscribeOptions())
 }
-func (c *Client) notifyConnectionChange(connected bool) {
-	for _, callback := range c.connectionCallbacks {
-		go callback(connected)
+func (c *Client) OnMessageWithOptions(msgType string, callback MessageCallback, options SubscribeOptions) *Subscription {
+	id, err := c.dispatcher.subscribe(msgType, callback, options)
+	if err != nil {
+		c.logger.Error("订阅 %s 失败: %v", msgType, err)
+		return newSubscription(nil)
 	}
+	return newSubscription(func() {
+		c.dispatcher.unsubscribe(id)
+	})
 }
-func (c *Client) SetReconnectEnabled(enabled bool) {
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
-	c.reconnectEnabled = enabled
+func (c *Client) DispatchStats() []SubscriberStats {
+	return c.dispatcher.snapshot()
 }
-func (c *Client) SetReconnectParams(interval time.Duration, maxAttempts int) {
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
-	c.reconnectInterval = interval
-	c.maxReconnectAttempts = maxAttempts
+func (c *Client) notifyConnectionChange(connected bool) {
+	for _, sub := range c.connectionCallbacks {
+		go sub.callback(connected)
+	}
+}
+func (c *Client) MarkIdempotent(methods ...string) {
+	c.requestMutex.Lock()
+	defer c.requestMutex.Unlock()
+	for _, method := range methods {
+		c.idempotent[method] = true
+	}
 }
-func (c *Client) GetReconnectStatus() (bool, int, int) {
-	c.mutex.RLock()
-	defer c.mutex.RUnlock()
-	return c.reconnectEnabled, c.currentReconnectAttempts, c.maxReconnectAttempts
-}
\ No newline at end of file
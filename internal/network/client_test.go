@@ -0,0 +1,355 @@
+package network_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"lazytea-mobile/internal/data"
+	"lazytea-mobile/internal/mockserver"
+	"lazytea-mobile/internal/network"
+	"lazytea-mobile/internal/protocol"
+	"lazytea-mobile/internal/utils"
+)
+
+func startServer(t *testing.T, options mockserver.Options) *mockserver.Server {
+	t.Helper()
+	options.Logger = quietLogger()
+	server := mockserver.New(options)
+	if err := server.Start(); err != nil {
+		t.Fatalf("start mock server: %v", err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return server
+}
+
+func connectClient(t *testing.T, server *mockserver.Server) *network.Client {
+	t.Helper()
+	client := newClient()
+	if err := client.ConnectWithOptions(server.ConnectOptions()); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(client.Disconnect)
+	return client
+}
+
+func newClient() *network.Client {
+	client := network.NewClient(quietLogger())
+	client.SetReconnectEnabled(false)
+	return client
+}
+
+func quietLogger() *utils.Logger {
+	logger := utils.NewLogger()
+	logger.SetLevel(utils.ERROR)
+	return logger
+}
+
+func openStorage(t *testing.T) *data.Storage {
+	t.Helper()
+	storage, err := data.NewStorage(filepath.Join(t.TempDir(), "data.db"))
+	if err != nil {
+		t.Fatalf("open storage: %v", err)
+	}
+	t.Cleanup(func() { storage.Close() })
+	return storage
+}
+
+func TestClientHandshakeAndRequests(t *testing.T) {
+	server := startServer(t, mockserver.Options{Token: "secret", Fixtures: mockserver.DemoFixtures()})
+	client := connectClient(t, server)
+	if !client.HasCapability(protocol.CapabilityMatchers) {
+		t.Fatalf("expected matchers capability, got %+v", client.ServerInfo())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	response, err := client.Call(ctx, "get_plugins", map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("get_plugins: %v", err)
+	}
+	plugins, err := protocol.As[*protocol.PluginList](protocol.DecodeResponse("", "get_plugins", response))
+	if err != nil {
+		t.Fatalf("decode get_plugins: %v", err)
+	}
+	if len(plugins.Plugins) != 2 || plugins.Plugins[0].Name != "echo" {
+		t.Fatalf("unexpected plugins: %+v", plugins.Plugins)
+	}
+
+	response, err = client.Call(ctx, "get_matchers", map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("get_matchers: %v", err)
+	}
+	matchers, err := protocol.As[*protocol.Matchers](protocol.DecodeResponse("", "get_matchers", response))
+	if err != nil {
+		t.Fatalf("decode get_matchers: %v", err)
+	}
+	if _, ok := matchers.Roster["bots"].(map[string]interface{})["10001"]; !ok {
+		t.Fatalf("roster is missing bot 10001: %+v", matchers.Roster)
+	}
+
+	response, err = client.Call(ctx, "save_env", map[string]interface{}{
+		"module_name": "nonebot_plugin_echo",
+		"data":        map[string]interface{}{"echo_prefix": ">> "},
+	})
+	if err != nil {
+		t.Fatalf("save_env: %v", err)
+	}
+	if _, err := protocol.DecodeResponse("", "save_env", response); err != nil {
+		t.Fatalf("save_env rejected: %v", err)
+	}
+
+	response, err = client.Call(ctx, "get_plugin_config", map[string]interface{}{"name": "echo"})
+	if err != nil {
+		t.Fatalf("get_plugin_config: %v", err)
+	}
+	config, err := protocol.As[*protocol.PluginConfig](protocol.DecodeResponse("", "get_plugin_config", response))
+	if err != nil {
+		t.Fatalf("decode get_plugin_config: %v", err)
+	}
+	if _, ok := config.Properties()["echo_prefix"]; !ok {
+		t.Fatalf("schema is missing echo_prefix: %+v", config.Schema)
+	}
+	if env := server.Env("nonebot_plugin_echo"); env["echo_prefix"] != ">> " {
+		t.Fatalf("saved env not reflected on the server, got %+v", env)
+	}
+	if config.Data != `{"echo_prefix":">> "}` {
+		t.Fatalf("saved env not returned by get_plugin_config, got %s", config.Data)
+	}
+
+	response, err = client.Call(ctx, "sync_matchers", map[string]interface{}{"new_roster": `{"bots":{}}`})
+	if err != nil {
+		t.Fatalf("sync_matchers: %v", err)
+	}
+	if _, err := protocol.DecodeResponse("", "sync_matchers", response); err != nil {
+		t.Fatalf("sync_matchers rejected: %v", err)
+	}
+	if bots := server.Matchers()["bots"].(map[string]interface{}); len(bots) != 0 {
+		t.Fatalf("expected synced roster to be empty, got %+v", bots)
+	}
+}
+
+func TestClientRejectsWrongToken(t *testing.T) {
+	server := startServer(t, mockserver.Options{Token: "secret"})
+	options := server.ConnectOptions()
+	options.Token = "wrong"
+	client := newClient()
+	if err := client.ConnectWithOptions(options); err == nil {
+		client.Disconnect()
+		t.Fatal("expected connection with a wrong token to fail")
+	}
+}
+
+func TestFailedHandshakeDoesNotReportDisconnect(t *testing.T) {
+	server := startServer(t, mockserver.Options{Version: "9.0"})
+	client := newClient()
+	changes := make(chan bool, 4)
+	client.OnConnectionChanged(func(connected bool) {
+		changes <- connected
+	})
+	if err := client.ConnectWithOptions(server.ConnectOptions()); err == nil {
+		client.Disconnect()
+		t.Fatal("expected an incompatible server to be rejected")
+	}
+	select {
+	case connected := <-changes:
+		t.Fatalf("unexpected connection change %v for a connection that never completed", connected)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestClientReceivesScriptedEvents(t *testing.T) {
+	server := startServer(t, mockserver.Options{Script: []mockserver.Event{
+		{Type: "bot_connect", Payload: map[string]interface{}{"bot": "10001", "adapter": "OneBot V11", "platform": "qq"}},
+		{Type: "message", Payload: map[string]interface{}{"bot": "10001", "content": "hello", "userid": 42}},
+		{Type: "plugin_call", Payload: map[string]interface{}{"bot": "10001", "plugin": "echo", "time_costed": 0.01}},
+	}})
+	client := newClient()
+	received := make(chan network.MessageHeader, 8)
+	options := network.DefaultSubscribeOptions()
+	options.Lanes = 1
+	client.OnMessageWithOptions(network.WildcardType, func(header network.MessageHeader, payload interface{}) {
+		if _, err := protocol.DecodeEvent(header.Version, header.MsgType, payload); err != nil {
+			t.Errorf("decode %s: %v", header.MsgType, err)
+		}
+		received <- header
+	}, options)
+	if err := client.ConnectWithOptions(server.ConnectOptions()); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(client.Disconnect)
+	for _, want := range []string{"bot_connect", "message", "plugin_call"} {
+		select {
+		case header := <-received:
+			if header.MsgType != want {
+				t.Fatalf("expected %s, got %s", want, header.MsgType)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %s", want)
+		}
+	}
+}
+
+func TestFrameAuthKeepsEarlyEvents(t *testing.T) {
+	server := startServer(t, mockserver.Options{Token: "secret", Greeting: []mockserver.Event{
+		{Type: "bot_connect", Payload: map[string]interface{}{"bot": "10001", "adapter": "OneBot V11", "platform": "qq"}},
+	}})
+	client := newClient()
+	received := make(chan string, 1)
+	client.OnMessage("bot_connect", func(header network.MessageHeader, payload interface{}) {
+		received <- header.MsgType
+	})
+	options := server.ConnectOptions()
+	options.Auth = network.AuthModeFrame
+	if err := client.ConnectWithOptions(options); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(client.Disconnect)
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("event sent before the auth response was dropped")
+	}
+}
+
+func TestClosedSubscriptionStopsDelivery(t *testing.T) {
+	server := startServer(t, mockserver.Options{})
+	client := connectClient(t, server)
+	kept := make(chan string, 4)
+	closed := make(chan string, 4)
+	client.OnMessage("bot_*", func(header network.MessageHeader, payload interface{}) {
+		kept <- header.MsgType
+	})
+	subscription := client.OnMessage("bot_connect", func(header network.MessageHeader, payload interface{}) {
+		closed <- header.MsgType
+	})
+	subscription.Close()
+	server.Emit("bot_connect", map[string]interface{}{"bot": "10001"})
+	select {
+	case <-kept:
+	case <-time.After(5 * time.Second):
+		t.Fatal("prefix subscription did not receive bot_connect")
+	}
+	select {
+	case msgType := <-closed:
+		t.Fatalf("closed subscription still received %s", msgType)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestClientNegotiatesMsgPack(t *testing.T) {
+	server := startServer(t, mockserver.Options{Encoding: network.EncodingMsgPack, Fixtures: mockserver.DemoFixtures()})
+	client := connectClient(t, server)
+	if encoding := client.Transport().Encoding; encoding != network.EncodingMsgPack {
+		t.Fatalf("expected msgpack transport, got %s", encoding)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	response, err := client.Call(ctx, "get_plugins", map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("get_plugins over msgpack: %v", err)
+	}
+	if _, err := protocol.DecodeResponse("", "get_plugins", response); err != nil {
+		t.Fatalf("decode get_plugins over msgpack: %v", err)
+	}
+}
+
+func TestParsePins(t *testing.T) {
+	const hexPin = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	cases := []struct {
+		name    string
+		raw     string
+		want    int
+		wantErr bool
+	}{
+		{"empty", " , ", 0, false},
+		{"hex with prefix", "sha256/" + hexPin, 1, false},
+		{"colon hex and base64", "9F:86:D0:81:88:4C:7D:65:9A:2F:EA:A0:C5:5A:D0:15:A3:BF:4F:1B:2B:0B:82:2C:D1:5D:6C:15:B0:F0:0A:08, n4bQgYhMfWWaL+qgxVrQFaO/TxsrC4Is0V1sFbDwCgg=", 2, false},
+		{"too short", "abcdef", 0, true},
+		{"one bad entry", hexPin + ",not-a-pin", 0, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			pins, err := network.ParsePins(tc.raw)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("unexpected error state: %v", err)
+			}
+			if len(pins) != tc.want {
+				t.Fatalf("expected %d pins, got %v", tc.want, pins)
+			}
+			for _, pin := range pins {
+				if pin != hexPin {
+					t.Fatalf("expected normalized pin %s, got %s", hexPin, pin)
+				}
+			}
+		})
+	}
+}
+
+func TestStoresScriptedMessages(t *testing.T) {
+	storage := openStorage(t)
+	server := startServer(t, mockserver.Options{Script: []mockserver.Event{
+		{Type: "message", Payload: map[string]interface{}{"bot": "10001", "content": "第一条消息", "userid": "42", "groupid": "123"}},
+		{Type: "message", Payload: map[string]interface{}{"bot": "10001", "content": []interface{}{[]interface{}{"text", "second message"}}, "userid": "42", "from_bot": true}},
+	}})
+	client := network.NewClient(quietLogger())
+	client.SetReconnectEnabled(false)
+	client.SetServerName("mock")
+	client.OnMessage("message", func(header network.MessageHeader, payload interface{}) {
+		event, err := protocol.As[*protocol.Message](protocol.DecodeEvent(header.Version, header.MsgType, payload))
+		if err != nil {
+			t.Errorf("decode message: %v", err)
+			return
+		}
+		msg := data.Message{
+			Bot:       string(event.Bot),
+			BotID:     string(event.Bot),
+			Content:   event.Content.Plaintext(),
+			Plaintext: event.Content.Plaintext(),
+			FromBot:   event.FromBot,
+			Timestamp: time.Now(),
+			User:      string(event.UserID),
+			UserID:    string(event.UserID),
+			Server:    header.Server,
+		}
+		if groupID := string(event.GroupID); groupID != "" {
+			msg.GroupID = &groupID
+		}
+		if err := storage.SaveMessage(msg); err != nil {
+			t.Errorf("save message: %v", err)
+		}
+	})
+	if err := client.ConnectWithOptions(server.ConnectOptions()); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(client.Disconnect)
+	waitUntil(t, "messages to be stored", func() bool {
+		count, err := storage.GetTotalMessageCount()
+		return err == nil && count == 2
+	})
+	count, err := storage.GetBotMessageCount("10001")
+	if err != nil || count != 2 {
+		t.Fatalf("expected 2 messages for bot 10001, got %d (%v)", count, err)
+	}
+	found, err := storage.SearchMessages("second", 10)
+	if err != nil {
+		t.Fatalf("search messages: %v", err)
+	}
+	if len(found) != 1 || found[0].Server != "mock" || !found[0].FromBot {
+		t.Fatalf("unexpected search result: %+v", found)
+	}
+	listed, err := storage.GetMessagesByBot("10001", 10, 0)
+	if err != nil {
+		t.Fatalf("get messages by bot: %v", err)
+	}
+	fromBot := 0
+	for _, msg := range listed {
+		if msg.FromBot {
+			fromBot++
+		}
+	}
+	if len(listed) != 2 || fromBot != 1 {
+		t.Fatalf("expected one message from the bot, got %+v", listed)
+	}
+}
@@ -0,0 +1,441 @@
+package network
+
+import (
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type OverflowPolicy int
+
+const (
+	PolicyBlock OverflowPolicy = iota
+	PolicyDropOldest
+	PolicyDropNewest
+	PolicyCoalesce
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case PolicyDropOldest:
+		return "drop-oldest"
+	case PolicyDropNewest:
+		return "drop-newest"
+	case PolicyCoalesce:
+		return "coalesce"
+	default:
+		return "block"
+	}
+}
+
+type EventKeyFunc func(header MessageHeader, payload interface{}) string
+
+// SubscribeOptions configures the queue behind a subscription. Subscriptions
+// that set the same Name share one queue, so they must agree on the rest of
+// the options; unnamed subscriptions always get a queue of their own.
+type SubscribeOptions struct {
+	Name         string
+	QueueSize    int
+	Lanes        int
+	Policy       OverflowPolicy
+	BlockTimeout time.Duration
+	Key          EventKeyFunc
+}
+
+// DefaultSubscribeOptions spreads events over several lanes keyed by bot, so
+// callbacks for different bots run concurrently. Subscribers that touch
+// shared state without a lock should set Lanes to 1.
+func DefaultSubscribeOptions() SubscribeOptions {
+	return SubscribeOptions{
+		QueueSize:    256,
+		Lanes:        4,
+		Policy:       PolicyBlock,
+		BlockTimeout: 2 * time.Second,
+		Key:          BotKey,
+	}
+}
+
+func BotKey(header MessageHeader, payload interface{}) string {
+	payloadMap, ok := payload.(map[string]interface{})
+	if !ok {
+		return header.Server
+	}
+	for _, field := range []string{"bot", "bot_id"} {
+		if value, ok := payloadMap[field]; ok && value != nil {
+			return header.Server + "/" + fmt.Sprint(value)
+		}
+	}
+	return header.Server
+}
+
+func TypeKey(header MessageHeader, payload interface{}) string {
+	return header.Server + "/" + header.MsgType
+}
+
+type SubscriberStats struct {
+	Name      string
+	MsgTypes  []string
+	Policy    OverflowPolicy
+	Depth     int
+	MaxDepth  int
+	Delivered int
+	Dropped   int
+	Coalesced int
+}
+
+type dispatchEvent struct {
+	header  MessageHeader
+	payload interface{}
+	key     string
+	sub     *subscription
+}
+
+type dispatchLane struct {
+	mutex  sync.Mutex
+	queue  []dispatchEvent
+	notify chan struct{}
+	space  chan struct{}
+}
+
+type subscriber struct {
+	key     string
+	options SubscribeOptions
+	lanes   []*dispatchLane
+	stop    chan struct{}
+	mutex   sync.Mutex
+	stats   SubscriberStats
+}
+
+type subscription struct {
+	id       uint64
+	pattern  string
+	group    *subscriber
+	callback MessageCallback
+	active   atomic.Bool
+}
+
+type dispatcher struct {
+	mutex         sync.RWMutex
+	groups        map[string]*subscriber
+	subscriptions []*subscription
+	nextID        uint64
+	closed        bool
+}
+
+func newDispatcher() *dispatcher {
+	return &dispatcher{
+		groups: make(map[string]*subscriber),
+	}
+}
+
+func (o SubscribeOptions) normalize() SubscribeOptions {
+	defaults := DefaultSubscribeOptions()
+	if o.QueueSize <= 0 {
+		o.QueueSize = defaults.QueueSize
+	}
+	if o.Lanes <= 0 {
+		o.Lanes = 1
+	}
+	if o.BlockTimeout <= 0 {
+		o.BlockTimeout = defaults.BlockTimeout
+	}
+	if o.Key == nil {
+		o.Key = defaults.Key
+	}
+	return o
+}
+
+func (o SubscribeOptions) sameQueue(other SubscribeOptions) bool {
+	return o.QueueSize == other.QueueSize && o.Lanes == other.Lanes && o.Policy == other.Policy &&
+		o.BlockTimeout == other.BlockTimeout &&
+		reflect.ValueOf(o.Key).Pointer() == reflect.ValueOf(other.Key).Pointer()
+}
+
+func (d *dispatcher) subscribe(pattern string, callback MessageCallback, options SubscribeOptions) (uint64, error) {
+	options = options.normalize()
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.closed {
+		return 0, nil
+	}
+	d.nextID++
+	key, name := options.Name, options.Name
+	if key == "" {
+		key, name = fmt.Sprintf("%s#%d", pattern, d.nextID), pattern
+	}
+	group, ok := d.groups[key]
+	if ok && !group.options.sameQueue(options) {
+		return 0, fmt.Errorf("subscriber %q already exists with different queue options", options.Name)
+	}
+	if !ok {
+		group = &subscriber{
+			key:     key,
+			options: options,
+			lanes:   make([]*dispatchLane, options.Lanes),
+			stop:    make(chan struct{}),
+			stats:   SubscriberStats{Name: name, Policy: options.Policy},
+		}
+		for i := range group.lanes {
+			lane := &dispatchLane{
+				notify: make(chan struct{}, 1),
+				space:  make(chan struct{}, 1),
+			}
+			group.lanes[i] = lane
+			go group.run(lane)
+		}
+		d.groups[key] = group
+	}
+	group.record(func(stats *SubscriberStats) {
+		if !containsString(stats.MsgTypes, pattern) {
+			stats.MsgTypes = append(stats.MsgTypes, pattern)
+		}
+	})
+	sub := &subscription{id: d.nextID, pattern: pattern, group: group, callback: callback}
+	sub.active.Store(true)
+	d.subscriptions = append(d.subscriptions, sub)
+	return sub.id, nil
+}
+
+func (d *dispatcher) unsubscribe(id uint64) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	var removed *subscription
+	remaining := d.subscriptions[:0]
+	for _, sub := range d.subscriptions {
+		if sub.id == id {
+			removed = sub
+			continue
+		}
+		remaining = append(remaining, sub)
+	}
+	for i := len(remaining); i < len(d.subscriptions); i++ {
+		d.subscriptions[i] = nil
+	}
+	d.subscriptions = remaining
+	if removed == nil {
+		return
+	}
+	removed.active.Store(false)
+	var patterns []string
+	for _, sub := range d.subscriptions {
+		if sub.group == removed.group && !containsString(patterns, sub.pattern) {
+			patterns = append(patterns, sub.pattern)
+		}
+	}
+	if len(patterns) == 0 {
+		delete(d.groups, removed.group.key)
+		if !d.closed {
+			close(removed.group.stop)
+		}
+		return
+	}
+	removed.group.record(func(stats *SubscriberStats) { stats.MsgTypes = patterns })
+}
+
+func (d *dispatcher) dispatch(header MessageHeader, payload interface{}) {
+	d.mutex.RLock()
+	var matched []*subscription
+	for _, sub := range d.subscriptions {
+		if MatchType(sub.pattern, header.MsgType) {
+			matched = append(matched, sub)
+		}
+	}
+	d.mutex.RUnlock()
+	for _, sub := range matched {
+		sub.group.enqueue(dispatchEvent{
+			header:  header,
+			payload: payload,
+			key:     sub.group.options.Key(header, payload),
+			sub:     sub,
+		})
+	}
+}
+
+func (d *dispatcher) close() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.closed {
+		return
+	}
+	d.closed = true
+	for _, group := range d.groups {
+		close(group.stop)
+	}
+}
+
+func (d *dispatcher) snapshot() []SubscriberStats {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	result := make([]SubscriberStats, 0, len(d.groups))
+	for _, group := range d.groups {
+		result = append(result, group.snapshot())
+	}
+	sortSubscriberStats(result)
+	return result
+}
+
+func (s *subscriber) lane(key string) *dispatchLane {
+	if len(s.lanes) == 1 {
+		return s.lanes[0]
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return s.lanes[h.Sum32()%uint32(len(s.lanes))]
+}
+
+func (s *subscriber) enqueue(event dispatchEvent) {
+	lane := s.lane(event.key)
+	var timer *time.Timer
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+	for {
+		lane.mutex.Lock()
+		if s.options.Policy == PolicyCoalesce {
+			for i := len(lane.queue) - 1; i >= 0; i-- {
+				if lane.queue[i].sub == event.sub && lane.queue[i].key == event.key && lane.queue[i].header.MsgType == event.header.MsgType {
+					lane.queue[i] = event
+					lane.mutex.Unlock()
+					s.record(func(stats *SubscriberStats) { stats.Coalesced++ })
+					return
+				}
+			}
+		}
+		if len(lane.queue) < s.options.QueueSize {
+			lane.queue = append(lane.queue, event)
+			depth := len(lane.queue)
+			lane.mutex.Unlock()
+			s.record(func(stats *SubscriberStats) {
+				if depth > stats.MaxDepth {
+					stats.MaxDepth = depth
+				}
+			})
+			wake(lane.notify)
+			return
+		}
+		switch s.options.Policy {
+		case PolicyDropOldest, PolicyCoalesce:
+			copy(lane.queue, lane.queue[1:])
+			lane.queue[len(lane.queue)-1] = event
+			lane.mutex.Unlock()
+			s.record(func(stats *SubscriberStats) { stats.Dropped++ })
+			wake(lane.notify)
+			return
+		case PolicyDropNewest:
+			lane.mutex.Unlock()
+			s.record(func(stats *SubscriberStats) { stats.Dropped++ })
+			return
+		}
+		lane.mutex.Unlock()
+		if timer == nil {
+			timer = time.NewTimer(s.options.BlockTimeout)
+		}
+		select {
+		case <-lane.space:
+		case <-timer.C:
+			s.record(func(stats *SubscriberStats) { stats.Dropped++ })
+			return
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *subscriber) run(lane *dispatchLane) {
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-lane.notify:
+		}
+		for {
+			lane.mutex.Lock()
+			if len(lane.queue) == 0 {
+				lane.mutex.Unlock()
+				break
+			}
+			event := lane.queue[0]
+			lane.queue[0] = dispatchEvent{}
+			lane.queue = lane.queue[1:]
+			lane.mutex.Unlock()
+			wake(lane.space)
+			if !event.sub.active.Load() {
+				continue
+			}
+			event.sub.callback(event.header, event.payload)
+			s.record(func(stats *SubscriberStats) { stats.Delivered++ })
+			select {
+			case <-s.stop:
+				return
+			default:
+			}
+		}
+	}
+}
+
+func (s *subscriber) record(update func(stats *SubscriberStats)) {
+	s.mutex.Lock()
+	update(&s.stats)
+	s.mutex.Unlock()
+}
+
+func (s *subscriber) snapshot() SubscriberStats {
+	depth := 0
+	for _, lane := range s.lanes {
+		lane.mutex.Lock()
+		depth += len(lane.queue)
+		lane.mutex.Unlock()
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stats := s.stats
+	stats.MsgTypes = append([]string(nil), s.stats.MsgTypes...)
+	stats.Depth = depth
+	return stats
+}
+
+func wake(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func mergeSubscriberStats(merged map[string]*SubscriberStats, stats []SubscriberStats) {
+	for _, s := range stats {
+		entry, ok := merged[s.Name]
+		if !ok {
+			copied := s
+			merged[s.Name] = &copied
+			continue
+		}
+		entry.Depth += s.Depth
+		entry.Delivered += s.Delivered
+		entry.Dropped += s.Dropped
+		entry.Coalesced += s.Coalesced
+		if s.MaxDepth > entry.MaxDepth {
+			entry.MaxDepth = s.MaxDepth
+		}
+	}
+}
+
+func sortSubscriberStats(stats []SubscriberStats) {
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Name < stats[j].Name
+	})
+}
@@ -0,0 +1,218 @@
+package network_test
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"lazytea-mobile/internal/mockserver"
+	"lazytea-mobile/internal/network"
+)
+
+// gatedRecorder holds the first delivery until release is called, so the
+// events emitted after it pile up in the subscription's queue.
+type gatedRecorder struct {
+	mutex   sync.Mutex
+	seen    []string
+	started chan struct{}
+	gate    chan struct{}
+	once    sync.Once
+}
+
+func newGatedRecorder() *gatedRecorder {
+	return &gatedRecorder{started: make(chan struct{}), gate: make(chan struct{})}
+}
+
+func (r *gatedRecorder) callback(header network.MessageHeader, payload interface{}) {
+	r.once.Do(func() {
+		close(r.started)
+		<-r.gate
+	})
+	fields := payload.(map[string]interface{})
+	r.mutex.Lock()
+	r.seen = append(r.seen, fmt.Sprint(fields["bot"], ":", fields["seq"]))
+	r.mutex.Unlock()
+}
+
+func (r *gatedRecorder) release() {
+	close(r.gate)
+}
+
+func (r *gatedRecorder) snapshot() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]string(nil), r.seen...)
+}
+
+func emitSeq(server *mockserver.Server, bot string, seq int) {
+	server.Emit("plugin_call", map[string]interface{}{"bot": bot, "seq": seq})
+}
+
+func statsFor(client *network.Client, name string) network.SubscriberStats {
+	for _, stats := range client.DispatchStats() {
+		if stats.Name == name {
+			return stats
+		}
+	}
+	return network.SubscriberStats{}
+}
+
+func waitUntil(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDispatchOverflowPolicies(t *testing.T) {
+	cases := []struct {
+		name      string
+		options   network.SubscribeOptions
+		emitted   int
+		want      []string
+		dropped   int
+		coalesced int
+	}{
+		{
+			name:    "drop oldest",
+			options: network.SubscribeOptions{QueueSize: 2, Policy: network.PolicyDropOldest},
+			emitted: 5,
+			want:    []string{"a:0", "a:3", "a:4"},
+			dropped: 2,
+		},
+		{
+			name:    "drop newest",
+			options: network.SubscribeOptions{QueueSize: 2, Policy: network.PolicyDropNewest},
+			emitted: 5,
+			want:    []string{"a:0", "a:1", "a:2"},
+			dropped: 2,
+		},
+		{
+			name:      "coalesce",
+			options:   network.SubscribeOptions{QueueSize: 2, Policy: network.PolicyCoalesce},
+			emitted:   5,
+			want:      []string{"a:0", "a:4"},
+			coalesced: 3,
+		},
+		{
+			name:    "block timeout",
+			options: network.SubscribeOptions{QueueSize: 1, Policy: network.PolicyBlock, BlockTimeout: 50 * time.Millisecond},
+			emitted: 3,
+			want:    []string{"a:0", "a:1"},
+			dropped: 1,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := startServer(t, mockserver.Options{})
+			client := connectClient(t, server)
+			recorder := newGatedRecorder()
+			client.OnMessageWithOptions("plugin_call", recorder.callback, tc.options)
+
+			emitSeq(server, "a", 0)
+			select {
+			case <-recorder.started:
+			case <-time.After(5 * time.Second):
+				t.Fatal("first event was not delivered")
+			}
+			for seq := 1; seq < tc.emitted; seq++ {
+				emitSeq(server, "a", seq)
+			}
+			waitUntil(t, "queued events", func() bool {
+				stats := statsFor(client, "plugin_call")
+				return stats.Dropped == tc.dropped && stats.Coalesced == tc.coalesced &&
+					stats.Depth+stats.Dropped+stats.Coalesced == tc.emitted-1
+			})
+			recorder.release()
+			waitUntil(t, "delivery", func() bool { return len(recorder.snapshot()) >= len(tc.want) })
+			time.Sleep(50 * time.Millisecond)
+			if got := recorder.snapshot(); strings.Join(got, ",") != strings.Join(tc.want, ",") {
+				t.Fatalf("expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestDispatchKeepsPerKeyOrder(t *testing.T) {
+	server := startServer(t, mockserver.Options{})
+	client := connectClient(t, server)
+	var mutex sync.Mutex
+	seen := make(map[string][]int)
+	total := 0
+	client.OnMessageWithOptions("plugin_call", func(header network.MessageHeader, payload interface{}) {
+		fields := payload.(map[string]interface{})
+		time.Sleep(time.Millisecond)
+		mutex.Lock()
+		defer mutex.Unlock()
+		bot := fields["bot"].(string)
+		seen[bot] = append(seen[bot], int(fields["seq"].(float64)))
+		total++
+	}, network.SubscribeOptions{Lanes: 4, Key: network.BotKey})
+
+	bots := []string{"a", "b", "c", "d", "e"}
+	const perBot = 20
+	for seq := 0; seq < perBot; seq++ {
+		for _, bot := range bots {
+			emitSeq(server, bot, seq)
+		}
+	}
+	waitUntil(t, "delivery", func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return total == perBot*len(bots)
+	})
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, bot := range bots {
+		for i, seq := range seen[bot] {
+			if seq != i {
+				t.Fatalf("bot %s delivered out of order: %v", bot, seen[bot])
+			}
+		}
+	}
+}
+
+func TestDispatchGroupsBySubscription(t *testing.T) {
+	server := startServer(t, mockserver.Options{})
+	client := connectClient(t, server)
+	noop := func(header network.MessageHeader, payload interface{}) {}
+
+	client.OnMessageWithOptions("plugin_call", noop, network.SubscribeOptions{QueueSize: 4, Policy: network.PolicyDropOldest})
+	client.OnMessageWithOptions("plugin_call", noop, network.SubscribeOptions{QueueSize: 8, Policy: network.PolicyCoalesce})
+	var policies []network.OverflowPolicy
+	for _, stats := range client.DispatchStats() {
+		policies = append(policies, stats.Policy)
+	}
+	if len(policies) != 2 {
+		t.Fatalf("expected unnamed subscriptions to get their own queues, got policies %v", policies)
+	}
+
+	shared := network.SubscribeOptions{Name: "page", QueueSize: 4, Policy: network.PolicyDropNewest}
+	client.OnMessageWithOptions("bot_connect", noop, shared)
+	client.OnMessageWithOptions("bot_disconnect", noop, shared)
+	if stats := statsFor(client, "page"); len(stats.MsgTypes) != 2 {
+		t.Fatalf("expected matching named subscriptions to share a queue, got %+v", stats)
+	}
+
+	mismatched := shared
+	mismatched.Policy = network.PolicyBlock
+	received := make(chan struct{}, 1)
+	client.OnMessageWithOptions("message", func(header network.MessageHeader, payload interface{}) {
+		received <- struct{}{}
+	}, mismatched)
+	if stats := statsFor(client, "page"); len(stats.MsgTypes) != 2 || stats.Policy != network.PolicyDropNewest {
+		t.Fatalf("expected mismatched options to be rejected, got %+v", stats)
+	}
+	server.Emit("message", map[string]interface{}{"bot": "a"})
+	select {
+	case <-received:
+		t.Fatal("rejected subscription still received events")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
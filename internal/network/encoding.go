@@ -0,0 +1,148 @@
+package network
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+type Encoding string
+
+const (
+	EncodingJSON    Encoding = "json"
+	EncodingMsgPack Encoding = "msgpack"
+)
+
+type TransportInfo struct {
+	Encoding    Encoding
+	Compression bool
+}
+
+func (e Encoding) normalize() Encoding {
+	if e == EncodingMsgPack {
+		return e
+	}
+	return EncodingJSON
+}
+
+func (e Encoding) offers() []string {
+	if e.normalize() == EncodingMsgPack {
+		return []string{string(EncodingMsgPack), string(EncodingJSON)}
+	}
+	return []string{string(EncodingJSON)}
+}
+
+func (e Encoding) accepts(chosen string) bool {
+	for _, offer := range e.offers() {
+		if offer == chosen {
+			return true
+		}
+	}
+	return false
+}
+
+func EncodeFrame(encoding Encoding, header MessageHeader, payload interface{}) (int, []byte, error) {
+	if encoding.normalize() == EncodingJSON {
+		message, err := EncodeMessage(header, payload)
+		if err != nil {
+			return 0, nil, err
+		}
+		return websocket.TextMessage, []byte(message), nil
+	}
+	message := ProtocolMessage{
+		Version: ProtocolVersion,
+		Header:  header,
+		Payload: payload,
+	}
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(message); err != nil {
+		return 0, nil, fmt.Errorf("failed to marshal message: %w", err)
+	}
+	return websocket.BinaryMessage, buf.Bytes(), nil
+}
+
+func DecodeFrame(messageType int, raw []byte) (*MessageHeader, interface{}, error) {
+	if messageType != websocket.BinaryMessage {
+		return DecodeMessage(string(raw))
+	}
+	dec := msgpack.NewDecoder(bytes.NewReader(raw))
+	dec.SetCustomStructTag("json")
+	var message ProtocolMessage
+	if err := dec.Decode(&message); err != nil {
+		return nil, nil, fmt.Errorf("failed to unmarshal message: %w", err)
+	}
+	message.Header.Version = message.Version
+	return &message.Header, normalizeNumbers(message.Payload), nil
+}
+
+func normalizeNumbers(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = normalizeNumbers(item)
+		}
+		return v
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			converted[fmt.Sprint(key)] = normalizeNumbers(item)
+		}
+		return converted
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalizeNumbers(item)
+		}
+		return v
+	case int8:
+		return float64(v)
+	case int16:
+		return float64(v)
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case int:
+		return float64(v)
+	case uint8:
+		return float64(v)
+	case uint16:
+		return float64(v)
+	case uint32:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	case uint:
+		return float64(v)
+	case float32:
+		return float64(v)
+	default:
+		return value
+	}
+}
+
+func deflateNegotiated(resp *http.Response) bool {
+	if resp == nil {
+		return false
+	}
+	for _, ext := range resp.Header.Values("Sec-Websocket-Extensions") {
+		if strings.Contains(strings.ToLower(ext), "permessage-deflate") {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Client) Transport() TransportInfo {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return TransportInfo{
+		Encoding:    c.encoding.normalize(),
+		Compression: c.compressed,
+	}
+}
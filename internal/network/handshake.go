@@ -0,0 +1,53 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"lazytea-mobile/internal/protocol"
+)
+
+const handshakeTimeout = 5 * time.Second
+
+func (c *Client) handshake() (*protocol.ServerInfo, error) {
+	c.mutex.RLock()
+	legacy := c.legacyServer
+	encoding := c.options.Encoding
+	c.mutex.RUnlock()
+	if legacy {
+		return protocol.LegacyServerInfo(), nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), handshakeTimeout)
+	defer cancel()
+	response, err := c.Call(ctx, "handshake", protocol.HandshakeParams("lazytea-mobile", encoding.offers()))
+	if err == nil {
+		var info *protocol.ServerInfo
+		info, err = protocol.As[*protocol.ServerInfo](protocol.DecodeResponse("", "handshake", response))
+		if err == nil {
+			return info, nil
+		}
+	}
+	if errors.Is(err, protocol.ErrIncompatibleVersion) || errors.Is(err, protocol.ErrUnsupportedVersion) {
+		return nil, fmt.Errorf("协议版本不兼容，请升级客户端或服务端: %w", err)
+	}
+	if !c.IsConnected() {
+		return nil, err
+	}
+	c.logger.Warn("服务端不支持协议握手，按旧版协议处理: %v", err)
+	c.mutex.Lock()
+	c.legacyServer = true
+	c.mutex.Unlock()
+	return protocol.LegacyServerInfo(), nil
+}
+
+func (c *Client) ServerInfo() *protocol.ServerInfo {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.serverInfo
+}
+
+func (c *Client) HasCapability(capability string) bool {
+	return c.ServerInfo().Has(capability)
+}
@@ -0,0 +1,288 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"lazytea-mobile/internal/data"
+	"lazytea-mobile/internal/protocol"
+	"lazytea-mobile/internal/utils"
+)
+
+const (
+	defaultHistoryPageSize = 200
+	maxHistoryPages        = 50
+	historyPageTimeout     = 15 * time.Second
+)
+
+type HistoryStore interface {
+	LatestMessageTimestamp(server string) (int64, error)
+	ImportMessages(messages []data.Message) (int, error)
+}
+
+type HistoryPhase int
+
+const (
+	HistoryStarted HistoryPhase = iota
+	HistoryFetching
+	HistoryDone
+	// HistoryTruncated means the sync stopped at maxHistoryPages. What was
+	// fetched is imported and the next sync continues after it.
+	HistoryTruncated
+	HistoryFailed
+)
+
+type HistoryProgress struct {
+	Server   string
+	Phase    HistoryPhase
+	Pages    int
+	Fetched  int
+	Imported int
+	Err      error
+}
+
+type HistoryCallback func(progress HistoryProgress)
+
+type historySubscription struct {
+	id       uint64
+	callback HistoryCallback
+}
+
+type HistorySync struct {
+	mutex     sync.Mutex
+	store     HistoryStore
+	logger    *utils.Logger
+	pageSize  int
+	syncing   map[string]bool
+	since     map[string]int64
+	callbacks []historySubscription
+	nextID    uint64
+}
+
+func NewHistorySync(store HistoryStore, logger *utils.Logger) *HistorySync {
+	return &HistorySync{
+		store:    store,
+		logger:   logger,
+		pageSize: defaultHistoryPageSize,
+		syncing:  make(map[string]bool),
+		since:    make(map[string]int64),
+	}
+}
+
+func (h *HistorySync) SetPageSize(size int) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if size <= 0 {
+		size = defaultHistoryPageSize
+	}
+	h.pageSize = size
+}
+
+func (h *HistorySync) OnProgress(callback HistoryCallback) *Subscription {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.nextID++
+	id := h.nextID
+	h.callbacks = append(h.callbacks, historySubscription{id: id, callback: callback})
+	return newSubscription(func() {
+		h.mutex.Lock()
+		defer h.mutex.Unlock()
+		for i, sub := range h.callbacks {
+			if sub.id == id {
+				h.callbacks = append(h.callbacks[:i:i], h.callbacks[i+1:]...)
+				return
+			}
+		}
+	})
+}
+
+func (h *HistorySync) emit(progress HistoryProgress) {
+	h.mutex.Lock()
+	callbacks := append([]historySubscription(nil), h.callbacks...)
+	h.mutex.Unlock()
+	for _, sub := range callbacks {
+		sub.callback(progress)
+	}
+}
+
+func (h *HistorySync) begin(server string) (int, bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.syncing[server] {
+		return 0, false
+	}
+	h.syncing[server] = true
+	return h.pageSize, true
+}
+
+func (h *HistorySync) end(server string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	delete(h.syncing, server)
+}
+
+// mark records where the next sync for server starts. It runs before a new
+// connection delivers live events, so messages stored while the sync runs
+// cannot hide the gap. A start point left by a sync that did not finish is
+// kept until one does.
+func (h *HistorySync) mark(server string) {
+	h.mutex.Lock()
+	_, ok := h.since[server]
+	h.mutex.Unlock()
+	if ok {
+		return
+	}
+	since, err := h.store.LatestMessageTimestamp(server)
+	if err != nil {
+		h.logger.Error("读取最新消息时间失败: %v", err)
+		return
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if _, ok := h.since[server]; !ok {
+		h.since[server] = since
+	}
+}
+
+func (h *HistorySync) startPoint(server string) (int64, error) {
+	h.mutex.Lock()
+	since, ok := h.since[server]
+	h.mutex.Unlock()
+	if ok {
+		return since, nil
+	}
+	return h.store.LatestMessageTimestamp(server)
+}
+
+// advance moves the start point of server past a partial sync, so the next
+// one resumes at the newest imported message instead of starting over.
+func (h *HistorySync) advance(server string, since int64) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if since > h.since[server] {
+		h.since[server] = since
+	}
+}
+
+func (h *HistorySync) synced(server string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	delete(h.since, server)
+}
+
+func (c *Client) SetHistorySync(history *HistorySync) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.history = history
+}
+
+func (c *Client) HistorySync() *HistorySync {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.history
+}
+
+func (c *Client) syncHistory() {
+	history := c.HistorySync()
+	if history == nil || !c.HasCapability(protocol.CapabilityHistorySync) {
+		return
+	}
+	server := c.ServerName()
+	pageSize, ok := history.begin(server)
+	if !ok {
+		return
+	}
+	defer history.end(server)
+	progress := HistoryProgress{Server: server, Phase: HistoryStarted}
+	history.emit(progress)
+	imported, truncated, err := c.fetchHistory(history, pageSize, &progress)
+	if err != nil {
+		c.logger.Error("同步历史消息失败: %v", err)
+		progress.Phase = HistoryFailed
+		progress.Err = err
+		history.emit(progress)
+		return
+	}
+	progress.Imported = imported
+	if truncated {
+		progress.Phase = HistoryTruncated
+		c.logger.Warn("历史消息超过 %d 页，已同步 %d 条，其余在下次同步时继续", maxHistoryPages, imported)
+		history.emit(progress)
+		return
+	}
+	progress.Phase = HistoryDone
+	c.logger.Info("历史消息同步完成: 获取 %d 条, 新增 %d 条", progress.Fetched, imported)
+	history.emit(progress)
+}
+
+// fetchHistory pages through get_history from the start point and imports
+// the result. It reports truncated when the server still had more after
+// maxHistoryPages pages.
+func (c *Client) fetchHistory(history *HistorySync, pageSize int, progress *HistoryProgress) (int, bool, error) {
+	since, err := history.startPoint(progress.Server)
+	if err != nil {
+		return 0, false, err
+	}
+	var messages []data.Message
+	cursor := ""
+	truncated := false
+	for {
+		if progress.Pages >= maxHistoryPages {
+			truncated = true
+			break
+		}
+		if !c.IsConnected() {
+			return 0, false, ErrNotConnected
+		}
+		params := map[string]interface{}{"since": since, "limit": pageSize}
+		if cursor != "" {
+			params["cursor"] = cursor
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), historyPageTimeout)
+		response, err := c.Call(ctx, "get_history", params)
+		cancel()
+		if err != nil {
+			return 0, false, err
+		}
+		page, err := protocol.As[*protocol.History](protocol.DecodeResponse("", "get_history", response))
+		if err != nil {
+			return 0, false, err
+		}
+		for i := range page.Messages {
+			msg, err := data.MessageFromEvent(progress.Server, &page.Messages[i])
+			if err != nil {
+				return 0, false, err
+			}
+			messages = append(messages, msg)
+		}
+		progress.Pages++
+		progress.Fetched = len(messages)
+		progress.Phase = HistoryFetching
+		history.emit(*progress)
+		if !page.HasMore {
+			break
+		}
+		if page.NextCursor == cursor {
+			return 0, false, fmt.Errorf("history cursor did not advance: %q", cursor)
+		}
+		cursor = page.NextCursor
+	}
+	imported, err := history.store.ImportMessages(messages)
+	if err != nil {
+		return 0, false, err
+	}
+	if !truncated {
+		history.synced(progress.Server)
+		return imported, false, nil
+	}
+	var newest int64
+	for _, msg := range messages {
+		if msg.Timestamps > newest {
+			newest = msg.Timestamps
+		}
+	}
+	history.advance(progress.Server, newest)
+	return imported, true, nil
+}
@@ -0,0 +1,242 @@
+package network_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"lazytea-mobile/internal/data"
+	"lazytea-mobile/internal/mockserver"
+	"lazytea-mobile/internal/network"
+	"lazytea-mobile/internal/protocol"
+)
+
+func historyMessage(seconds float64, text string) map[string]interface{} {
+	return map[string]interface{}{
+		"bot":     "10001",
+		"content": text,
+		"userid":  "42",
+		"groupid": "123",
+		"time":    seconds,
+	}
+}
+
+func TestHistorySyncImportsMissingMessages(t *testing.T) {
+	storage := openStorage(t)
+	base := float64(time.Now().Add(-time.Hour).Unix())
+	history := []map[string]interface{}{
+		historyMessage(base-60, "too old"),
+		historyMessage(base, "already stored"),
+		historyMessage(base+1, "missed one"),
+		historyMessage(base+2, "missed two"),
+		historyMessage(base+3, "missed three"),
+		historyMessage(base+4, "missed four"),
+	}
+	server := startServer(t, mockserver.Options{Fixtures: mockserver.Fixtures{History: history}})
+
+	var event protocol.Message
+	event.Bot = "10001"
+	event.UserID = "42"
+	event.GroupID = "123"
+	event.Content = protocol.Content{{Type: "text", Data: "already stored"}}
+	event.Time = protocol.Timestamp(base)
+	stored, err := data.MessageFromEvent("mock", &event)
+	if err != nil {
+		t.Fatalf("build message: %v", err)
+	}
+	if err := storage.SaveMessage(stored); err != nil {
+		t.Fatalf("save message: %v", err)
+	}
+
+	sync := network.NewHistorySync(storage, quietLogger())
+	sync.SetPageSize(2)
+	done := make(chan network.HistoryProgress, 4)
+	sync.OnProgress(func(progress network.HistoryProgress) {
+		if progress.Phase == network.HistoryDone || progress.Phase == network.HistoryFailed {
+			done <- progress
+		}
+	})
+	client := network.NewClient(quietLogger())
+	client.SetReconnectEnabled(false)
+	client.SetServerName("mock")
+	client.SetHistorySync(sync)
+
+	awaitSync := func() network.HistoryProgress {
+		t.Helper()
+		select {
+		case progress := <-done:
+			if progress.Err != nil {
+				t.Fatalf("history sync failed: %v", progress.Err)
+			}
+			return progress
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for history sync")
+		}
+		return network.HistoryProgress{}
+	}
+
+	if err := client.ConnectWithOptions(server.ConnectOptions()); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	progress := awaitSync()
+	if progress.Pages != 3 || progress.Fetched != 5 || progress.Imported != 4 {
+		t.Fatalf("unexpected sync progress: %+v", progress)
+	}
+	if count, err := storage.GetTotalMessageCount(); err != nil || count != 5 {
+		t.Fatalf("expected 5 stored messages, got %d (%v)", count, err)
+	}
+	if found, err := storage.SearchMessages("too old", 10); err != nil || len(found) != 0 {
+		t.Fatalf("history older than the last stored message was imported: %+v (%v)", found, err)
+	}
+
+	client.Disconnect()
+	if err := client.ConnectWithOptions(server.ConnectOptions()); err != nil {
+		t.Fatalf("reconnect: %v", err)
+	}
+	t.Cleanup(client.Disconnect)
+	if progress := awaitSync(); progress.Imported != 0 {
+		t.Fatalf("expected no new messages on reconnect, got %+v", progress)
+	}
+	if count, err := storage.GetTotalMessageCount(); err != nil || count != 5 {
+		t.Fatalf("expected 5 stored messages after reconnect, got %d (%v)", count, err)
+	}
+	if requests := server.Requests("get_history"); len(requests) != 4 {
+		t.Fatalf("expected 4 get_history requests, got %d", len(requests))
+	}
+}
+
+func TestHistorySyncIgnoresLiveMessagesDuringSync(t *testing.T) {
+	storage := openStorage(t)
+	base := float64(time.Now().Add(-time.Hour).Unix())
+	server := startServer(t, mockserver.Options{Fixtures: mockserver.Fixtures{History: []map[string]interface{}{
+		historyMessage(base+1, "missed one"),
+		historyMessage(base+2, "missed two"),
+		historyMessage(base+3, "missed three"),
+	}}})
+
+	client := network.NewClient(quietLogger())
+	client.SetReconnectEnabled(false)
+	client.SetServerName("mock")
+	live := make(chan struct{}, 1)
+	client.OnMessage("message", func(header network.MessageHeader, payload interface{}) {
+		event, err := protocol.As[*protocol.Message](protocol.DecodeEvent(header.Version, header.MsgType, payload))
+		if err != nil {
+			t.Errorf("decode message: %v", err)
+			return
+		}
+		msg, err := data.MessageFromEvent(header.Server, event)
+		if err != nil {
+			t.Errorf("build message: %v", err)
+			return
+		}
+		if err := storage.SaveMessage(msg); err != nil {
+			t.Errorf("save live message: %v", err)
+		}
+		live <- struct{}{}
+	})
+
+	sync := network.NewHistorySync(storage, quietLogger())
+	done := make(chan network.HistoryProgress, 1)
+	sync.OnProgress(func(progress network.HistoryProgress) {
+		switch progress.Phase {
+		case network.HistoryStarted:
+			server.Emit("message", historyMessage(base+600, "live while syncing"))
+			select {
+			case <-live:
+			case <-time.After(5 * time.Second):
+				t.Error("live message was not stored")
+			}
+		case network.HistoryDone, network.HistoryFailed:
+			done <- progress
+		}
+	})
+	client.SetHistorySync(sync)
+	if err := client.ConnectWithOptions(server.ConnectOptions()); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(client.Disconnect)
+
+	select {
+	case progress := <-done:
+		if progress.Err != nil || progress.Imported != 3 {
+			t.Fatalf("expected the gap before the live message to be imported, got %+v", progress)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for history sync")
+	}
+	if count, err := storage.GetTotalMessageCount(); err != nil || count != 4 {
+		t.Fatalf("expected 4 stored messages, got %d (%v)", count, err)
+	}
+}
+
+func TestHistorySyncResumesAfterPageLimit(t *testing.T) {
+	storage := openStorage(t)
+	base := float64(time.Now().Add(-time.Hour).Unix())
+	var history []map[string]interface{}
+	for i := 1; i <= 55; i++ {
+		history = append(history, historyMessage(base+float64(i), fmt.Sprintf("missed %d", i)))
+	}
+	server := startServer(t, mockserver.Options{Fixtures: mockserver.Fixtures{History: history}})
+
+	sync := network.NewHistorySync(storage, quietLogger())
+	sync.SetPageSize(1)
+	done := make(chan network.HistoryProgress, 4)
+	sync.OnProgress(func(progress network.HistoryProgress) {
+		switch progress.Phase {
+		case network.HistoryDone, network.HistoryTruncated, network.HistoryFailed:
+			done <- progress
+		}
+	})
+	client := network.NewClient(quietLogger())
+	client.SetReconnectEnabled(false)
+	client.SetServerName("mock")
+	client.SetHistorySync(sync)
+	awaitSync := func() network.HistoryProgress {
+		t.Helper()
+		select {
+		case progress := <-done:
+			if progress.Err != nil {
+				t.Fatalf("history sync failed: %v", progress.Err)
+			}
+			return progress
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for history sync")
+		}
+		return network.HistoryProgress{}
+	}
+
+	if err := client.ConnectWithOptions(server.ConnectOptions()); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	progress := awaitSync()
+	if progress.Phase != network.HistoryTruncated || progress.Pages != 50 || progress.Imported != 50 {
+		t.Fatalf("expected the sync to stop at the page limit, got %+v", progress)
+	}
+	client.Disconnect()
+
+	// A newer message stored before reconnecting must not hide the rest.
+	var event protocol.Message
+	event.Bot = "10001"
+	event.UserID = "42"
+	event.GroupID = "123"
+	event.Content = protocol.Content{{Type: "text", Data: "live after disconnect"}}
+	event.Time = protocol.Timestamp(base + 600)
+	live, err := data.MessageFromEvent("mock", &event)
+	if err != nil {
+		t.Fatalf("build message: %v", err)
+	}
+	if err := storage.SaveMessage(live); err != nil {
+		t.Fatalf("save message: %v", err)
+	}
+
+	if err := client.ConnectWithOptions(server.ConnectOptions()); err != nil {
+		t.Fatalf("reconnect: %v", err)
+	}
+	t.Cleanup(client.Disconnect)
+	if progress := awaitSync(); progress.Phase != network.HistoryDone || progress.Imported != 5 {
+		t.Fatalf("expected the next sync to import the rest, got %+v", progress)
+	}
+	if count, err := storage.GetTotalMessageCount(); err != nil || count != 56 {
+		t.Fatalf("expected 56 stored messages, got %d (%v)", count, err)
+	}
+}
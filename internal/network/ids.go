@@ -0,0 +1,32 @@
+package network
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+type IDGenerator struct {
+	session string
+	seq     atomic.Uint64
+}
+
+func NewIDGenerator() *IDGenerator {
+	buf := make([]byte, 4)
+	if _, err := rand.Read(buf); err != nil {
+		return &IDGenerator{session: fmt.Sprintf("%08x", uint32(time.Now().UnixNano()))}
+	}
+	return &IDGenerator{session: hex.EncodeToString(buf)}
+}
+
+func (g *IDGenerator) Next(kind string) string {
+	return fmt.Sprintf("%s-%s-%d", kind, g.session, g.seq.Add(1))
+}
+
+var defaultIDs = NewIDGenerator()
+
+func NewMessageID(kind string) string {
+	return defaultIDs.Next(kind)
+}
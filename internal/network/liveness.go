@@ -0,0 +1,206 @@
+package network
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type HeartbeatConfig struct {
+	Interval  time.Duration
+	MaxMisses int
+}
+
+func DefaultHeartbeatConfig() HeartbeatConfig {
+	return HeartbeatConfig{
+		Interval:  30 * time.Second,
+		MaxMisses: 3,
+	}
+}
+
+type LatencyCallback func(rtt time.Duration)
+
+type liveness struct {
+	mutex       sync.Mutex
+	config      HeartbeatConfig
+	lastAck     time.Time
+	misses      int
+	latency     time.Duration
+	heartbeatID string
+	heartbeatAt time.Time
+	callbacks   []LatencyCallback
+}
+
+func newLiveness() *liveness {
+	return &liveness{config: DefaultHeartbeatConfig()}
+}
+
+func (l *liveness) reset() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.lastAck = time.Now()
+	l.misses = 0
+	l.latency = 0
+	l.heartbeatID = ""
+}
+
+func (c *Client) SetHeartbeatConfig(config HeartbeatConfig) {
+	c.liveness.mutex.Lock()
+	defer c.liveness.mutex.Unlock()
+	if config.Interval <= 0 {
+		config.Interval = DefaultHeartbeatConfig().Interval
+	}
+	if config.MaxMisses <= 0 {
+		config.MaxMisses = DefaultHeartbeatConfig().MaxMisses
+	}
+	c.liveness.config = config
+}
+
+func (c *Client) HeartbeatConfig() HeartbeatConfig {
+	c.liveness.mutex.Lock()
+	defer c.liveness.mutex.Unlock()
+	return c.liveness.config
+}
+
+func (c *Client) Latency() time.Duration {
+	c.liveness.mutex.Lock()
+	defer c.liveness.mutex.Unlock()
+	return c.liveness.latency
+}
+
+func (c *Client) LastAck() time.Time {
+	c.liveness.mutex.Lock()
+	defer c.liveness.mutex.Unlock()
+	return c.liveness.lastAck
+}
+
+func (c *Client) MissedHeartbeats() int {
+	c.liveness.mutex.Lock()
+	defer c.liveness.mutex.Unlock()
+	return c.liveness.misses
+}
+
+func (c *Client) OnLatency(callback LatencyCallback) {
+	c.liveness.mutex.Lock()
+	defer c.liveness.mutex.Unlock()
+	c.liveness.callbacks = append(c.liveness.callbacks, callback)
+}
+
+func (c *Client) recordAck(sentAt time.Time) {
+	l := c.liveness
+	l.mutex.Lock()
+	now := time.Now()
+	l.lastAck = now
+	l.misses = 0
+	var callbacks []LatencyCallback
+	if !sentAt.IsZero() && !sentAt.After(now) {
+		rtt := now.Sub(sentAt)
+		if l.latency == 0 {
+			l.latency = rtt
+		} else {
+			l.latency = (l.latency*3 + rtt) / 4
+		}
+		callbacks = append(callbacks, l.callbacks...)
+	}
+	latency := l.latency
+	l.mutex.Unlock()
+	for _, callback := range callbacks {
+		go callback(latency)
+	}
+}
+
+func (c *Client) handlePong(appData string) error {
+	var sentAt time.Time
+	if nanos, err := strconv.ParseInt(appData, 10, 64); err == nil {
+		sentAt = time.Unix(0, nanos)
+	}
+	c.recordAck(sentAt)
+	return nil
+}
+
+func (c *Client) handleHeartbeatAck(header *MessageHeader) bool {
+	if header.Replayed {
+		// Recorded acks are swallowed like live ones but must not count
+		// towards this connection's liveness.
+		return header.MsgType == "heartbeat_ack" || header.MsgType == "pong"
+	}
+	l := c.liveness
+	l.mutex.Lock()
+	var matched bool
+	if header.CorrelationID != nil {
+		matched = l.heartbeatID != "" && *header.CorrelationID == l.heartbeatID
+	} else {
+		matched = header.MsgType == "heartbeat_ack" || header.MsgType == "pong"
+	}
+	var sentAt time.Time
+	if l.heartbeatID != "" {
+		sentAt = l.heartbeatAt
+	}
+	if matched {
+		l.heartbeatID = ""
+	}
+	l.mutex.Unlock()
+	if matched {
+		c.recordAck(sentAt)
+	}
+	return matched
+}
+
+func (c *Client) heartbeatLoop(stopCh <-chan struct{}) {
+	config := c.HeartbeatConfig()
+	ticker := time.NewTicker(config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+			if !c.IsConnected() {
+				return
+			}
+			l := c.liveness
+			l.mutex.Lock()
+			misses := l.misses
+			l.mutex.Unlock()
+			if misses >= config.MaxMisses {
+				c.logger.Error("连续 %d 次未收到心跳响应，判定连接已失效", misses)
+				c.handleDisconnection()
+				return
+			}
+			if err := c.sendHeartbeat(config.Interval); err != nil {
+				c.logger.Error("发送心跳失败: %v", err)
+				c.handleDisconnection()
+				return
+			}
+		}
+	}
+}
+
+func (c *Client) sendHeartbeat(interval time.Duration) error {
+	c.mutex.RLock()
+	conn := c.conn
+	c.mutex.RUnlock()
+	if conn == nil {
+		return websocket.ErrCloseSent
+	}
+	now := time.Now()
+	msgID := NewMessageID("hb")
+	l := c.liveness
+	l.mutex.Lock()
+	l.misses++
+	l.heartbeatID = msgID
+	l.heartbeatAt = now
+	l.mutex.Unlock()
+	ping := []byte(strconv.FormatInt(now.UnixNano(), 10))
+	if err := conn.WriteControl(websocket.PingMessage, ping, now.Add(interval)); err != nil {
+		return err
+	}
+	header := MessageHeader{
+		MsgID:     msgID,
+		MsgType:   "heartbeat",
+		Timestamp: float64(now.UnixNano()) / 1e9,
+	}
+	return c.sendMessage(header, HeartbeatPayload{Status: "alive"})
+}
@@ -0,0 +1,51 @@
+package network_test
+
+import (
+	"testing"
+	"time"
+
+	"lazytea-mobile/internal/mockserver"
+	"lazytea-mobile/internal/network"
+)
+
+func TestMissedHeartbeatsReconnect(t *testing.T) {
+	server := startServer(t, mockserver.Options{})
+	client := newReconnectingClient(t, 5)
+	client.SetHeartbeatConfig(network.HeartbeatConfig{Interval: 30 * time.Millisecond, MaxMisses: 2})
+	connections := make(chan bool, 8)
+	client.OnConnectionChanged(func(connected bool) {
+		connections <- connected
+	})
+	if err := client.ConnectWithOptions(server.ConnectOptions()); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	waitUntil(t, "heartbeat round trips", func() bool {
+		return client.Latency() > 0 && client.MissedHeartbeats() <= 1
+	})
+	if time.Since(client.LastAck()) > time.Second {
+		t.Fatalf("last ack is stale: %v", client.LastAck())
+	}
+
+	changes, stop := client.WatchState()
+	defer stop()
+	server.SetHeartbeats(false)
+	awaitState(t, changes, network.StateBackoff)
+	if client.IsConnected() {
+		t.Fatal("client still reports a connection whose heartbeats went unanswered")
+	}
+	reported := false
+	for !reported {
+		select {
+		case connected := <-connections:
+			reported = !connected
+		case <-time.After(5 * time.Second):
+			t.Fatal("the dead link was not reported as a disconnect")
+		}
+	}
+
+	server.SetHeartbeats(true)
+	awaitState(t, changes, network.StateConnected)
+	waitUntil(t, "heartbeats after reconnecting", func() bool {
+		return client.IsConnected() && client.MissedHeartbeats() <= 1 && time.Since(client.LastAck()) < time.Second
+	})
+}
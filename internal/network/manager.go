@@ -0,0 +1,341 @@
+package network
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"lazytea-mobile/internal/utils"
+)
+
+type ServerConnectionCallback func(server string, connected bool)
+
+type managedSubscription struct {
+	id       uint64
+	msgType  string
+	callback MessageCallback
+	options  SubscribeOptions
+	handles  map[*Client]*Subscription
+}
+
+type serverConnectionSubscription struct {
+	id       uint64
+	callback ServerConnectionCallback
+}
+
+type Manager struct {
+	mutex               sync.RWMutex
+	logger              *utils.Logger
+	clients             map[string]*Client
+	primary             *Client
+	messageCallbacks    []*managedSubscription
+	connectionCallbacks []serverConnectionSubscription
+	nextCallbackID      uint64
+	backoff             BackoffPolicy
+	heartbeat           HeartbeatConfig
+	outbox              *Outbox
+	history             *HistorySync
+}
+
+func NewManager(logger *utils.Logger) *Manager {
+	m := &Manager{
+		logger:    logger,
+		clients:   make(map[string]*Client),
+		backoff:   DefaultBackoffPolicy(),
+		heartbeat: DefaultHeartbeatConfig(),
+	}
+	m.primary = NewClient(logger)
+	m.attach(m.primary)
+	m.clients[""] = m.primary
+	return m
+}
+
+func (m *Manager) Primary() *Client {
+	return m.primary
+}
+
+func (m *Manager) SetPrimaryServer(name string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if existing, ok := m.clients[name]; ok && existing != m.primary {
+		return fmt.Errorf("server %q is already connected as a secondary backend", name)
+	}
+	delete(m.clients, m.primary.ServerName())
+	m.primary.SetServerName(name)
+	m.clients[name] = m.primary
+	return nil
+}
+
+func (m *Manager) Add(name string) (*Client, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, exists := m.clients[name]; exists {
+		return nil, fmt.Errorf("server %q already exists", name)
+	}
+	client := NewClient(m.logger)
+	client.SetServerName(name)
+	m.attach(client)
+	m.clients[name] = client
+	return client, nil
+}
+
+func (m *Manager) Remove(name string) {
+	m.mutex.Lock()
+	client, exists := m.clients[name]
+	if !exists || client == m.primary {
+		m.mutex.Unlock()
+		return
+	}
+	delete(m.clients, name)
+	for _, sub := range m.messageCallbacks {
+		delete(sub.handles, client)
+	}
+	m.mutex.Unlock()
+	client.SetReconnectEnabled(false)
+	client.Disconnect()
+	client.dispatcher.close()
+}
+
+func (m *Manager) Client(name string) *Client {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	if client, ok := m.clients[name]; ok {
+		return client
+	}
+	return m.primary
+}
+
+func (m *Manager) Has(name string) bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	_, ok := m.clients[name]
+	return ok
+}
+
+func (m *Manager) Servers() []string {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	names := make([]string, 0, len(m.clients))
+	for name := range m.clients {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (m *Manager) ConnectionCounts() (connected, total int) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	total = len(m.clients)
+	for _, client := range m.clients {
+		if client.IsConnected() {
+			connected++
+		}
+	}
+	return
+}
+
+func (m *Manager) DisconnectAll() {
+	m.mutex.RLock()
+	clients := make([]*Client, 0, len(m.clients))
+	for _, client := range m.clients {
+		clients = append(clients, client)
+	}
+	m.mutex.RUnlock()
+	for _, client := range clients {
+		client.Disconnect()
+	}
+}
+
+func (m *Manager) OnMessage(msgType string, callback MessageCallback) *Subscription {
+	return m.OnMessageWithOptions(msgType, callback, DefaultSubscribeOptions())
+}
+
+func (m *Manager) OnMessageWithOptions(msgType string, callback MessageCallback, options SubscribeOptions) *Subscription {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.nextCallbackID++
+	managed := &managedSubscription{
+		id:       m.nextCallbackID,
+		msgType:  msgType,
+		callback: callback,
+		options:  options,
+		handles:  make(map[*Client]*Subscription),
+	}
+	for _, client := range m.clients {
+		managed.handles[client] = client.OnMessageWithOptions(msgType, callback, options)
+	}
+	m.messageCallbacks = append(m.messageCallbacks, managed)
+	return newSubscription(func() {
+		m.mutex.Lock()
+		var handles map[*Client]*Subscription
+		for i, sub := range m.messageCallbacks {
+			if sub.id == managed.id {
+				handles = sub.handles
+				m.messageCallbacks = append(m.messageCallbacks[:i:i], m.messageCallbacks[i+1:]...)
+				break
+			}
+		}
+		m.mutex.Unlock()
+		for _, handle := range handles {
+			handle.Close()
+		}
+	})
+}
+
+func (m *Manager) OnConnectionChanged(callback ServerConnectionCallback) *Subscription {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.nextCallbackID++
+	id := m.nextCallbackID
+	m.connectionCallbacks = append(m.connectionCallbacks, serverConnectionSubscription{id: id, callback: callback})
+	return newSubscription(func() {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		for i, sub := range m.connectionCallbacks {
+			if sub.id == id {
+				m.connectionCallbacks = append(m.connectionCallbacks[:i:i], m.connectionCallbacks[i+1:]...)
+				return
+			}
+		}
+	})
+}
+
+func (m *Manager) SetBackoffPolicy(policy BackoffPolicy) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.backoff = policy
+	for _, client := range m.clients {
+		client.SetBackoffPolicy(policy)
+	}
+}
+
+func (m *Manager) SetHeartbeatConfig(config HeartbeatConfig) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.heartbeat = config
+	for _, client := range m.clients {
+		client.SetHeartbeatConfig(config)
+	}
+}
+
+func (m *Manager) SetOutbox(outbox *Outbox) {
+	m.mutex.Lock()
+	m.outbox = outbox
+	for _, client := range m.clients {
+		client.SetOutbox(outbox)
+	}
+	m.mutex.Unlock()
+	if outbox == nil {
+		return
+	}
+	outbox.mutex.Lock()
+	outbox.resume = func(server string) {
+		m.mutex.RLock()
+		client, ok := m.clients[server]
+		m.mutex.RUnlock()
+		if ok && client.IsConnected() {
+			go client.replayOutbox()
+		}
+	}
+	outbox.mutex.Unlock()
+}
+
+func (m *Manager) Outbox() *Outbox {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.outbox
+}
+
+func (m *Manager) SetHistorySync(history *HistorySync) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.history = history
+	for _, client := range m.clients {
+		client.SetHistorySync(history)
+	}
+}
+
+func (m *Manager) HistorySync() *HistorySync {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.history
+}
+
+func (m *Manager) ReconnectAll() {
+	m.mutex.RLock()
+	clients := make([]*Client, 0, len(m.clients))
+	for _, client := range m.clients {
+		clients = append(clients, client)
+	}
+	m.mutex.RUnlock()
+	for _, client := range clients {
+		client.ReconnectNow()
+	}
+}
+
+func (m *Manager) attach(client *Client) {
+	client.SetBackoffPolicy(m.backoff)
+	client.SetHeartbeatConfig(m.heartbeat)
+	client.SetOutbox(m.outbox)
+	client.SetHistorySync(m.history)
+	for _, sub := range m.messageCallbacks {
+		sub.handles[client] = client.OnMessageWithOptions(sub.msgType, sub.callback, sub.options)
+	}
+	client.OnConnectionChanged(func(connected bool) {
+		m.mutex.RLock()
+		callbacks := append([]serverConnectionSubscription(nil), m.connectionCallbacks...)
+		m.mutex.RUnlock()
+		for _, sub := range callbacks {
+			sub.callback(client.ServerName(), connected)
+		}
+	})
+}
+
+func (m *Manager) InFlight() int {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	total := 0
+	for _, client := range m.clients {
+		total += client.InFlight()
+	}
+	return total
+}
+
+func (m *Manager) RequestStats() []MethodStats {
+	m.mutex.RLock()
+	clients := make([]*Client, 0, len(m.clients))
+	for _, client := range m.clients {
+		clients = append(clients, client)
+	}
+	m.mutex.RUnlock()
+	merged := make(map[string]*MethodStats)
+	for _, client := range clients {
+		mergeMethodStats(merged, client.RequestStats())
+	}
+	result := make([]MethodStats, 0, len(merged))
+	for _, stats := range merged {
+		result = append(result, *stats)
+	}
+	sortMethodStats(result)
+	return result
+}
+
+func (m *Manager) DispatchStats() []SubscriberStats {
+	m.mutex.RLock()
+	clients := make([]*Client, 0, len(m.clients))
+	for _, client := range m.clients {
+		clients = append(clients, client)
+	}
+	m.mutex.RUnlock()
+	merged := make(map[string]*SubscriberStats)
+	for _, client := range clients {
+		mergeSubscriberStats(merged, client.DispatchStats())
+	}
+	result := make([]SubscriberStats, 0, len(merged))
+	for _, stats := range merged {
+		result = append(result, *stats)
+	}
+	sortSubscriberStats(result)
+	return result
+}
@@ -0,0 +1,352 @@
+package network
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"lazytea-mobile/internal/data"
+	"lazytea-mobile/internal/protocol"
+	"lazytea-mobile/internal/utils"
+)
+
+var ErrQueued = errors.New("request queued until the connection is restored")
+
+// ErrQueuedBehind reports a request queued while connected because earlier
+// requests for the same server are still pending.
+var ErrQueuedBehind = fmt.Errorf("%w: earlier requests are still pending", ErrQueued)
+
+const outboxReplayTimeout = 15 * time.Second
+
+type OutboxStore interface {
+	EnqueueOutboxItem(item data.OutboxItem) (int64, error)
+	ListOutboxItems(server string) ([]data.OutboxItem, error)
+	ListAllOutboxItems() ([]data.OutboxItem, error)
+	CountPendingOutboxItems(server string) (int, error)
+	UpdateOutboxStatus(id int64, status data.OutboxStatus, lastError string) error
+	ClearOutboxGuard(id int64) error
+	DeleteOutboxItem(id int64) error
+	MoveOutboxItem(id int64, delta int) error
+}
+
+type Guard struct {
+	Method      string
+	Params      map[string]interface{}
+	Fingerprint string
+}
+
+type Mutation struct {
+	Method  string
+	Params  map[string]interface{}
+	Summary string
+	Guard   *Guard
+}
+
+type OutboxEventKind int
+
+const (
+	OutboxQueued OutboxEventKind = iota
+	OutboxSent
+	OutboxConflict
+	OutboxFailed
+)
+
+type OutboxEvent struct {
+	Kind OutboxEventKind
+	Item data.OutboxItem
+	Err  error
+}
+
+type OutboxCallback func(event OutboxEvent)
+
+type Outbox struct {
+	mutex     sync.Mutex
+	store     OutboxStore
+	logger    *utils.Logger
+	replaying map[string]bool
+	callbacks []OutboxCallback
+	resume    func(server string)
+}
+
+func NewOutbox(store OutboxStore, logger *utils.Logger) *Outbox {
+	return &Outbox{
+		store:     store,
+		logger:    logger,
+		replaying: make(map[string]bool),
+	}
+}
+
+// StateFingerprint hashes the JSON form of value. The value is round-tripped
+// through JSON first so typed structs and decoded maps of the same state
+// hash the same.
+func StateFingerprint(value interface{}) string {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return ""
+	}
+	var normalized interface{}
+	if err := json.Unmarshal(raw, &normalized); err != nil {
+		return ""
+	}
+	if raw, err = json.Marshal(normalized); err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// GuardFingerprint decodes a guard response the same way pages decode it,
+// so the fingerprint matches one taken from the page's copy of the state.
+func GuardFingerprint(method string, response *ResponsePayload) (string, error) {
+	decoded, err := protocol.DecodeResponse("", method, response)
+	if err != nil {
+		return "", err
+	}
+	switch state := decoded.(type) {
+	case *protocol.Matchers:
+		return StateFingerprint(state.Roster), nil
+	case *protocol.BotStatus:
+		return StateFingerprint(state.IsOnline), nil
+	default:
+		return StateFingerprint(response.Data), nil
+	}
+}
+
+func (o *Outbox) OnEvent(callback OutboxCallback) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.callbacks = append(o.callbacks, callback)
+}
+
+func (o *Outbox) emit(event OutboxEvent) {
+	o.mutex.Lock()
+	callbacks := append([]OutboxCallback(nil), o.callbacks...)
+	o.mutex.Unlock()
+	for _, callback := range callbacks {
+		go callback(event)
+	}
+}
+
+func (o *Outbox) Items() ([]data.OutboxItem, error) {
+	return o.store.ListAllOutboxItems()
+}
+
+// Len counts the pending items for server. Conflicted and failed items hold
+// back the queued items behind them until the user retries, moves or
+// discards them, but do not hold back requests sent while connected.
+func (o *Outbox) Len(server string) int {
+	count, err := o.store.CountPendingOutboxItems(server)
+	if err != nil {
+		o.logger.Error("读取离线队列失败: %v", err)
+		return 0
+	}
+	return count
+}
+
+func (o *Outbox) Move(item data.OutboxItem, delta int) error {
+	if err := o.store.MoveOutboxItem(item.ID, delta); err != nil {
+		return err
+	}
+	o.kick(item.Server)
+	return nil
+}
+
+func (o *Outbox) Discard(item data.OutboxItem) error {
+	if err := o.store.DeleteOutboxItem(item.ID); err != nil {
+		return err
+	}
+	o.kick(item.Server)
+	return nil
+}
+
+// Retry puts item back in the queue. A conflicted item is sent anyway: its
+// guard is dropped, since the server state it was checked against is gone.
+func (o *Outbox) Retry(item data.OutboxItem) error {
+	if item.Status == data.OutboxConflict {
+		if err := o.store.ClearOutboxGuard(item.ID); err != nil {
+			return err
+		}
+	}
+	if err := o.store.UpdateOutboxStatus(item.ID, data.OutboxPending, ""); err != nil {
+		return err
+	}
+	o.kick(item.Server)
+	return nil
+}
+
+func (o *Outbox) Flush(server string) {
+	o.kick(server)
+}
+
+func (o *Outbox) kick(server string) {
+	o.mutex.Lock()
+	resume := o.resume
+	o.mutex.Unlock()
+	if resume != nil {
+		resume(server)
+	}
+}
+
+func (o *Outbox) enqueue(server string, mutation Mutation) error {
+	item := data.OutboxItem{
+		Server:  server,
+		Method:  mutation.Method,
+		Params:  mutation.Params,
+		Summary: mutation.Summary,
+	}
+	if item.Summary == "" {
+		item.Summary = mutation.Method
+	}
+	if mutation.Guard != nil {
+		item.GuardMethod = mutation.Guard.Method
+		item.GuardParams = mutation.Guard.Params
+		item.GuardFingerprint = mutation.Guard.Fingerprint
+	}
+	id, err := o.store.EnqueueOutboxItem(item)
+	if err != nil {
+		return fmt.Errorf("failed to queue %s: %w", mutation.Method, err)
+	}
+	item.ID = id
+	item.Status = data.OutboxPending
+	o.logger.Info("请求已加入离线队列: %s (%d)", mutation.Method, id)
+	o.emit(OutboxEvent{Kind: OutboxQueued, Item: item})
+	return ErrQueued
+}
+
+func (o *Outbox) beginReplay(server string) bool {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if o.replaying[server] {
+		return false
+	}
+	o.replaying[server] = true
+	return true
+}
+
+func (o *Outbox) endReplay(server string) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	delete(o.replaying, server)
+}
+
+func (c *Client) SetOutbox(outbox *Outbox) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.outbox = outbox
+}
+
+func (c *Client) Outbox() *Outbox {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.outbox
+}
+
+func (c *Client) Submit(ctx context.Context, mutation Mutation) (*ResponsePayload, error) {
+	outbox := c.Outbox()
+	if outbox == nil {
+		return c.Call(ctx, mutation.Method, mutation.Params)
+	}
+	server := c.ServerName()
+	if !c.IsConnected() {
+		return nil, outbox.enqueue(server, mutation)
+	}
+	if outbox.Len(server) > 0 {
+		if err := outbox.enqueue(server, mutation); !errors.Is(err, ErrQueued) {
+			return nil, err
+		}
+		go c.replayOutbox()
+		return nil, ErrQueuedBehind
+	}
+	response, err := c.Call(ctx, mutation.Method, mutation.Params)
+	if errors.Is(err, ErrNotConnected) {
+		return nil, outbox.enqueue(server, mutation)
+	}
+	return response, err
+}
+
+func (c *Client) replayOutbox() {
+	outbox := c.Outbox()
+	if outbox == nil {
+		return
+	}
+	server := c.ServerName()
+	if !outbox.beginReplay(server) {
+		return
+	}
+	defer outbox.endReplay(server)
+	items, err := outbox.store.ListOutboxItems(server)
+	if err != nil {
+		c.logger.Error("读取离线队列失败: %v", err)
+		return
+	}
+	for _, item := range items {
+		if item.Status != data.OutboxPending {
+			// Later items must not overtake one that waits for the user.
+			c.logger.Info("离线队列等待处理 %s (%d)，后续请求暂停重放", item.Method, item.ID)
+			return
+		}
+		if !c.IsConnected() {
+			return
+		}
+		status, err := c.replayItem(item)
+		if err != nil && (errors.Is(err, ErrNotConnected) || !c.IsConnected()) {
+			return
+		}
+		if err == nil {
+			if err := outbox.store.DeleteOutboxItem(item.ID); err != nil {
+				c.logger.Error("移除离线队列项失败: %v", err)
+				return
+			}
+			c.logger.Info("离线请求已重放: %s (%d)", item.Method, item.ID)
+			outbox.emit(OutboxEvent{Kind: OutboxSent, Item: item})
+			continue
+		}
+		c.logger.Error("离线请求重放失败: %s (%d): %v", item.Method, item.ID, err)
+		item.Status = status
+		item.LastError = err.Error()
+		if updateErr := outbox.store.UpdateOutboxStatus(item.ID, status, item.LastError); updateErr != nil {
+			c.logger.Error("更新离线队列项失败: %v", updateErr)
+		}
+		kind := OutboxFailed
+		if status == data.OutboxConflict {
+			kind = OutboxConflict
+		}
+		outbox.emit(OutboxEvent{Kind: kind, Item: item, Err: err})
+		return
+	}
+}
+
+func (c *Client) replayItem(item data.OutboxItem) (data.OutboxStatus, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), outboxReplayTimeout)
+	defer cancel()
+	if item.GuardMethod != "" && item.GuardFingerprint != "" {
+		response, err := c.Call(ctx, item.GuardMethod, item.GuardParams)
+		if err != nil {
+			return data.OutboxFailed, err
+		}
+		fingerprint, err := GuardFingerprint(item.GuardMethod, response)
+		if err != nil {
+			return data.OutboxFailed, err
+		}
+		if fingerprint != item.GuardFingerprint {
+			return data.OutboxConflict, fmt.Errorf("server state changed since %s was queued", item.Method)
+		}
+	}
+	response, err := c.Call(ctx, item.Method, item.Params)
+	if err == nil {
+		_, err = protocol.DecodeResponse("", item.Method, response)
+	}
+	if err != nil {
+		var serverErr *ServerError
+		if errors.As(err, &serverErr) && serverErr.Code == 409 {
+			return data.OutboxConflict, err
+		}
+		return data.OutboxFailed, err
+	}
+	return "", nil
+}
@@ -0,0 +1,224 @@
+package network_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"lazytea-mobile/internal/data"
+	"lazytea-mobile/internal/mockserver"
+	"lazytea-mobile/internal/network"
+	"lazytea-mobile/internal/protocol"
+)
+
+func TestOutboxReplaysAfterReconnect(t *testing.T) {
+	storage := openStorage(t)
+	server := startServer(t, mockserver.Options{Fixtures: mockserver.DemoFixtures()})
+	outbox := network.NewOutbox(storage, quietLogger())
+	client := network.NewClient(quietLogger())
+	client.SetReconnectEnabled(false)
+	client.SetServerName("mock")
+	client.SetOutbox(outbox)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, roster := range []string{`{"bots":{"1":{}}}`, `{"bots":{"2":{}}}`} {
+		_, err := client.Submit(ctx, network.Mutation{
+			Method:  "sync_matchers",
+			Params:  map[string]interface{}{"new_roster": roster},
+			Summary: "同步权限名单",
+		})
+		if !errors.Is(err, network.ErrQueued) {
+			t.Fatalf("expected mutation to be queued while offline, got %v", err)
+		}
+	}
+	if count, err := storage.CountPendingOutboxItems("mock"); err != nil || count != 2 {
+		t.Fatalf("expected 2 queued items, got %d (%v)", count, err)
+	}
+
+	if err := client.ConnectWithOptions(server.ConnectOptions()); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(client.Disconnect)
+	waitUntil(t, "outbox to drain", func() bool {
+		count, err := storage.CountPendingOutboxItems("mock")
+		return err == nil && count == 0
+	})
+	requests := server.Requests("sync_matchers")
+	if len(requests) != 2 {
+		t.Fatalf("expected 2 replayed sync_matchers requests, got %d", len(requests))
+	}
+	if requests[0].Params["new_roster"] != `{"bots":{"1":{}}}` {
+		t.Fatalf("requests replayed out of order: %+v", requests)
+	}
+	if _, ok := server.Matchers()["bots"].(map[string]interface{})["2"]; !ok {
+		t.Fatalf("server roster does not reflect the last replayed mutation: %+v", server.Matchers())
+	}
+}
+
+func TestOutboxGuardConflictRetry(t *testing.T) {
+	storage := openStorage(t)
+	server := startServer(t, mockserver.Options{Fixtures: mockserver.DemoFixtures()})
+	outbox := network.NewOutbox(storage, quietLogger())
+	manager := network.NewManager(quietLogger())
+	manager.SetOutbox(outbox)
+	client, err := manager.Add("mock")
+	if err != nil {
+		t.Fatalf("add client: %v", err)
+	}
+	client.SetReconnectEnabled(false)
+	t.Cleanup(manager.DisconnectAll)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.ConnectWithOptions(server.ConnectOptions()); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	response, err := client.Call(ctx, "get_matchers", map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("get matchers: %v", err)
+	}
+	matchers, err := protocol.As[*protocol.Matchers](protocol.DecodeResponse("", "get_matchers", response))
+	if err != nil {
+		t.Fatalf("decode matchers: %v", err)
+	}
+	guard := &network.Guard{Method: "get_matchers", Params: map[string]interface{}{}, Fingerprint: network.StateFingerprint(matchers.Roster)}
+	client.Disconnect()
+
+	for _, roster := range []string{`{"bots":{"1":{}}}`, `{"bots":{"2":{}}}`} {
+		_, err := client.Submit(ctx, network.Mutation{Method: "sync_matchers", Params: map[string]interface{}{"new_roster": roster}, Guard: guard})
+		if !errors.Is(err, network.ErrQueued) {
+			t.Fatalf("expected mutation to be queued while offline, got %v", err)
+		}
+	}
+	if err := client.ConnectWithOptions(server.ConnectOptions()); err != nil {
+		t.Fatalf("reconnect: %v", err)
+	}
+	var conflict data.OutboxItem
+	waitUntil(t, "second item to conflict", func() bool {
+		items, err := storage.ListOutboxItems("mock")
+		if err != nil || len(items) != 1 || items[0].Status != data.OutboxConflict {
+			return false
+		}
+		conflict = items[0]
+		return true
+	})
+	if got := len(server.Requests("sync_matchers")); got != 1 {
+		t.Fatalf("expected the unchanged guard to pass once, got %d syncs", got)
+	}
+
+	if _, err := client.Submit(ctx, network.Mutation{Method: "sync_matchers", Params: map[string]interface{}{"new_roster": `{"bots":{"3":{}}}`}}); err != nil {
+		t.Fatalf("expected a conflicted item not to hold back new requests, got %v", err)
+	}
+	if err := outbox.Retry(conflict); err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+	waitUntil(t, "conflicted item to be sent anyway", func() bool {
+		items, err := storage.ListOutboxItems("mock")
+		return err == nil && len(items) == 0
+	})
+	if _, ok := server.Matchers()["bots"].(map[string]interface{})["2"]; !ok {
+		t.Fatalf("server roster does not reflect the retried mutation: %+v", server.Matchers())
+	}
+}
+
+func TestOutboxBotSwitchGuardConflict(t *testing.T) {
+	storage := openStorage(t)
+	server := startServer(t, mockserver.Options{})
+	outbox := network.NewOutbox(storage, quietLogger())
+	manager := network.NewManager(quietLogger())
+	manager.SetOutbox(outbox)
+	client, err := manager.Add("mock")
+	if err != nil {
+		t.Fatalf("add client: %v", err)
+	}
+	client.SetReconnectEnabled(false)
+	t.Cleanup(manager.DisconnectAll)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	switchOff := func(botID string) network.Mutation {
+		return network.Mutation{
+			Method: "bot_switch",
+			Params: map[string]interface{}{"bot_id": botID, "platform": "qq", "is_online_now": false},
+			Guard: &network.Guard{
+				Method:      "get_bot_status",
+				Params:      map[string]interface{}{"bot_id": botID, "platform": "qq"},
+				Fingerprint: network.StateFingerprint(true),
+			},
+		}
+	}
+	for _, botID := range []string{"1", "2"} {
+		if _, err := client.Submit(ctx, switchOff(botID)); !errors.Is(err, network.ErrQueued) {
+			t.Fatalf("expected mutation to be queued while offline, got %v", err)
+		}
+	}
+	server.SetBotOnline("2", false)
+	if err := client.ConnectWithOptions(server.ConnectOptions()); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	waitUntil(t, "second switch to conflict", func() bool {
+		items, err := storage.ListOutboxItems("mock")
+		return err == nil && len(items) == 1 && items[0].Status == data.OutboxConflict
+	})
+	requests := server.Requests("bot_switch")
+	if len(requests) != 1 || requests[0].Params["bot_id"] != "1" {
+		t.Fatalf("expected only the unchanged bot to be switched, got %+v", requests)
+	}
+}
+
+func TestOutboxFailedItemHoldsBackLaterItems(t *testing.T) {
+	storage := openStorage(t)
+	server := startServer(t, mockserver.Options{Fixtures: mockserver.DemoFixtures()})
+	outbox := network.NewOutbox(storage, quietLogger())
+	manager := network.NewManager(quietLogger())
+	manager.SetOutbox(outbox)
+	client, err := manager.Add("mock")
+	if err != nil {
+		t.Fatalf("add client: %v", err)
+	}
+	client.SetReconnectEnabled(false)
+	t.Cleanup(manager.DisconnectAll)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, mutation := range []network.Mutation{
+		{Method: "no_such_method", Params: map[string]interface{}{}},
+		{Method: "sync_matchers", Params: map[string]interface{}{"new_roster": `{"bots":{"1":{}}}`}},
+	} {
+		if _, err := client.Submit(ctx, mutation); !errors.Is(err, network.ErrQueued) {
+			t.Fatalf("expected mutation to be queued while offline, got %v", err)
+		}
+	}
+	if err := client.ConnectWithOptions(server.ConnectOptions()); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	var failed data.OutboxItem
+	waitUntil(t, "first item to fail", func() bool {
+		items, err := storage.ListOutboxItems("mock")
+		if err != nil || len(items) != 2 || items[0].Status != data.OutboxFailed {
+			return false
+		}
+		failed = items[0]
+		return true
+	})
+	time.Sleep(100 * time.Millisecond)
+	if got := len(server.Requests("sync_matchers")); got != 0 {
+		t.Fatalf("a later item overtook the failed one: %d syncs", got)
+	}
+	if items, err := storage.ListOutboxItems("mock"); err != nil || items[1].Status != data.OutboxPending {
+		t.Fatalf("expected the later item to stay pending, got %+v (%v)", items, err)
+	}
+
+	if err := outbox.Discard(failed); err != nil {
+		t.Fatalf("discard: %v", err)
+	}
+	waitUntil(t, "later item to be replayed", func() bool {
+		items, err := storage.ListOutboxItems("mock")
+		return err == nil && len(items) == 0
+	})
+	if got := len(server.Requests("sync_matchers")); got != 1 {
+		t.Fatalf("expected the later item to be sent once, got %d syncs", got)
+	}
+}
@@ -4,9 +4,10 @@ import (
 	"fmt"
 	"strings"
 	"time"
+	"lazytea-mobile/internal/protocol"
 )
 const (
-	ProtocolVersion = "1.0"
+	ProtocolVersion = protocol.CurrentVersion
 	Separator       = "\x1e"  
 )
 type MessageHeader struct {
@@ -14,6 +15,9 @@ type MessageHeader struct {
 	MsgType       string  `json:"msg_type"`  
 	CorrelationID *string `json:"correlation_id,omitempty"`
 	Timestamp     float64 `json:"timestamp"`
+	Server        string  `json:"-"`
+	Version       string  `json:"-"`
+	Replayed      bool    `json:"-"`
 }
 type ProtocolMessage struct {
 	Version string        `json:"version"`
@@ -51,6 +55,7 @@ func DecodeMessage(rawData string) (*MessageHeader, interface{}, error) {
 	if err := json.Unmarshal([]byte(msgData), &message); err != nil {
 		return nil, nil, fmt.Errorf("failed to unmarshal message: %w", err)
 	}
+	message.Header.Version = message.Version
 	return &message.Header, message.Payload, nil
 }
 func NewRequestHeader(msgID string, correlationID *string) MessageHeader {
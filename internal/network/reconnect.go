@@ -0,0 +1,267 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"lazytea-mobile/internal/protocol"
+)
+
+type ConnectionState int
+
+const (
+	StateIdle ConnectionState = iota
+	StateConnecting
+	StateConnected
+	StateBackoff
+	StateGaveUp
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateBackoff:
+		return "backoff"
+	case StateGaveUp:
+		return "gave-up"
+	default:
+		return "idle"
+	}
+}
+
+type StateChange struct {
+	State   ConnectionState
+	Attempt int
+	Delay   time.Duration
+	Err     error
+	At      time.Time
+}
+
+type BackoffPolicy struct {
+	Initial     time.Duration
+	Max         time.Duration
+	Multiplier  float64
+	Jitter      float64
+	MaxAttempts int
+}
+
+func DefaultBackoffPolicy() BackoffPolicy {
+	return BackoffPolicy{
+		Initial:     time.Second,
+		Max:         time.Minute,
+		Multiplier:  2,
+		Jitter:      0.2,
+		MaxAttempts: 10,
+	}
+}
+
+func (p BackoffPolicy) Delay(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	delay := float64(p.Initial) * math.Pow(multiplier, float64(attempt-1))
+	if p.Jitter > 0 {
+		delay += delay * p.Jitter * (rand.Float64()*2 - 1)
+	}
+	if p.Max > 0 && delay > float64(p.Max) {
+		delay = float64(p.Max)
+	}
+	if delay < 0 {
+		delay = 0
+	}
+	return time.Duration(delay)
+}
+
+const stateWatchBuffer = 16
+
+type reconnector struct {
+	mutex    sync.Mutex
+	enabled  bool
+	policy   BackoffPolicy
+	state    StateChange
+	attempt  int
+	timer    *time.Timer
+	watchers map[chan StateChange]struct{}
+}
+
+func newReconnector() *reconnector {
+	return &reconnector{
+		enabled:  true,
+		policy:   DefaultBackoffPolicy(),
+		state:    StateChange{State: StateIdle, At: time.Now()},
+		watchers: make(map[chan StateChange]struct{}),
+	}
+}
+
+func (c *Client) setState(change StateChange) {
+	change.At = time.Now()
+	r := c.reconnect
+	r.mutex.Lock()
+	r.state = change
+	watchers := make([]chan StateChange, 0, len(r.watchers))
+	for ch := range r.watchers {
+		watchers = append(watchers, ch)
+	}
+	r.mutex.Unlock()
+	c.logger.Debug("连接状态: %s", change.State)
+	for _, ch := range watchers {
+		select {
+		case ch <- change:
+		default:
+			select {
+			case <-ch:
+			default:
+			}
+			select {
+			case ch <- change:
+			default:
+			}
+		}
+	}
+}
+
+func (c *Client) State() StateChange {
+	c.reconnect.mutex.Lock()
+	defer c.reconnect.mutex.Unlock()
+	return c.reconnect.state
+}
+
+func (c *Client) WatchState() (<-chan StateChange, func()) {
+	ch := make(chan StateChange, stateWatchBuffer)
+	r := c.reconnect
+	r.mutex.Lock()
+	r.watchers[ch] = struct{}{}
+	ch <- r.state
+	r.mutex.Unlock()
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			r.mutex.Lock()
+			delete(r.watchers, ch)
+			r.mutex.Unlock()
+		})
+	}
+}
+
+func (c *Client) SetReconnectEnabled(enabled bool) {
+	r := c.reconnect
+	r.mutex.Lock()
+	r.enabled = enabled
+	r.mutex.Unlock()
+	if !enabled {
+		c.stopReconnect()
+	}
+}
+
+func (c *Client) SetBackoffPolicy(policy BackoffPolicy) {
+	c.reconnect.mutex.Lock()
+	defer c.reconnect.mutex.Unlock()
+	c.reconnect.policy = policy
+}
+
+func (c *Client) BackoffPolicy() BackoffPolicy {
+	c.reconnect.mutex.Lock()
+	defer c.reconnect.mutex.Unlock()
+	return c.reconnect.policy
+}
+
+func (c *Client) ReconnectNow() bool {
+	c.mutex.RLock()
+	connected := c.connected
+	host := c.options.Host
+	c.mutex.RUnlock()
+	if connected || c.State().State == StateConnecting {
+		return true
+	}
+	if host == "" {
+		return false
+	}
+	c.stopReconnect()
+	go c.reconnectTick()
+	return true
+}
+
+func (c *Client) stopReconnect() {
+	r := c.reconnect
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.timer != nil {
+		r.timer.Stop()
+		r.timer = nil
+	}
+	r.attempt = 0
+}
+
+func (c *Client) resetAttempts() {
+	c.reconnect.mutex.Lock()
+	defer c.reconnect.mutex.Unlock()
+	c.reconnect.attempt = 0
+}
+
+func (c *Client) scheduleReconnect(cause error) {
+	r := c.reconnect
+	r.mutex.Lock()
+	if !r.enabled {
+		r.mutex.Unlock()
+		c.failPending(fmt.Errorf("connection lost"), false)
+		c.setState(StateChange{State: StateIdle, Err: cause})
+		return
+	}
+	r.attempt++
+	attempt := r.attempt
+	if r.policy.MaxAttempts > 0 && attempt > r.policy.MaxAttempts {
+		r.mutex.Unlock()
+		c.giveUp(cause)
+		return
+	}
+	delay := r.policy.Delay(attempt)
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+	r.timer = time.AfterFunc(delay, c.reconnectTick)
+	r.mutex.Unlock()
+	c.logger.Info("%v 后尝试第 %d 次重连", delay.Round(time.Millisecond), attempt)
+	c.setState(StateChange{State: StateBackoff, Attempt: attempt, Delay: delay, Err: cause})
+}
+
+func (c *Client) reconnectTick() {
+	r := c.reconnect
+	r.mutex.Lock()
+	r.timer = nil
+	attempt := r.attempt
+	r.mutex.Unlock()
+	c.mutex.RLock()
+	options := c.options
+	connected := c.connected
+	c.mutex.RUnlock()
+	if connected {
+		return
+	}
+	err := c.connect(options, attempt)
+	if err == nil {
+		return
+	}
+	c.logger.Error("自动重连失败: %v", err)
+	if errors.Is(err, protocol.ErrIncompatibleVersion) || errors.Is(err, protocol.ErrUnsupportedVersion) {
+		c.giveUp(err)
+		return
+	}
+	c.scheduleReconnect(err)
+}
+
+func (c *Client) giveUp(cause error) {
+	c.logger.Error("已放弃自动重连: %v", cause)
+	c.failPending(fmt.Errorf("reconnect gave up: %w", cause), false)
+	c.setState(StateChange{State: StateGaveUp, Err: cause})
+}
@@ -0,0 +1,107 @@
+package network_test
+
+import (
+	"testing"
+	"time"
+
+	"lazytea-mobile/internal/mockserver"
+	"lazytea-mobile/internal/network"
+	"lazytea-mobile/internal/utils"
+)
+
+func newReconnectingClient(t *testing.T, maxAttempts int) *network.Client {
+	t.Helper()
+	logger := utils.NewLogger()
+	logger.SetLevel(utils.ERROR)
+	client := network.NewClient(logger)
+	client.SetBackoffPolicy(network.BackoffPolicy{
+		Initial:     20 * time.Millisecond,
+		Max:         50 * time.Millisecond,
+		Multiplier:  2,
+		MaxAttempts: maxAttempts,
+	})
+	t.Cleanup(client.Disconnect)
+	return client
+}
+
+// awaitState reads state changes until one reaches want and returns the
+// backoff attempts seen on the way.
+func awaitState(t *testing.T, changes <-chan network.StateChange, want network.ConnectionState) []int {
+	t.Helper()
+	var attempts []int
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case change := <-changes:
+			if change.State == network.StateBackoff {
+				attempts = append(attempts, change.Attempt)
+			}
+			if change.State == want {
+				return attempts
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for state %s (backoff attempts %v)", want, attempts)
+		}
+	}
+}
+
+func TestClientReconnectsAfterDrop(t *testing.T) {
+	server := startServer(t, mockserver.Options{})
+	client := newReconnectingClient(t, 5)
+	if err := client.ConnectWithOptions(server.ConnectOptions()); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	changes, stop := client.WatchState()
+	defer stop()
+	awaitState(t, changes, network.StateConnected)
+
+	server.DropConnections()
+	if attempts := awaitState(t, changes, network.StateConnected); len(attempts) != 1 || attempts[0] != 1 {
+		t.Fatalf("expected one backoff before reconnecting, got %v", attempts)
+	}
+	if !client.IsConnected() {
+		t.Fatal("client is not connected after reconnecting")
+	}
+
+	server.DropConnections()
+	if attempts := awaitState(t, changes, network.StateConnected); len(attempts) != 1 || attempts[0] != 1 {
+		t.Fatalf("expected the attempt count to reset after a reconnect, got %v", attempts)
+	}
+}
+
+func TestClientGivesUpAfterMaxAttempts(t *testing.T) {
+	server := startServer(t, mockserver.Options{})
+	client := newReconnectingClient(t, 3)
+	if err := client.ConnectWithOptions(server.ConnectOptions()); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	changes, stop := client.WatchState()
+	defer stop()
+	awaitState(t, changes, network.StateConnected)
+
+	server.Close()
+	attempts := awaitState(t, changes, network.StateGaveUp)
+	if len(attempts) != 3 || attempts[0] != 1 || attempts[1] != 2 || attempts[2] != 3 {
+		t.Fatalf("expected backoff attempts 1, 2 and 3 before giving up, got %v", attempts)
+	}
+	select {
+	case change := <-changes:
+		t.Fatalf("unexpected state %s after giving up", change.State)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestFailedConnectDoesNotReconnect(t *testing.T) {
+	server := startServer(t, mockserver.Options{DropHandshake: true})
+	client := newReconnectingClient(t, 5)
+	if err := client.ConnectWithOptions(server.ConnectOptions()); err == nil {
+		t.Fatal("expected a connection dropped during the handshake to fail")
+	}
+	time.Sleep(300 * time.Millisecond)
+	if state := client.State().State; state != network.StateIdle {
+		t.Fatalf("expected the client to stay idle after a failed connect, got %s", state)
+	}
+	if requests := server.Requests("handshake"); len(requests) != 1 {
+		t.Fatalf("expected a single handshake, got %d", len(requests))
+	}
+}
@@ -0,0 +1,198 @@
+package network
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type RecordDirection string
+
+const (
+	RecordInbound  RecordDirection = "in"
+	RecordOutbound RecordDirection = "out"
+)
+
+type RecordEntry struct {
+	At        time.Time       `json:"at"`
+	Direction RecordDirection `json:"dir"`
+	Server    string          `json:"server,omitempty"`
+	Message   ProtocolMessage `json:"message"`
+}
+
+type Recorder struct {
+	mutex   sync.Mutex
+	closer  io.Closer
+	encoder *json.Encoder
+	count   int
+	err     error
+}
+
+func NewRecorder(path string) (*Recorder, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open recording: %w", err)
+	}
+	recorder := NewRecorderWriter(file)
+	recorder.closer = file
+	return recorder, nil
+}
+
+func NewRecorderWriter(w io.Writer) *Recorder {
+	return &Recorder{encoder: json.NewEncoder(w)}
+}
+
+func (r *Recorder) record(direction RecordDirection, header MessageHeader, payload interface{}) {
+	version := header.Version
+	if version == "" {
+		version = ProtocolVersion
+	}
+	entry := RecordEntry{
+		At:        time.Now(),
+		Direction: direction,
+		Server:    header.Server,
+		Message:   ProtocolMessage{Version: version, Header: header, Payload: payload},
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.err != nil {
+		return
+	}
+	if err := r.encoder.Encode(entry); err != nil {
+		r.err = fmt.Errorf("failed to write recording: %w", err)
+		return
+	}
+	r.count++
+}
+
+func (r *Recorder) Count() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.count
+}
+
+func (r *Recorder) Err() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.err
+}
+
+func (r *Recorder) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closer == nil {
+		return r.err
+	}
+	err := r.closer.Close()
+	r.closer = nil
+	if r.err != nil {
+		return r.err
+	}
+	return err
+}
+
+func LoadRecording(path string) ([]RecordEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open recording: %w", err)
+	}
+	defer file.Close()
+	return ReadRecording(file)
+}
+
+func ReadRecording(r io.Reader) ([]RecordEntry, error) {
+	var entries []RecordEntry
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxPartialRecord)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry RecordEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return entries, fmt.Errorf("invalid recording entry on line %d: %w", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return entries, fmt.Errorf("failed to read recording: %w", err)
+	}
+	return entries, nil
+}
+
+func (c *Client) SetRecorder(recorder *Recorder) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.recorder = recorder
+}
+
+func (c *Client) Recorder() *Recorder {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.recorder
+}
+
+func (c *Client) recordMessage(direction RecordDirection, header MessageHeader, payload interface{}) {
+	c.mutex.RLock()
+	recorder := c.recorder
+	header.Server = c.server
+	c.mutex.RUnlock()
+	if recorder != nil {
+		recorder.record(direction, header, payload)
+	}
+}
+
+// Replay feeds the recorded inbound messages back through the same decode
+// and handling path as live frames, with Replayed set on the header so
+// pages can show them without persisting them. Recorded responses and
+// heartbeat acks go through that path too but never reach the calls and
+// liveness of this client. It returns how many events reached subscribers.
+func (c *Client) Replay(ctx context.Context, entries []RecordEntry, speed float64) (int, error) {
+	decoder := NewStreamDecoder()
+	var previous time.Time
+	replayed := 0
+	for _, entry := range entries {
+		if entry.Direction != RecordInbound {
+			continue
+		}
+		if speed > 0 && !previous.IsZero() {
+			if delay := time.Duration(float64(entry.At.Sub(previous)) / speed); delay > 0 {
+				timer := time.NewTimer(delay)
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return replayed, ctx.Err()
+				case <-timer.C:
+				}
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return replayed, err
+		}
+		previous = entry.At
+		frame, err := json.Marshal(entry.Message)
+		if err != nil {
+			c.logger.Error("重放失败: %v", err)
+			continue
+		}
+		replayed += c.deliverMessages(decoder, websocket.TextMessage, append(frame, Separator...), true)
+	}
+	return replayed, nil
+}
+
+func (c *Client) ReplayFile(ctx context.Context, path string, speed float64) (int, error) {
+	entries, err := LoadRecording(path)
+	if err != nil {
+		return 0, err
+	}
+	return c.Replay(ctx, entries, speed)
+}
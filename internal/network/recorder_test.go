@@ -0,0 +1,108 @@
+package network_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"lazytea-mobile/internal/mockserver"
+	"lazytea-mobile/internal/network"
+)
+
+func TestRecordAndReplaySession(t *testing.T) {
+	script := []mockserver.Event{
+		{Type: "bot_connect", Payload: map[string]interface{}{"bot": "10001"}},
+		{After: 50 * time.Millisecond, Type: "message", Payload: map[string]interface{}{"bot": "10001", "content": "hello"}},
+		{Type: "bot_disconnect", Payload: map[string]interface{}{"bot": "10001"}},
+	}
+	server := startServer(t, mockserver.Options{Script: script})
+	var buf bytes.Buffer
+	recorder := network.NewRecorderWriter(&buf)
+	client := newClient()
+	client.SetRecorder(recorder)
+	done := make(chan struct{})
+	client.OnMessage("bot_disconnect", func(header network.MessageHeader, payload interface{}) {
+		close(done)
+	})
+	if err := client.ConnectWithOptions(server.ConnectOptions()); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the scripted session")
+	}
+	client.SetRecorder(nil)
+	client.Disconnect()
+	if err := recorder.Err(); err != nil {
+		t.Fatalf("recorder: %v", err)
+	}
+
+	entries, err := network.ReadRecording(&buf)
+	if err != nil {
+		t.Fatalf("read recording: %v", err)
+	}
+	var sawHandshake bool
+	var inbound []string
+	var first, last time.Time
+	for _, entry := range entries {
+		if entry.Direction == network.RecordInbound {
+			if first.IsZero() {
+				first = entry.At
+			}
+			last = entry.At
+		}
+		if entry.Direction == network.RecordOutbound && entry.Message.Header.MsgType == "request" {
+			sawHandshake = true
+		}
+		if entry.Direction == network.RecordInbound && entry.Message.Header.MsgType != "response" {
+			inbound = append(inbound, entry.Message.Header.MsgType)
+		}
+	}
+	if !sawHandshake {
+		t.Fatal("outbound handshake request was not recorded")
+	}
+	if len(inbound) != 3 {
+		t.Fatalf("expected 3 recorded events, got %v", inbound)
+	}
+
+	entries = append(entries, network.RecordEntry{
+		At:        last,
+		Direction: network.RecordInbound,
+		Message:   network.ProtocolMessage{Version: network.ProtocolVersion, Header: network.MessageHeader{MsgID: "ack", MsgType: "heartbeat_ack"}},
+	})
+
+	replay := newClient()
+	replay.SetServerName("replay")
+	received := make(chan network.MessageHeader, 8)
+	options := network.DefaultSubscribeOptions()
+	options.Lanes = 1
+	replay.OnMessageWithOptions(network.WildcardType, func(header network.MessageHeader, payload interface{}) {
+		received <- header
+	}, options)
+	started := time.Now()
+	replayed, err := replay.Replay(context.Background(), entries, 10)
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	if elapsed, recorded := time.Since(started), last.Sub(first); elapsed >= recorded {
+		t.Fatalf("accelerated replay took %v for a %v recording", elapsed, recorded)
+	}
+	if replayed != 3 {
+		t.Fatalf("expected 3 replayed events without the recorded responses, got %d", replayed)
+	}
+	if !replay.LastAck().IsZero() {
+		t.Fatalf("a recorded heartbeat ack counted towards the replaying client: %v", replay.LastAck())
+	}
+	for _, want := range []string{"bot_connect", "message", "bot_disconnect"} {
+		select {
+		case header := <-received:
+			if header.MsgType != want || header.Server != "replay" || !header.Replayed || header.Version == "" {
+				t.Fatalf("expected replayed %s from replay, got %+v", want, header)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for replayed %s", want)
+		}
+	}
+}
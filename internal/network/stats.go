@@ -0,0 +1,108 @@
+package network
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+type MethodStats struct {
+	Method      string
+	InFlight    int
+	Completed   int
+	Errors      int
+	Timeouts    int
+	LastLatency time.Duration
+	AvgLatency  time.Duration
+	MaxLatency  time.Duration
+	total       time.Duration
+}
+
+type requestStats struct {
+	mutex   sync.Mutex
+	methods map[string]*MethodStats
+}
+
+func newRequestStats() *requestStats {
+	return &requestStats{methods: make(map[string]*MethodStats)}
+}
+
+func (s *requestStats) entry(method string) *MethodStats {
+	stats, ok := s.methods[method]
+	if !ok {
+		stats = &MethodStats{Method: method}
+		s.methods[method] = stats
+	}
+	return stats
+}
+
+func (s *requestStats) begin(method string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.entry(method).InFlight++
+}
+
+func (s *requestStats) finish(method string, latency time.Duration, err error, timedOut bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stats := s.entry(method)
+	if stats.InFlight > 0 {
+		stats.InFlight--
+	}
+	switch {
+	case timedOut:
+		stats.Timeouts++
+		return
+	case err != nil:
+		stats.Errors++
+	}
+	stats.Completed++
+	stats.total += latency
+	stats.LastLatency = latency
+	stats.AvgLatency = stats.total / time.Duration(stats.Completed)
+	if latency > stats.MaxLatency {
+		stats.MaxLatency = latency
+	}
+}
+
+func (s *requestStats) snapshot() []MethodStats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	result := make([]MethodStats, 0, len(s.methods))
+	for _, stats := range s.methods {
+		result = append(result, *stats)
+	}
+	sortMethodStats(result)
+	return result
+}
+
+func sortMethodStats(stats []MethodStats) {
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Method < stats[j].Method
+	})
+}
+
+func mergeMethodStats(target map[string]*MethodStats, stats []MethodStats) {
+	for _, s := range stats {
+		merged, ok := target[s.Method]
+		if !ok {
+			copied := s
+			target[s.Method] = &copied
+			continue
+		}
+		merged.InFlight += s.InFlight
+		merged.Completed += s.Completed
+		merged.Errors += s.Errors
+		merged.Timeouts += s.Timeouts
+		merged.total += s.total
+		if s.MaxLatency > merged.MaxLatency {
+			merged.MaxLatency = s.MaxLatency
+		}
+		if s.LastLatency > 0 {
+			merged.LastLatency = s.LastLatency
+		}
+		if merged.Completed > 0 {
+			merged.AvgLatency = merged.total / time.Duration(merged.Completed)
+		}
+	}
+}
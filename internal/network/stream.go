@@ -0,0 +1,113 @@
+package network
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const maxPartialRecord = 16 << 20
+
+type DecodedMessage struct {
+	Header  *MessageHeader
+	Payload interface{}
+}
+
+type StreamDecoder struct {
+	partial []byte
+}
+
+func NewStreamDecoder() *StreamDecoder {
+	return &StreamDecoder{}
+}
+
+func (d *StreamDecoder) Feed(messageType int, raw []byte) ([]DecodedMessage, []error) {
+	if messageType == websocket.BinaryMessage {
+		return decodeBinaryRecords(raw)
+	}
+	data := append(d.partial, raw...)
+	d.partial = nil
+	var messages []DecodedMessage
+	var errs []error
+	for {
+		idx := bytes.IndexByte(data, Separator[0])
+		if idx < 0 {
+			break
+		}
+		record := data[:idx]
+		data = data[idx+1:]
+		if len(bytes.TrimSpace(record)) == 0 {
+			continue
+		}
+		header, payload, err := DecodeMessage(string(record))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		messages = append(messages, DecodedMessage{Header: header, Payload: payload})
+	}
+	rest := bytes.TrimSpace(data)
+	switch {
+	case len(rest) == 0:
+	case json.Valid(rest):
+		header, payload, err := DecodeMessage(string(rest))
+		if err != nil {
+			errs = append(errs, err)
+			break
+		}
+		messages = append(messages, DecodedMessage{Header: header, Payload: payload})
+	case len(rest) > maxPartialRecord:
+		errs = append(errs, fmt.Errorf("partial record exceeds %d bytes, discarded", maxPartialRecord))
+	default:
+		if err := unfinishedRecord(rest); err != nil {
+			errs = append(errs, err)
+			break
+		}
+		d.partial = append([]byte(nil), rest...)
+	}
+	return messages, errs
+}
+
+// unfinishedRecord returns nil when tail is a JSON object cut off mid-way,
+// the only shape worth holding for the next frame.
+func unfinishedRecord(tail []byte) error {
+	if tail[0] != '{' {
+		return fmt.Errorf("failed to unmarshal message: unexpected %q before separator", tail[0])
+	}
+	var record json.RawMessage
+	err := json.NewDecoder(bytes.NewReader(tail)).Decode(&record)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil
+	}
+	if err == nil {
+		err = errors.New("trailing data after record")
+	}
+	return fmt.Errorf("failed to unmarshal message: %w", err)
+}
+
+func decodeBinaryRecords(raw []byte) ([]DecodedMessage, []error) {
+	var messages []DecodedMessage
+	reader := bytes.NewReader(raw)
+	dec := msgpack.NewDecoder(reader)
+	for reader.Len() > 0 {
+		start := len(raw) - reader.Len()
+		var record msgpack.RawMessage
+		if err := dec.Decode(&record); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return messages, []error{fmt.Errorf("truncated binary record at offset %d", start)}
+			}
+			return messages, []error{fmt.Errorf("failed to unmarshal message: %w", err)}
+		}
+		header, payload, err := DecodeFrame(websocket.BinaryMessage, record)
+		if err != nil {
+			return messages, []error{err}
+		}
+		messages = append(messages, DecodedMessage{Header: header, Payload: payload})
+	}
+	return messages, nil
+}
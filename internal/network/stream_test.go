@@ -0,0 +1,62 @@
+package network_test
+
+import (
+	"strings"
+	"testing"
+
+	"lazytea-mobile/internal/network"
+
+	"github.com/gorilla/websocket"
+)
+
+func encodeRecord(t *testing.T, msgType string) string {
+	t.Helper()
+	record, err := network.EncodeMessage(network.MessageHeader{MsgID: msgType + "-1", MsgType: msgType}, map[string]interface{}{"bot": "10001"})
+	if err != nil {
+		t.Fatalf("encode %s: %v", msgType, err)
+	}
+	return record
+}
+
+func TestStreamDecoderFeed(t *testing.T) {
+	first := encodeRecord(t, "bot_connect")
+	second := encodeRecord(t, "message")
+	third := encodeRecord(t, "plugin_call")
+	cut := len(second) / 2
+	cases := []struct {
+		name   string
+		frames []string
+		want   []string
+		errs   int
+	}{
+		{"single record", []string{first}, []string{"bot_connect"}, 0},
+		{"record without separator", []string{strings.TrimSuffix(first, network.Separator)}, []string{"bot_connect"}, 0},
+		{"multiple records per frame", []string{first + second + third}, []string{"bot_connect", "message", "plugin_call"}, 0},
+		{"record split across frames", []string{first + second[:cut], second[cut:]}, []string{"bot_connect", "message"}, 0},
+		{"record split three ways", []string{second[:5], second[5:cut], second[cut:] + third}, []string{"message", "plugin_call"}, 0},
+		{"trailing separators", []string{first + network.Separator + network.Separator, " \n" + network.Separator}, []string{"bot_connect"}, 0},
+		{"malformed record before separator", []string{"not json" + network.Separator + first}, []string{"bot_connect"}, 1},
+		{"malformed tail does not swallow next frame", []string{first + "garbage", second}, []string{"bot_connect", "message"}, 1},
+		{"broken object tail does not swallow next frame", []string{`{"version": ]`, second}, []string{"message"}, 1},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			decoder := network.NewStreamDecoder()
+			var got []string
+			errs := 0
+			for _, frame := range tc.frames {
+				messages, frameErrs := decoder.Feed(websocket.TextMessage, []byte(frame))
+				errs += len(frameErrs)
+				for _, message := range messages {
+					got = append(got, message.Header.MsgType)
+				}
+			}
+			if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+				t.Fatalf("expected %v, got %v", tc.want, got)
+			}
+			if errs != tc.errs {
+				t.Fatalf("expected %d errors, got %d", tc.errs, errs)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package network
+
+import (
+	"strings"
+	"sync"
+)
+
+const WildcardType = "*"
+
+type Subscription struct {
+	once   sync.Once
+	cancel func()
+}
+
+func newSubscription(cancel func()) *Subscription {
+	return &Subscription{cancel: cancel}
+}
+
+func (s *Subscription) Close() {
+	if s == nil {
+		return
+	}
+	s.once.Do(func() {
+		if s.cancel != nil {
+			s.cancel()
+		}
+	})
+}
+
+func MatchType(pattern, msgType string) bool {
+	if pattern == WildcardType || pattern == msgType {
+		return true
+	}
+	if prefix, ok := strings.CutSuffix(pattern, WildcardType); ok {
+		return strings.HasPrefix(msgType, prefix)
+	}
+	return false
+}
+
+type connectionSubscription struct {
+	id       uint64
+	callback ConnectionCallback
+}
@@ -0,0 +1,192 @@
+package network
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"lazytea-mobile/internal/data"
+
+	"github.com/gorilla/websocket"
+)
+
+var ErrCertificatePinMismatch = errors.New("certificate does not match any pinned SHA-256 fingerprint")
+
+type TLSOptions struct {
+	Enabled         bool
+	CABundle        string
+	PinnedSHA256    []string
+	AllowSelfSigned bool
+}
+
+type ConnectOptions struct {
+	Host        string
+	Port        int
+	Token       string
+	Auth        AuthMode
+	TLS         TLSOptions
+	Compression bool
+	Encoding    Encoding
+	// Legacy skips the handshake probe for servers known to predate it.
+	Legacy bool
+}
+
+// ProfileConnectOptions builds the options for dialing a saved profile.
+// Pins are validated again when the TLS config is built.
+func ProfileConnectOptions(profile data.ConnectionProfile) ConnectOptions {
+	return ConnectOptions{
+		Host:  profile.Host,
+		Port:  profile.Port,
+		Token: profile.Token,
+		Auth:  AuthMode(profile.AuthMode),
+		TLS: TLSOptions{
+			Enabled:         profile.TLS,
+			CABundle:        profile.CABundle,
+			PinnedSHA256:    splitPins(profile.PinnedSHA256),
+			AllowSelfSigned: profile.AllowSelfSigned,
+		},
+		Compression: profile.Compression,
+		Encoding:    Encoding(profile.Encoding),
+		Legacy:      profile.LegacyServer,
+	}
+}
+
+// RememberLegacy saves on the profile whether the connected server fell
+// back to the legacy protocol.
+func RememberLegacy(storage *data.Storage, profile data.ConnectionProfile, client *Client) error {
+	info := client.ServerInfo()
+	if storage == nil || profile.Name == "" || info == nil || info.Legacy == profile.LegacyServer {
+		return nil
+	}
+	return storage.SetProfileLegacy(profile.Name, info.Legacy)
+}
+
+func (o ConnectOptions) scheme() string {
+	if o.TLS.Enabled {
+		return "wss"
+	}
+	return "ws"
+}
+
+func splitPins(raw string) []string {
+	return strings.FieldsFunc(raw, func(r rune) bool {
+		return r == ',' || r == ';' || r == '\n' || r == '\r' || r == ' ' || r == '\t'
+	})
+}
+
+// ParsePins normalizes a list of SHA-256 pins to lowercase hex and rejects
+// any entry that is not a 32-byte hex or base64 digest.
+func ParsePins(raw string) ([]string, error) {
+	fields := splitPins(raw)
+	pins := make([]string, 0, len(fields))
+	for _, field := range fields {
+		pin, err := normalizePin(field)
+		if err != nil {
+			return nil, err
+		}
+		if pin != "" {
+			pins = append(pins, pin)
+		}
+	}
+	return pins, nil
+}
+
+func normalizePin(pin string) (string, error) {
+	raw := strings.TrimSpace(pin)
+	pin = strings.TrimPrefix(strings.TrimPrefix(raw, "sha256/"), "sha256:")
+	if pin == "" {
+		return "", nil
+	}
+	if decoded, err := hex.DecodeString(strings.ReplaceAll(pin, ":", "")); err == nil && len(decoded) == sha256.Size {
+		return hex.EncodeToString(decoded), nil
+	}
+	if decoded, err := base64.StdEncoding.DecodeString(pin); err == nil && len(decoded) == sha256.Size {
+		return hex.EncodeToString(decoded), nil
+	}
+	return "", fmt.Errorf("证书指纹 %q 无效: 需要 64 位十六进制或 base64 编码的 SHA-256", raw)
+}
+
+func (o TLSOptions) buildConfig(host string) (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName: host,
+		MinVersion: tls.VersionTLS12,
+	}
+	if strings.TrimSpace(o.CABundle) != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM([]byte(o.CABundle)) {
+			return nil, fmt.Errorf("CA 证书解析失败: 未找到有效的 PEM 证书")
+		}
+		config.RootCAs = pool
+	}
+	pins := make(map[string]bool, len(o.PinnedSHA256))
+	for _, pin := range o.PinnedSHA256 {
+		normalized, err := normalizePin(pin)
+		if err != nil {
+			return nil, err
+		}
+		if normalized != "" {
+			pins[normalized] = true
+		}
+	}
+	config.InsecureSkipVerify = o.AllowSelfSigned
+	config.VerifyConnection = func(state tls.ConnectionState) error {
+		if len(state.PeerCertificates) == 0 {
+			return fmt.Errorf("server presented no certificate")
+		}
+		if o.AllowSelfSigned {
+			if err := state.PeerCertificates[0].VerifyHostname(host); err != nil {
+				return err
+			}
+		}
+		if len(pins) == 0 {
+			return nil
+		}
+		for _, cert := range state.PeerCertificates {
+			certSum := sha256.Sum256(cert.Raw)
+			spkiSum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			if pins[hex.EncodeToString(certSum[:])] || pins[hex.EncodeToString(spkiSum[:])] {
+				return nil
+			}
+		}
+		return ErrCertificatePinMismatch
+	}
+	return config, nil
+}
+
+func describeDialError(err error, resp *http.Response) error {
+	var (
+		unknownAuthority x509.UnknownAuthorityError
+		hostnameErr      x509.HostnameError
+		invalidErr       x509.CertificateInvalidError
+		recordErr        tls.RecordHeaderError
+	)
+	switch {
+	case errors.Is(err, ErrCertificatePinMismatch):
+		return fmt.Errorf("TLS 握手失败: 证书指纹与固定的 SHA-256 不匹配: %w", err)
+	case errors.As(err, &unknownAuthority):
+		return fmt.Errorf("TLS 握手失败: 服务器证书不受信任，请配置 CA 证书或允许自签名证书: %w", err)
+	case errors.As(err, &hostnameErr):
+		return fmt.Errorf("TLS 握手失败: 证书与主机名不匹配: %w", err)
+	case errors.As(err, &invalidErr):
+		return fmt.Errorf("TLS 握手失败: 证书无效或已过期: %w", err)
+	case errors.As(err, &recordErr):
+		return fmt.Errorf("TLS 握手失败: 服务器未启用 TLS，请关闭 TLS 选项: %w", err)
+	case errors.Is(err, websocket.ErrBadHandshake) && resp != nil:
+		switch resp.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return fmt.Errorf("WebSocket 握手被拒绝 (HTTP %d)，请检查访问令牌: %w", resp.StatusCode, err)
+		default:
+			return fmt.Errorf("WebSocket 握手失败 (HTTP %d): %w", resp.StatusCode, err)
+		}
+	}
+	return fmt.Errorf("连接失败: %w", err)
+}
@@ -0,0 +1,159 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+type Event interface {
+	EventType() string
+	Validate() error
+}
+
+type BotConnect struct {
+	Bot      ID     `json:"bot"`
+	Adapter  string `json:"adapter"`
+	Platform string `json:"platform"`
+}
+
+func (e *BotConnect) EventType() string { return "bot_connect" }
+
+func (e *BotConnect) Validate() error {
+	return required(e.EventType(), "bot", string(e.Bot))
+}
+
+type BotDisconnect struct {
+	Bot      ID     `json:"bot"`
+	Adapter  string `json:"adapter"`
+	Platform string `json:"platform"`
+}
+
+func (e *BotDisconnect) EventType() string { return "bot_disconnect" }
+
+func (e *BotDisconnect) Validate() error {
+	return required(e.EventType(), "bot", string(e.Bot))
+}
+
+type Segment struct {
+	Type string
+	Data interface{}
+}
+
+type Content []Segment
+
+func (c *Content) UnmarshalJSON(raw []byte) error {
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		if text == "" {
+			*c = nil
+		} else {
+			*c = Content{{Type: "text", Data: text}}
+		}
+		return nil
+	}
+	var parts []interface{}
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return fmt.Errorf("content must be a string or segment list")
+	}
+	result := make(Content, 0, len(parts))
+	for _, part := range parts {
+		pair, ok := part.([]interface{})
+		if !ok || len(pair) < 2 {
+			continue
+		}
+		segmentType, ok := pair[0].(string)
+		if !ok {
+			continue
+		}
+		result = append(result, Segment{Type: segmentType, Data: pair[1]})
+	}
+	*c = result
+	return nil
+}
+
+func (c Content) Plaintext() string {
+	var parts []string
+	for _, segment := range c {
+		if segment.Type == "text" {
+			if text, ok := segment.Data.(string); ok {
+				parts = append(parts, text)
+			}
+		} else {
+			parts = append(parts, fmt.Sprintf("[%s]", segment.Type))
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+type Message struct {
+	Bot       ID        `json:"bot"`
+	Platform  string    `json:"platform"`
+	Content   Content   `json:"content"`
+	FromBot   bool      `json:"from_bot"`
+	UserID    ID        `json:"userid"`
+	UserName  string    `json:"username"`
+	GroupID   ID        `json:"groupid"`
+	GroupName string    `json:"groupname"`
+	Session   string    `json:"session"`
+	Avatar    string    `json:"avatar"`
+	Time      Timestamp `json:"time"`
+	MessageID ID        `json:"message_id"`
+}
+
+func (e *Message) EventType() string { return "message" }
+
+func (e *Message) Validate() error {
+	return required(e.EventType(), "bot", string(e.Bot))
+}
+
+type CallAPI struct {
+	Message
+	API string `json:"api"`
+}
+
+func (e *CallAPI) EventType() string { return "call_api" }
+
+func (e *CallAPI) Validate() error {
+	return required(e.EventType(), "bot", string(e.Bot))
+}
+
+type PluginException struct {
+	Name   string `json:"name"`
+	Detail string `json:"detail"`
+}
+
+type PluginCall struct {
+	Bot         ID               `json:"bot"`
+	Platform    string           `json:"platform"`
+	Plugin      string           `json:"plugin"`
+	MatcherHash StringList       `json:"matcher_hash"`
+	TimeCosted  float64          `json:"time_costed"`
+	Time        Timestamp        `json:"time"`
+	GroupID     ID               `json:"groupid"`
+	UserID      ID               `json:"userid"`
+	Exception   *PluginException `json:"exception"`
+}
+
+func (e *PluginCall) EventType() string { return "plugin_call" }
+
+func (e *PluginCall) Validate() error {
+	if err := required(e.EventType(), "bot", string(e.Bot)); err != nil {
+		return err
+	}
+	if err := required(e.EventType(), "plugin", e.Plugin); err != nil {
+		return err
+	}
+	if e.TimeCosted < 0 {
+		return &ValidationError{Kind: e.EventType(), Field: "time_costed", Reason: "must not be negative"}
+	}
+	return nil
+}
+
+func init() {
+	register(decoders.events, 1, "bot_connect", func() *BotConnect { return &BotConnect{} })
+	register(decoders.events, 1, "bot_disconnect", func() *BotDisconnect { return &BotDisconnect{} })
+	register(decoders.events, 1, "message", func() *Message { return &Message{} })
+	register(decoders.events, 1, "call_api", func() *CallAPI { return &CallAPI{} })
+	register(decoders.events, 1, "plugin_call", func() *PluginCall { return &PluginCall{} })
+}
@@ -0,0 +1,113 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const (
+	CapabilityHistorySync  = "history_sync"
+	CapabilityPluginUpdate = "plugin_update"
+	CapabilityPluginConfig = "plugin_config"
+	CapabilityBotSwitch    = "bot_switch"
+	CapabilityBotStatus    = "bot_status"
+	CapabilityMatchers     = "matchers"
+)
+
+var ErrIncompatibleVersion = errors.New("incompatible protocol version")
+
+var ClientCapabilities = []string{
+	CapabilityHistorySync,
+	CapabilityPluginUpdate,
+	CapabilityPluginConfig,
+	CapabilityBotSwitch,
+	CapabilityBotStatus,
+	CapabilityMatchers,
+}
+
+var legacyCapabilities = []string{
+	CapabilityPluginUpdate,
+	CapabilityPluginConfig,
+	CapabilityBotSwitch,
+	CapabilityMatchers,
+}
+
+type ServerInfo struct {
+	Version      string   `json:"version"`
+	Name         string   `json:"name"`
+	Capabilities []string `json:"capabilities"`
+	Encoding     string   `json:"encoding"`
+	Legacy       bool     `json:"-"`
+}
+
+func LegacyServerInfo() *ServerInfo {
+	return &ServerInfo{
+		Version:      CurrentVersion,
+		Capabilities: append([]string(nil), legacyCapabilities...),
+		Legacy:       true,
+	}
+}
+
+func (i *ServerInfo) Method() string { return "handshake" }
+
+func (i *ServerInfo) UnmarshalJSON(raw []byte) error {
+	var env envelope
+	if err := json.Unmarshal(raw, &env); err != nil {
+		return err
+	}
+	if text := env.errorText(); text != "" {
+		return fmt.Errorf("server error: %s", text)
+	}
+	type plain ServerInfo
+	var info plain
+	if err := json.Unmarshal(env.Data, &info); err != nil {
+		return err
+	}
+	*i = ServerInfo(info)
+	return nil
+}
+
+func (i *ServerInfo) Validate() error {
+	if err := required(i.Method(), "version", i.Version); err != nil {
+		return err
+	}
+	return CheckCompatible(i.Version)
+}
+
+func (i *ServerInfo) Has(capability string) bool {
+	if i == nil {
+		return false
+	}
+	for _, c := range i.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+func CheckCompatible(serverVersion string) error {
+	server, err := MajorVersion(serverVersion)
+	if err != nil {
+		return err
+	}
+	client, _ := MajorVersion(CurrentVersion)
+	if server != client {
+		return fmt.Errorf("%w: server speaks %s, client speaks %s", ErrIncompatibleVersion, serverVersion, CurrentVersion)
+	}
+	return nil
+}
+
+func HandshakeParams(client string, encodings []string) map[string]interface{} {
+	return map[string]interface{}{
+		"version":      CurrentVersion,
+		"client":       client,
+		"capabilities": ClientCapabilities,
+		"encodings":    encodings,
+	}
+}
+
+func init() {
+	register(decoders.responses, 1, "handshake", func() *ServerInfo { return &ServerInfo{} })
+}
@@ -0,0 +1,219 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const CurrentVersion = "1.0"
+
+var ErrUnsupportedVersion = errors.New("unsupported protocol version")
+
+type ValidationError struct {
+	Kind   string
+	Field  string
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("invalid %s payload: %s", e.Kind, e.Reason)
+	}
+	return fmt.Sprintf("invalid %s payload: %s %s", e.Kind, e.Field, e.Reason)
+}
+
+func required(kind, field, value string) error {
+	if strings.TrimSpace(value) == "" {
+		return &ValidationError{Kind: kind, Field: field, Reason: "is required"}
+	}
+	return nil
+}
+
+type validator interface {
+	Validate() error
+}
+
+type decodeFunc func(raw json.RawMessage) (validator, error)
+
+type registry struct {
+	events    map[int]map[string]decodeFunc
+	responses map[int]map[string]decodeFunc
+}
+
+var decoders = registry{
+	events:    make(map[int]map[string]decodeFunc),
+	responses: make(map[int]map[string]decodeFunc),
+}
+
+func register[T validator](table map[int]map[string]decodeFunc, major int, name string, newValue func() T) {
+	if table[major] == nil {
+		table[major] = make(map[string]decodeFunc)
+	}
+	table[major][name] = func(raw json.RawMessage) (validator, error) {
+		value := newValue()
+		if err := json.Unmarshal(raw, value); err != nil {
+			return nil, err
+		}
+		return value, nil
+	}
+}
+
+func MajorVersion(version string) (int, error) {
+	if version == "" {
+		version = CurrentVersion
+	}
+	head, _, _ := strings.Cut(strings.TrimPrefix(version, "v"), ".")
+	major, err := strconv.Atoi(head)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q", ErrUnsupportedVersion, version)
+	}
+	return major, nil
+}
+
+func decode(table map[int]map[string]decodeFunc, version, name string, payload interface{}) (validator, error) {
+	major, err := MajorVersion(version)
+	if err != nil {
+		return nil, err
+	}
+	byName, ok := table[major]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedVersion, version)
+	}
+	fn, ok := byName[name]
+	if !ok {
+		return nil, fmt.Errorf("no decoder for %s in protocol %s", name, version)
+	}
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal %s payload: %w", name, err)
+	}
+	value, err := fn(raw)
+	if err != nil {
+		return nil, &ValidationError{Kind: name, Reason: err.Error()}
+	}
+	if err := value.Validate(); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func DecodeEvent(version, msgType string, payload interface{}) (Event, error) {
+	value, err := decode(decoders.events, version, msgType, payload)
+	if err != nil {
+		return nil, err
+	}
+	return value.(Event), nil
+}
+
+func DecodeResponse(version, method string, payload interface{}) (Response, error) {
+	value, err := decode(decoders.responses, version, method, payload)
+	if err != nil {
+		return nil, err
+	}
+	return value.(Response), nil
+}
+
+func As[T any](value interface{}, err error) (T, error) {
+	var zero T
+	if err != nil {
+		return zero, err
+	}
+	typed, ok := value.(T)
+	if !ok {
+		return zero, fmt.Errorf("unexpected payload type %T", value)
+	}
+	return typed, nil
+}
+
+type Timestamp float64
+
+func (t *Timestamp) UnmarshalJSON(raw []byte) error {
+	var number float64
+	if err := json.Unmarshal(raw, &number); err == nil {
+		*t = Timestamp(number)
+		return nil
+	}
+	var text string
+	if err := json.Unmarshal(raw, &text); err != nil {
+		return fmt.Errorf("time must be a number or numeric string")
+	}
+	if text == "" {
+		*t = 0
+		return nil
+	}
+	number, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return fmt.Errorf("time %q is not numeric", text)
+	}
+	*t = Timestamp(number)
+	return nil
+}
+
+func (t Timestamp) IsZero() bool {
+	return t == 0
+}
+
+func (t Timestamp) UnixMilli() int64 {
+	if t > 1e10 {
+		return int64(t)
+	}
+	return int64(t * 1000)
+}
+
+func (t Timestamp) Unix() int64 {
+	if t > 1e10 {
+		return int64(t) / 1000
+	}
+	return int64(t)
+}
+
+type StringList []string
+
+func (l *StringList) UnmarshalJSON(raw []byte) error {
+	var single string
+	if err := json.Unmarshal(raw, &single); err == nil {
+		if single == "" {
+			*l = nil
+		} else {
+			*l = StringList{single}
+		}
+		return nil
+	}
+	var items []interface{}
+	if err := json.Unmarshal(raw, &items); err != nil {
+		return fmt.Errorf("expected string or array")
+	}
+	result := make(StringList, 0, len(items))
+	for _, item := range items {
+		if s, ok := item.(string); ok {
+			result = append(result, s)
+		} else if item != nil {
+			result = append(result, fmt.Sprintf("%v", item))
+		}
+	}
+	*l = result
+	return nil
+}
+
+func (l StringList) Join() string {
+	return strings.Join(l, ",")
+}
+
+type ID string
+
+func (id *ID) UnmarshalJSON(raw []byte) error {
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		*id = ID(text)
+		return nil
+	}
+	var number json.Number
+	if err := json.Unmarshal(raw, &number); err != nil {
+		return fmt.Errorf("expected string or number")
+	}
+	*id = ID(number.String())
+	return nil
+}
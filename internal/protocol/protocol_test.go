@@ -0,0 +1,237 @@
+package protocol_test
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"lazytea-mobile/internal/protocol"
+)
+
+func TestDecodeEventVersionDispatch(t *testing.T) {
+	payload := map[string]interface{}{"bot": "10001", "adapter": "OneBot V11", "platform": "qq"}
+	cases := []struct {
+		name        string
+		version     string
+		msgType     string
+		unsupported bool
+		wantErr     bool
+	}{
+		{"default version", "", "bot_connect", false, false},
+		{"current version", protocol.CurrentVersion, "bot_connect", false, false},
+		{"minor bump", "1.7", "bot_connect", false, false},
+		{"v prefix", "v1.2", "bot_connect", false, false},
+		{"unknown major", "2.0", "bot_connect", true, true},
+		{"garbage version", "latest", "bot_connect", true, true},
+		{"unknown type", "1.0", "no_such_event", false, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			event, err := protocol.DecodeEvent(tc.version, tc.msgType, payload)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("unexpected error state: %v", err)
+			}
+			if errors.Is(err, protocol.ErrUnsupportedVersion) != tc.unsupported {
+				t.Fatalf("expected ErrUnsupportedVersion=%v, got %v", tc.unsupported, err)
+			}
+			if err != nil {
+				return
+			}
+			connect, ok := event.(*protocol.BotConnect)
+			if !ok || connect.Bot != "10001" || connect.EventType() != tc.msgType {
+				t.Fatalf("unexpected event %#v", event)
+			}
+		})
+	}
+}
+
+func TestDecodeResponseVersionDispatch(t *testing.T) {
+	cases := []struct {
+		name    string
+		version string
+		method  string
+		payload interface{}
+		want    string
+		wantErr bool
+	}{
+		{"matchers", "1.0", "get_matchers", map[string]interface{}{"code": 200, "data": map[string]interface{}{"bots": map[string]interface{}{}}}, "get_matchers", false},
+		{"ack keeps method", "1.3", "bot_switch", map[string]interface{}{"code": 200}, "bot_switch", false},
+		{"bot status", "1.0", "get_bot_status", map[string]interface{}{"code": 200, "data": map[string]interface{}{"bot_id": 42, "is_online": false}}, "get_bot_status", false},
+		{"bot status without state", "1.0", "get_bot_status", map[string]interface{}{"code": 200, "data": map[string]interface{}{"bot_id": "42"}}, "", true},
+		{"ack error code", "1.0", "save_env", map[string]interface{}{"code": 500}, "", true},
+		{"server error", "1.0", "get_matchers", map[string]interface{}{"code": 500, "error": "boom"}, "", true},
+		{"unknown major", "3.0", "get_matchers", map[string]interface{}{"code": 200}, "", true},
+		{"unknown method", "1.0", "get_nothing", map[string]interface{}{"code": 200}, "", true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			response, err := protocol.DecodeResponse(tc.version, tc.method, tc.payload)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("unexpected error state: %v", err)
+			}
+			if err == nil && response.Method() != tc.want {
+				t.Fatalf("expected method %s, got %s", tc.want, response.Method())
+			}
+		})
+	}
+}
+
+func TestValidationErrorFields(t *testing.T) {
+	cases := []struct {
+		name    string
+		decode  func() error
+		kind    string
+		field   string
+		message string
+	}{
+		{
+			name: "missing bot",
+			decode: func() error {
+				_, err := protocol.DecodeEvent("1.0", "bot_connect", map[string]interface{}{"adapter": "OneBot V11"})
+				return err
+			},
+			kind:    "bot_connect",
+			field:   "bot",
+			message: "invalid bot_connect payload: bot is required",
+		},
+		{
+			name: "negative cost",
+			decode: func() error {
+				_, err := protocol.DecodeEvent("1.0", "plugin_call", map[string]interface{}{"bot": "1", "plugin": "echo", "time_costed": -1})
+				return err
+			},
+			kind:    "plugin_call",
+			field:   "time_costed",
+			message: "invalid plugin_call payload: time_costed must not be negative",
+		},
+		{
+			name: "wrong shape",
+			decode: func() error {
+				_, err := protocol.DecodeEvent("1.0", "message", []interface{}{"not", "an", "object"})
+				return err
+			},
+			kind: "message",
+		},
+		{
+			name: "bots not an object",
+			decode: func() error {
+				_, err := protocol.DecodeResponse("1.0", "get_matchers", map[string]interface{}{"data": map[string]interface{}{"bots": "none"}})
+				return err
+			},
+			kind:    "get_matchers",
+			field:   "bots",
+			message: "invalid get_matchers payload: bots must be an object",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var validation *protocol.ValidationError
+			err := tc.decode()
+			if !errors.As(err, &validation) {
+				t.Fatalf("expected a ValidationError, got %v", err)
+			}
+			if validation.Kind != tc.kind || validation.Field != tc.field {
+				t.Fatalf("expected kind %q field %q, got %+v", tc.kind, tc.field, validation)
+			}
+			if tc.message != "" && err.Error() != tc.message {
+				t.Fatalf("expected message %q, got %q", tc.message, err.Error())
+			}
+		})
+	}
+}
+
+func TestTimestampUnmarshal(t *testing.T) {
+	cases := []struct {
+		raw       string
+		wantMilli int64
+		wantUnix  int64
+		wantErr   bool
+	}{
+		{`1700000000`, 1700000000000, 1700000000, false},
+		{`1700000000.25`, 1700000000250, 1700000000, false},
+		{`1700000000123`, 1700000000123, 1700000000, false},
+		{`"1700000000"`, 1700000000000, 1700000000, false},
+		{`"1700000000123"`, 1700000000123, 1700000000, false},
+		{`""`, 0, 0, false},
+		{`null`, 0, 0, false},
+		{`"yesterday"`, 0, 0, true},
+		{`true`, 0, 0, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.raw, func(t *testing.T) {
+			var ts protocol.Timestamp
+			err := json.Unmarshal([]byte(tc.raw), &ts)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("unexpected error state: %v", err)
+			}
+			if err != nil {
+				return
+			}
+			if ts.UnixMilli() != tc.wantMilli || ts.Unix() != tc.wantUnix {
+				t.Fatalf("expected %d ms / %d s, got %d ms / %d s", tc.wantMilli, tc.wantUnix, ts.UnixMilli(), ts.Unix())
+			}
+			if ts.IsZero() != (tc.wantMilli == 0) {
+				t.Fatalf("unexpected IsZero for %s", tc.raw)
+			}
+		})
+	}
+}
+
+func TestStringListUnmarshal(t *testing.T) {
+	cases := []struct {
+		raw     string
+		want    protocol.StringList
+		wantErr bool
+	}{
+		{`"abc"`, protocol.StringList{"abc"}, false},
+		{`""`, nil, false},
+		{`null`, nil, false},
+		{`["a", "b"]`, protocol.StringList{"a", "b"}, false},
+		{`["a", 12, null, 1.5]`, protocol.StringList{"a", "12", "1.5"}, false},
+		{`[]`, protocol.StringList{}, false},
+		{`{"a": 1}`, nil, true},
+		{`42`, nil, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.raw, func(t *testing.T) {
+			var list protocol.StringList
+			err := json.Unmarshal([]byte(tc.raw), &list)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("unexpected error state: %v", err)
+			}
+			if err == nil && !reflect.DeepEqual(list, tc.want) {
+				t.Fatalf("expected %#v, got %#v", tc.want, list)
+			}
+		})
+	}
+}
+
+func TestIDUnmarshal(t *testing.T) {
+	cases := []struct {
+		raw     string
+		want    protocol.ID
+		wantErr bool
+	}{
+		{`"10001"`, "10001", false},
+		{`10001`, "10001", false},
+		{`123456789012345678`, "123456789012345678", false},
+		{`1.5`, "1.5", false},
+		{`""`, "", false},
+		{`null`, "", false},
+		{`true`, "", true},
+		{`[1]`, "", true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.raw, func(t *testing.T) {
+			var id protocol.ID
+			err := json.Unmarshal([]byte(tc.raw), &id)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("unexpected error state: %v", err)
+			}
+			if err == nil && id != tc.want {
+				t.Fatalf("expected %q, got %q", tc.want, id)
+			}
+		})
+	}
+}
@@ -0,0 +1,326 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+type Response interface {
+	Method() string
+	Validate() error
+}
+
+type envelope struct {
+	Code  int             `json:"code"`
+	Time  int64           `json:"time"`
+	Data  json.RawMessage `json:"data"`
+	Error *string         `json:"error"`
+}
+
+func (e envelope) errorText() string {
+	if e.Error == nil || *e.Error == "<nil>" {
+		return ""
+	}
+	return *e.Error
+}
+
+func (e envelope) hasData() bool {
+	return len(e.Data) > 0 && string(e.Data) != "null"
+}
+
+type PluginMeta struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Homepage    string `json:"homepage"`
+	ConfigExist bool   `json:"config_exist"`
+	IconAbspath string `json:"icon_abspath"`
+	Author      string `json:"author"`
+	Version     string `json:"version"`
+}
+
+type PluginInfo struct {
+	Name   string     `json:"name"`
+	Module string     `json:"module"`
+	Meta   PluginMeta `json:"meta"`
+}
+
+type PluginList struct {
+	Plugins []PluginInfo
+}
+
+func (r *PluginList) Method() string { return "get_plugins" }
+
+func (r *PluginList) UnmarshalJSON(raw []byte) error {
+	var env envelope
+	if err := json.Unmarshal(raw, &env); err != nil {
+		return err
+	}
+	if text := env.errorText(); text != "" {
+		return fmt.Errorf("server error: %s", text)
+	}
+	if !env.hasData() {
+		r.Plugins = nil
+		return nil
+	}
+	var list []PluginInfo
+	if err := json.Unmarshal(env.Data, &list); err == nil {
+		r.Plugins = list
+		return nil
+	}
+	var byName map[string]PluginInfo
+	if err := json.Unmarshal(env.Data, &byName); err != nil {
+		return fmt.Errorf("data must be a plugin list or map")
+	}
+	keys := make([]string, 0, len(byName))
+	for key := range byName {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	r.Plugins = make([]PluginInfo, 0, len(keys))
+	for _, key := range keys {
+		plugin := byName[key]
+		if plugin.Name == "" {
+			plugin.Name = key
+		}
+		r.Plugins = append(r.Plugins, plugin)
+	}
+	return nil
+}
+
+func (r *PluginList) Validate() error {
+	for i, plugin := range r.Plugins {
+		if plugin.Name == "" {
+			return &ValidationError{Kind: r.Method(), Field: fmt.Sprintf("data[%d].name", i), Reason: "is required"}
+		}
+	}
+	return nil
+}
+
+type Matchers struct {
+	Roster map[string]interface{}
+}
+
+func (r *Matchers) Method() string { return "get_matchers" }
+
+func (r *Matchers) UnmarshalJSON(raw []byte) error {
+	var env envelope
+	if err := json.Unmarshal(raw, &env); err != nil {
+		return err
+	}
+	if text := env.errorText(); text != "" {
+		return fmt.Errorf("server error: %s", text)
+	}
+	if env.hasData() {
+		if err := json.Unmarshal(env.Data, &r.Roster); err == nil {
+			return nil
+		}
+	}
+	return json.Unmarshal(raw, &r.Roster)
+}
+
+func (r *Matchers) Validate() error {
+	if r.Roster == nil {
+		return &ValidationError{Kind: r.Method(), Field: "data", Reason: "must be an object"}
+	}
+	if bots, ok := r.Roster["bots"]; ok && bots != nil {
+		if _, ok := bots.(map[string]interface{}); !ok {
+			return &ValidationError{Kind: r.Method(), Field: "bots", Reason: "must be an object"}
+		}
+	}
+	return nil
+}
+
+type PluginConfig struct {
+	Module string
+	Schema map[string]interface{}
+	Data   string
+	Error  string
+}
+
+func (r *PluginConfig) Method() string { return "get_plugin_config" }
+
+func (r *PluginConfig) UnmarshalJSON(raw []byte) error {
+	var env envelope
+	if err := json.Unmarshal(raw, &env); err != nil {
+		return err
+	}
+	r.Error = env.errorText()
+	var body struct {
+		Schema     map[string]interface{} `json:"schema"`
+		Data       json.RawMessage        `json:"data"`
+		Module     string                 `json:"module"`
+		ModuleName string                 `json:"module_name"`
+	}
+	if err := json.Unmarshal(raw, &body); err != nil {
+		return err
+	}
+	var nested struct {
+		Schema map[string]interface{} `json:"schema"`
+		Data   string                 `json:"data"`
+	}
+	if env.hasData() && json.Unmarshal(env.Data, &nested) == nil && nested.Schema != nil {
+		r.Schema = nested.Schema
+		r.Data = nested.Data
+	} else {
+		r.Schema = body.Schema
+		var text string
+		if json.Unmarshal(body.Data, &text) == nil {
+			r.Data = text
+		}
+	}
+	r.Module = body.Module
+	if r.Module == "" {
+		r.Module = body.ModuleName
+	}
+	return nil
+}
+
+func (r *PluginConfig) Validate() error {
+	if r.Schema == nil {
+		return nil
+	}
+	if properties, ok := r.Schema["properties"]; ok && properties != nil {
+		if _, ok := properties.(map[string]interface{}); !ok {
+			return &ValidationError{Kind: r.Method(), Field: "schema.properties", Reason: "must be an object"}
+		}
+	}
+	return nil
+}
+
+func (r *PluginConfig) Properties() map[string]interface{} {
+	if r.Schema == nil {
+		return nil
+	}
+	properties, _ := r.Schema["properties"].(map[string]interface{})
+	return properties
+}
+
+type Ack struct {
+	method string
+	Code   int
+	Error  string
+	Data   interface{}
+}
+
+func (r *Ack) Method() string { return r.method }
+
+func (r *Ack) UnmarshalJSON(raw []byte) error {
+	var env envelope
+	if err := json.Unmarshal(raw, &env); err != nil {
+		return err
+	}
+	r.Code = env.Code
+	r.Error = env.errorText()
+	if env.hasData() {
+		return json.Unmarshal(env.Data, &r.Data)
+	}
+	return nil
+}
+
+func (r *Ack) Validate() error {
+	if r.Error != "" {
+		return fmt.Errorf("%s failed: %s", r.method, r.Error)
+	}
+	if r.Code != 0 && (r.Code < 200 || r.Code >= 300) {
+		return fmt.Errorf("%s failed: code %d", r.method, r.Code)
+	}
+	return nil
+}
+
+type BotStatus struct {
+	BotID    string
+	Platform string
+	IsOnline bool
+}
+
+func (r *BotStatus) Method() string { return "get_bot_status" }
+
+func (r *BotStatus) UnmarshalJSON(raw []byte) error {
+	var env envelope
+	if err := json.Unmarshal(raw, &env); err != nil {
+		return err
+	}
+	if text := env.errorText(); text != "" {
+		return fmt.Errorf("server error: %s", text)
+	}
+	if !env.hasData() {
+		return fmt.Errorf("data is required")
+	}
+	var body struct {
+		BotID    ID     `json:"bot_id"`
+		Platform string `json:"platform"`
+		IsOnline *bool  `json:"is_online"`
+	}
+	if err := json.Unmarshal(env.Data, &body); err != nil {
+		return fmt.Errorf("data must be a bot status object")
+	}
+	if body.IsOnline == nil {
+		return fmt.Errorf("is_online is required")
+	}
+	r.BotID = string(body.BotID)
+	r.Platform = body.Platform
+	r.IsOnline = *body.IsOnline
+	return nil
+}
+
+func (r *BotStatus) Validate() error {
+	return required(r.Method(), "bot_id", r.BotID)
+}
+
+type History struct {
+	Messages   []Message
+	NextCursor string
+	HasMore    bool
+}
+
+func (r *History) Method() string { return "get_history" }
+
+func (r *History) UnmarshalJSON(raw []byte) error {
+	var env envelope
+	if err := json.Unmarshal(raw, &env); err != nil {
+		return err
+	}
+	if text := env.errorText(); text != "" {
+		return fmt.Errorf("server error: %s", text)
+	}
+	if !env.hasData() {
+		return nil
+	}
+	var page struct {
+		Messages   []Message `json:"messages"`
+		NextCursor ID        `json:"next_cursor"`
+		HasMore    bool      `json:"has_more"`
+	}
+	if err := json.Unmarshal(env.Data, &page); err == nil {
+		r.Messages = page.Messages
+		r.NextCursor = string(page.NextCursor)
+		r.HasMore = page.HasMore && r.NextCursor != ""
+		return nil
+	}
+	if err := json.Unmarshal(env.Data, &r.Messages); err != nil {
+		return fmt.Errorf("data must be a history page or message list")
+	}
+	return nil
+}
+
+func (r *History) Validate() error {
+	for i := range r.Messages {
+		if err := r.Messages[i].Validate(); err != nil {
+			return &ValidationError{Kind: r.Method(), Field: fmt.Sprintf("messages[%d]", i), Reason: err.Error()}
+		}
+	}
+	return nil
+}
+
+func init() {
+	register(decoders.responses, 1, "get_plugins", func() *PluginList { return &PluginList{} })
+	register(decoders.responses, 1, "get_matchers", func() *Matchers { return &Matchers{} })
+	register(decoders.responses, 1, "get_plugin_config", func() *PluginConfig { return &PluginConfig{} })
+	register(decoders.responses, 1, "get_history", func() *History { return &History{} })
+	register(decoders.responses, 1, "get_bot_status", func() *BotStatus { return &BotStatus{} })
+	for _, method := range []string{"save_env", "sync_matchers", "bot_switch", "update_plugin"} {
+		method := method
+		register(decoders.responses, 1, method, func() *Ack { return &Ack{method: method} })
+	}
+}
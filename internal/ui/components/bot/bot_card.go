@@ -20,16 +20,16 @@ type BotCard struct {
 	botInfo  data.BotInfo
 	stats    BotStats
 	isOnline bool
-	onToggleStatus func(botID string, isOnline bool)
+	onToggleStatus func(key data.BotKey, isOnline bool)
 	onShowDetails  func(botInfo data.BotInfo, botStats BotStats)
-	onShowRoster   func(botID string)
+	onShowRoster   func(key data.BotKey)
 	statusIcon    *canvas.Circle
 	statusLabel   *widget.Label
 	statsLabel    *widget.Label
 	actionButtons *fyne.Container
 	cardContainer *fyne.Container
 }
-func NewBotCard(botInfo data.BotInfo, onToggleStatus func(botID string, isOnline bool), onShowDetails func(botInfo data.BotInfo, botStats BotStats), onShowRoster func(botID string)) *BotCard {
+func NewBotCard(botInfo data.BotInfo, onToggleStatus func(key data.BotKey, isOnline bool), onShowDetails func(botInfo data.BotInfo, botStats BotStats), onShowRoster func(key data.BotKey)) *BotCard {
 	card := &BotCard{
 		botInfo:        botInfo,
 		isOnline:       botInfo.IsOnline,
@@ -73,7 +73,7 @@ func (c *BotCard) setupUI() {
 func (c *BotCard) createActionButtons() *fyne.Container {
 	toggleBtn := widget.NewButtonWithIcon("", theme.MediaPlayIcon(), func() {
 		if c.onToggleStatus != nil {
-			c.onToggleStatus(c.botInfo.ID, c.isOnline)
+			c.onToggleStatus(c.botInfo.Key(), c.isOnline)
 		}
 	})
 	toggleBtn.Importance = widget.HighImportance
@@ -87,7 +87,7 @@ func (c *BotCard) createActionButtons() *fyne.Container {
 	detailsBtn.Resize(fyne.NewSize(40, 40))
 	rosterBtn := widget.NewButtonWithIcon("", theme.SettingsIcon(), func() {
 		if c.onShowRoster != nil {
-			c.onShowRoster(c.botInfo.ID)
+			c.onShowRoster(c.botInfo.Key())
 		}
 	})
 	rosterBtn.Importance = widget.MediumImportance
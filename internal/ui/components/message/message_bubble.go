@@ -266,6 +266,29 @@ func (mb *MessageBubble) UpdateMessage(message data.Message) {
 		mb.content.Refresh()
 	}
 }
+func (mb *MessageBubble) SetHighlight(highlighted string) {
+	if mb.content == nil {
+		return
+	}
+	parts, matched := data.SplitHighlights(highlighted)
+	segments := make([]widget.RichTextSegment, 0, len(parts))
+	for i, part := range parts {
+		style := widget.RichTextStyleInline
+		if matched[i] {
+			style = widget.RichTextStyle{
+				Inline:    true,
+				ColorName: theme.ColorNamePrimary,
+				TextStyle: fyne.TextStyle{Bold: true},
+			}
+		}
+		segments = append(segments, &widget.TextSegment{Text: part, Style: style})
+	}
+	if len(segments) == 0 {
+		segments = append(segments, &widget.TextSegment{Text: " ", Style: widget.RichTextStyleInline})
+	}
+	mb.content.Segments = segments
+	mb.content.Refresh()
+}
 func (mb *MessageBubble) SetAccentColor(color string) {
 	mb.accentColor = color
 }
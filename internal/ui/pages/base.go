@@ -1,15 +1,21 @@
 package pages
 import (
+	"errors"
+	"fmt"
 	"lazytea-mobile/internal/data"
 	"lazytea-mobile/internal/network"
 	"lazytea-mobile/internal/utils"
+	"sync"
 	"fyne.io/fyne/v2"
 )
 type PageBase struct {
 	client  *network.Client
+	clients *network.Manager
 	storage *data.Storage
 	logger  *utils.Logger
 	content fyne.CanvasObject
+	subMutex      sync.Mutex
+	subscriptions []*network.Subscription
 }
 func NewPageBase(client *network.Client, storage *data.Storage, logger *utils.Logger) *PageBase {
 	return &PageBase{
@@ -18,9 +24,23 @@ func NewPageBase(client *network.Client, storage *data.Storage, logger *utils.Lo
 		logger:  logger,
 	}
 }
+func NewManagedPageBase(clients *network.Manager, storage *data.Storage, logger *utils.Logger) *PageBase {
+	base := NewPageBase(clients.Primary(), storage, logger)
+	base.clients = clients
+	return base
+}
 func (p *PageBase) GetClient() *network.Client {
 	return p.client
 }
+func (p *PageBase) GetClients() *network.Manager {
+	return p.clients
+}
+func (p *PageBase) ClientFor(server string) *network.Client {
+	if p.clients == nil {
+		return p.client
+	}
+	return p.clients.Client(server)
+}
 func (p *PageBase) GetStorage() *data.Storage {
 	return p.storage
 }
@@ -32,4 +52,24 @@ func (p *PageBase) GetContent() fyne.CanvasObject {
 }
 func (p *PageBase) SetContent(content fyne.CanvasObject) {
 	p.content = content
-}
\ No newline at end of file
+}
+func (p *PageBase) Track(subs ...*network.Subscription) {
+	p.subMutex.Lock()
+	defer p.subMutex.Unlock()
+	p.subscriptions = append(p.subscriptions, subs...)
+}
+func (p *PageBase) Close() {
+	p.subMutex.Lock()
+	subs := p.subscriptions
+	p.subscriptions = nil
+	p.subMutex.Unlock()
+	for _, sub := range subs {
+		sub.Close()
+	}
+}
+func queuedNotice(err error, action string) string {
+	if errors.Is(err, network.ErrQueuedBehind) {
+		return fmt.Sprintf("离线队列中还有未发送的请求，%s已排在其后按顺序发送", action)
+	}
+	return fmt.Sprintf("连接已断开，%s将在重连后发送", action)
+}
@@ -1,9 +1,12 @@
 package pages
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"lazytea-mobile/internal/data"
 	"lazytea-mobile/internal/network"
+	"lazytea-mobile/internal/protocol"
 	"lazytea-mobile/internal/ui/components/bot"
 	"lazytea-mobile/internal/utils"
 	"lazytea-mobile/internal/utils/bottools"
@@ -23,10 +26,11 @@ type BotInfoPage struct {
 	mainWindow    fyne.Window
 	toolkit       *bottools.BotToolKit
 	body          *fyne.Container  
+	rosterPage    *RosterPage
 }
-func NewBotInfoPage(client *network.Client, storage *data.Storage, logger *utils.Logger, mainWindow fyne.Window) *BotInfoPage {
+func NewBotInfoPage(clients *network.Manager, storage *data.Storage, logger *utils.Logger, mainWindow fyne.Window) *BotInfoPage {
 	page := &BotInfoPage{
-		PageBase:   NewPageBase(client, storage, logger),
+		PageBase:   NewManagedPageBase(clients, storage, logger),
 		mainWindow: mainWindow,
 		toolkit:    bottools.GetDefaultToolKit(),
 	}
@@ -36,65 +40,82 @@ func NewBotInfoPage(client *network.Client, storage *data.Storage, logger *utils
 	return page
 }
 func (p *BotInfoPage) setupEventHandlers() {
-	p.client.OnConnectionChanged(func(connected bool) {
+	p.Track(p.clients.OnConnectionChanged(func(server string, connected bool) {
 		if connected {
 			p.statusLabel.SetText("已连接")
-		} else {
+			return
+		}
+		if online, _ := p.clients.ConnectionCounts(); online == 0 {
 			p.statusLabel.SetText("未连接")
 			p.cardManager.Clear()
 			if bots, err := p.storage.GetBotInfoList(); err == nil {
 				for _, bot := range bots {
-					p.storage.UpdateBotOnlineStatus(bot.ID, false)
+					p.storage.UpdateBotOnlineStatus(bot.Key(), false)
 				}
 			}
-			p.refreshCardLayout()
-			p.updateBotCount()
+		} else {
+			p.cardManager.RemoveServer(server)
+			p.storage.MarkServerBotsOffline(server)
 		}
-	})
-	p.client.OnMessage("bot_connect", func(header network.MessageHeader, payload interface{}) {
-		if payloadMap, ok := payload.(map[string]interface{}); ok {
-			botID, _ := payloadMap["bot"].(string)
-			adapter, _ := payloadMap["adapter"].(string)
-			platform, _ := payloadMap["platform"].(string)
-			p.logger.Info("Bot connected: %s (%s via %s)", botID, platform, adapter)
-			p.toolkit.AddBot(botID)
-			p.toolkit.SetBotOnline(botID, true)
-			botInfo := data.BotInfo{
-				ID:          botID,
-				AdapterName: adapter,
-				Platform:    platform,
-				IsOnline:    true,
-				LastSeen:    time.Now(),
-			}
+		p.refreshCardLayout()
+		p.updateBotCount()
+	}))
+	subscribeOptions := network.DefaultSubscribeOptions()
+	subscribeOptions.Name = "bot-page"
+	// 回调会修改页面状态，单通道按顺序处理避免并发
+	subscribeOptions.Lanes = 1
+	p.Track(p.clients.OnMessageWithOptions("bot_connect", func(header network.MessageHeader, payload interface{}) {
+		event, err := protocol.As[*protocol.BotConnect](protocol.DecodeEvent(header.Version, header.MsgType, payload))
+		if err != nil {
+			p.logger.Warn("Ignoring bot_connect: %v", err)
+			return
+		}
+		botID := string(event.Bot)
+		key := data.BotKey{Server: header.Server, ID: botID}
+		p.logger.Info("Bot connected: %s (%s via %s)", key, event.Platform, event.Adapter)
+		p.toolkit.AddBot(key.String())
+		p.toolkit.SetBotOnline(key.String(), true)
+		botInfo := data.BotInfo{
+			ID:          botID,
+			Server:      header.Server,
+			AdapterName: event.Adapter,
+			Platform:    event.Platform,
+			IsOnline:    true,
+			LastSeen:    time.Now(),
+		}
+		if !header.Replayed {
 			if err := p.storage.SaveBotInfo(botInfo); err != nil {
 				p.logger.Error("Failed to save bot info: %v", err)
 			}
-			p.cardManager.AddOrUpdate(botInfo)
-			p.refreshCardLayout()
-			p.updateBotCount()
 		}
-	})
-	p.client.OnMessage("message", func(header network.MessageHeader, payload interface{}) {
-		if payloadMap, ok := payload.(map[string]interface{}); ok {
-			if botID, ok := payloadMap["bot"].(string); ok {
-				p.toolkit.IncrementMessage(botID)
-				go func() {
-				}()
-			}
+		p.cardManager.AddOrUpdate(botInfo)
+		p.refreshCardLayout()
+		p.updateBotCount()
+	}, subscribeOptions))
+	p.Track(p.clients.OnMessageWithOptions("message", func(header network.MessageHeader, payload interface{}) {
+		event, err := protocol.As[*protocol.Message](protocol.DecodeEvent(header.Version, header.MsgType, payload))
+		if err != nil {
+			return
+		}
+		p.toolkit.IncrementMessage(data.BotKey{Server: header.Server, ID: string(event.Bot)}.String())
+	}, subscribeOptions))
+	p.Track(p.clients.OnMessageWithOptions("bot_disconnect", func(header network.MessageHeader, payload interface{}) {
+		event, err := protocol.As[*protocol.BotDisconnect](protocol.DecodeEvent(header.Version, header.MsgType, payload))
+		if err != nil {
+			p.logger.Warn("Ignoring bot_disconnect: %v", err)
+			return
 		}
-	})
-	p.client.OnMessage("bot_disconnect", func(header network.MessageHeader, payload interface{}) {
-		if payloadMap, ok := payload.(map[string]interface{}); ok {
-			botID, _ := payloadMap["bot"].(string)
-			p.logger.Info("Bot disconnected: %s", botID)
-			p.toolkit.SetBotOnline(botID, false)
-			if err := p.storage.UpdateBotOnlineStatus(botID, false); err != nil {
+		key := data.BotKey{Server: header.Server, ID: string(event.Bot)}
+		p.logger.Info("Bot disconnected: %s", key)
+		p.toolkit.SetBotOnline(key.String(), false)
+		if !header.Replayed {
+			if err := p.storage.UpdateBotOnlineStatus(key, false); err != nil {
 				p.logger.Error("Failed to update bot offline status: %v", err)
 			}
-			p.cardManager.SetOnlineStatus(botID, false)
-			p.updateBotCount()
 		}
-	})
+		p.cardManager.SetOnlineStatus(key, false)
+		p.updateBotCount()
+	}, subscribeOptions))
 	p.startDataRefreshTimer()
 }
 func (p *BotInfoPage) setupUI() {
@@ -150,9 +171,10 @@ func (p *BotInfoPage) refreshAllData() {
 	onlineCount := 0
 	offlineCount := 0
 	allBotInfos := p.cardManager.GetAllBotInfos()
-	for botID, botInfo := range allBotInfos {
+	for key, botInfo := range allBotInfos {
 		if botInfo.IsOnline {
 			onlineCount++
+			botID := key.String()
 			onlineTime := p.toolkit.Timer.GetElapsedTime(botID)
 			onlineMinutes := int(onlineTime.Minutes())
 			if onlineMinutes == 0 {
@@ -164,7 +186,7 @@ func (p *BotInfoPage) refreshAllData() {
 			}
 			rate := float64(p.toolkit.Counter.GetPeriodCount(botID, 1800)) / float64(periodMinutes)
 			p.cardManager.UpdateData(
-				botID,
+				key,
 				p.toolkit.Counter.GetTotalCount(botID),
 				rate,
 				int(onlineTime.Seconds()),
@@ -177,12 +199,13 @@ func (p *BotInfoPage) refreshAllData() {
 		p.statusLabel.SetText(fmt.Sprintf("%d 在线 / %d 离线", onlineCount, offlineCount))
 	}
 }
-func (p *BotInfoPage) handleToggleStatus(botID string, isOnlineNow bool) {
-	botInfo, ok := p.cardManager.GetBotInfo(botID)
+func (p *BotInfoPage) handleToggleStatus(key data.BotKey, isOnlineNow bool) {
+	botInfo, ok := p.cardManager.GetBotInfo(key)
 	if !ok {
-		p.logger.Warn("Bot not found for status toggle: %s", botID)
+		p.logger.Warn("Bot not found for status toggle: %s", key)
 		return
 	}
+	botID := botInfo.ID
 	newState := !isOnlineNow
 	p.logger.Info("Sending 'bot_switch' for bot %s, platform %s. New state: %v", botID, botInfo.Platform, newState)
 	params := map[string]interface{}{
@@ -190,25 +213,53 @@ func (p *BotInfoPage) handleToggleStatus(botID string, isOnlineNow bool) {
 		"platform":      botInfo.Platform,
 		"is_online_now": newState,
 	}
-	callback := &network.RequestCallback{
-		Success: func(payload interface{}) {
-			p.logger.Info("Successfully sent bot_switch request for bot %s", botID)
-			p.cardManager.SetOnlineStatus(botID, newState)
-			p.updateBotCount()
-		},
-		Error: func(err error) {
-			p.logger.Error("Failed to send bot_switch request: %v", err)
-			dialog.ShowError(err, p.mainWindow)
-		},
+	client := p.ClientFor(botInfo.Server)
+	if !client.HasCapability(protocol.CapabilityBotSwitch) {
+		dialog.ShowInformation("Bot 管理", "当前服务端不支持切换 Bot 状态", p.mainWindow)
+		return
 	}
-	if err := p.client.SendRequestWithCallback("bot_switch", params, callback); err != nil {
-		p.logger.Error("Failed to send bot_switch request: %v", err)
-		dialog.ShowError(err, p.mainWindow)
+	action := "停用"
+	if newState {
+		action = "启用"
+	}
+	mutation := network.Mutation{
+		Method:  "bot_switch",
+		Params:  params,
+		Summary: fmt.Sprintf("%s Bot %s", action, botID),
+	}
+	if client.HasCapability(protocol.CapabilityBotStatus) {
+		mutation.Guard = &network.Guard{
+			Method:      "get_bot_status",
+			Params:      map[string]interface{}{"bot_id": botID, "platform": botInfo.Platform},
+			Fingerprint: network.StateFingerprint(isOnlineNow),
+		}
 	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		response, err := client.Submit(ctx, mutation)
+		if errors.Is(err, network.ErrQueued) {
+			p.logger.Info("bot_switch for bot %s queued: %v", botID, err)
+			dialog.ShowInformation("Bot 管理", queuedNotice(err, "操作"), p.mainWindow)
+			return
+		}
+		if err == nil {
+			_, err = protocol.DecodeResponse("", "bot_switch", response)
+		}
+		if err != nil {
+			p.logger.Error("Failed to send bot_switch request: %v", err)
+			dialog.ShowError(err, p.mainWindow)
+			return
+		}
+		p.logger.Info("Successfully sent bot_switch request for bot %s", botID)
+		p.cardManager.SetOnlineStatus(key, newState)
+		p.updateBotCount()
+	}()
 }
 func (p *BotInfoPage) handleShowDetails(botInfo data.BotInfo, botStats bot.BotStats) {
 	content := container.NewVBox(
 		widget.NewLabel(fmt.Sprintf("Bot ID: %s", botInfo.ID)),
+		widget.NewLabel(fmt.Sprintf("Server: %s", botInfo.Server)),
 		widget.NewLabel(fmt.Sprintf("Platform: %s", botInfo.Platform)),
 		widget.NewLabel(fmt.Sprintf("Adapter: %s", botInfo.AdapterName)),
 		widget.NewSeparator(),
@@ -227,40 +278,35 @@ func formatUptime(seconds int) string {
 	}
 	return fmt.Sprintf("%dh %dm", hours, minutes)
 }
-func (p *BotInfoPage) handleShowRoster(botID string) {
-	p.logger.Info("开始获取Bot %s 的名单数据", botID)
+func (p *BotInfoPage) handleShowRoster(key data.BotKey) {
+	botID := key.ID
+	p.logger.Info("开始获取Bot %s 的名单数据", key)
+	client := p.ClientFor(key.Server)
+	if !client.HasCapability(protocol.CapabilityMatchers) {
+		dialog.ShowInformation("权限配置", "当前服务端不支持名单管理", p.mainWindow)
+		return
+	}
 	callback := &network.RequestCallback{
 		Success: func(payload interface{}) {
 			p.logger.Info("收到名单数据响应，payload类型: %T", payload)
 			if jsonBytes, err := json.Marshal(payload); err == nil {
 				p.logger.Info("原始响应内容: %s", string(jsonBytes))
 			}
-			var payloadMap map[string]interface{}
-			if respPayload, ok := payload.(map[string]interface{}); ok {
-				p.logger.Info("响应是map类型，包含以下键: %v", getMapKeys(respPayload))
-				if data, hasData := respPayload["data"]; hasData {
-					p.logger.Info("响应包含data字段，data类型: %T", data)
-					if dataMap, ok := data.(map[string]interface{}); ok {
-						p.logger.Info("data是map类型，包含以下键: %v", getMapKeys(dataMap))
-						payloadMap = dataMap
-					} else {
-						p.logger.Warn("data字段不是map类型，使用原始响应")
-						payloadMap = respPayload
-					}
-				} else {
-					p.logger.Info("响应不包含data字段，使用原始响应")
-					payloadMap = respPayload
-				}
-			} else {
-				dialog.ShowError(fmt.Errorf("无效的payload类型: %T", payload), p.mainWindow)
+			matchers, err := protocol.As[*protocol.Matchers](protocol.DecodeResponse("", "get_matchers", payload))
+			if err != nil {
+				dialog.ShowError(fmt.Errorf("名单数据无效: %w", err), p.mainWindow)
 				return
 			}
+			payloadMap := matchers.Roster
 			p.logger.Info("最终用于解析的payloadMap包含以下键: %v", getMapKeys(payloadMap))
-			pageBase := NewPageBase(p.client, p.storage, p.logger)
+			p.closeRoster()
+			pageBase := NewPageBase(client, p.storage, p.logger)
 			rosterPage := NewRosterPageForBot(payloadMap, func(data map[string]interface{}) {
 				p.logger.Info("名单数据保存触发")
 			}, p.mainWindow, pageBase, botID)  
+			p.rosterPage = rosterPage
 			backBtn := widget.NewButton("返回", func() {
+				p.closeRoster()
 				padded := container.NewPadded(p.cardContainer)
 				scroll := container.NewVScroll(padded)
 				scroll.SetMinSize(fyne.NewSize(320, 480))
@@ -280,24 +326,31 @@ func (p *BotInfoPage) handleShowRoster(botID string) {
 			dialog.ShowError(err, p.mainWindow)
 		},
 	}
-	if err := p.client.SendRequestWithCallback("get_matchers", map[string]interface{}{}, callback); err != nil {
+	if err := client.SendRequestWithCallback("get_matchers", map[string]interface{}{}, callback); err != nil {
 		dialog.ShowError(err, p.mainWindow)
 	}
 }
+func (p *BotInfoPage) closeRoster() {
+	if p.rosterPage == nil {
+		return
+	}
+	p.rosterPage.Close()
+	p.rosterPage = nil
+}
 type BotCardManager struct {
 	mu             sync.RWMutex
-	cards          map[string]*bot.BotCard
-	botInfos       map[string]data.BotInfo
-	botStats       map[string]bot.BotStats
-	onToggleStatus func(botID string, isOnline bool)
+	cards          map[data.BotKey]*bot.BotCard
+	botInfos       map[data.BotKey]data.BotInfo
+	botStats       map[data.BotKey]bot.BotStats
+	onToggleStatus func(key data.BotKey, isOnline bool)
 	onShowDetails  func(botInfo data.BotInfo, botStats bot.BotStats)
-	onShowRoster   func(botID string)
+	onShowRoster   func(key data.BotKey)
 }
-func NewBotCardManager(onToggleStatus func(botID string, isOnline bool), onShowDetails func(botInfo data.BotInfo, botStats bot.BotStats), onShowRoster func(botID string)) *BotCardManager {
+func NewBotCardManager(onToggleStatus func(key data.BotKey, isOnline bool), onShowDetails func(botInfo data.BotInfo, botStats bot.BotStats), onShowRoster func(key data.BotKey)) *BotCardManager {
 	return &BotCardManager{
-		cards:          make(map[string]*bot.BotCard),
-		botInfos:       make(map[string]data.BotInfo),
-		botStats:       make(map[string]bot.BotStats),
+		cards:          make(map[data.BotKey]*bot.BotCard),
+		botInfos:       make(map[data.BotKey]data.BotInfo),
+		botStats:       make(map[data.BotKey]bot.BotStats),
 		onToggleStatus: onToggleStatus,
 		onShowDetails:  onShowDetails,
 		onShowRoster:   onShowRoster,
@@ -306,47 +359,48 @@ func NewBotCardManager(onToggleStatus func(botID string, isOnline bool), onShowD
 func (m *BotCardManager) AddOrUpdate(botInfo data.BotInfo) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	m.botInfos[botInfo.ID] = botInfo
-	if card, exists := m.cards[botInfo.ID]; exists {
+	key := botInfo.Key()
+	m.botInfos[key] = botInfo
+	if card, exists := m.cards[key]; exists {
 		card.SetOnlineStatus(botInfo.IsOnline)
 	} else {
 		card := bot.NewBotCard(botInfo, m.onToggleStatus, m.onShowDetails, m.onShowRoster)
-		m.cards[botInfo.ID] = card
+		m.cards[key] = card
 	}
 }
-func (m *BotCardManager) SetOnlineStatus(botID string, isOnline bool) {
+func (m *BotCardManager) SetOnlineStatus(key data.BotKey, isOnline bool) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	if botInfo, ok := m.botInfos[botID]; ok {
+	if botInfo, ok := m.botInfos[key]; ok {
 		botInfo.IsOnline = isOnline
-		m.botInfos[botID] = botInfo
+		m.botInfos[key] = botInfo
 	}
-	if card, exists := m.cards[botID]; exists {
+	if card, exists := m.cards[key]; exists {
 		card.SetOnlineStatus(isOnline)
 	}
 }
-func (m *BotCardManager) UpdateData(botID string, total int, rate float64, uptime int) {
+func (m *BotCardManager) UpdateData(key data.BotKey, total int, rate float64, uptime int) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	m.botStats[botID] = bot.BotStats{
+	m.botStats[key] = bot.BotStats{
 		Total:  total,
 		Rate:   rate,
 		Uptime: uptime,
 	}
-	if card, exists := m.cards[botID]; exists {
+	if card, exists := m.cards[key]; exists {
 		card.UpdateData(total, rate, uptime)
 	}
 }
-func (m *BotCardManager) GetBotInfo(botID string) (data.BotInfo, bool) {
+func (m *BotCardManager) GetBotInfo(key data.BotKey) (data.BotInfo, bool) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	botInfo, ok := m.botInfos[botID]
+	botInfo, ok := m.botInfos[key]
 	return botInfo, ok
 }
-func (m *BotCardManager) GetBotStats(botID string) (bot.BotStats, bool) {
+func (m *BotCardManager) GetBotStats(key data.BotKey) (bot.BotStats, bool) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	stats, ok := m.botStats[botID]
+	stats, ok := m.botStats[key]
 	return stats, ok
 }
 func (m *BotCardManager) GetCards() []fyne.CanvasObject {
@@ -369,17 +423,29 @@ func (m *BotCardManager) GetCounts() (online, total int) {
 	}
 	return
 }
+func (m *BotCardManager) RemoveServer(server string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key := range m.botInfos {
+		if key.Server != server {
+			continue
+		}
+		delete(m.cards, key)
+		delete(m.botInfos, key)
+		delete(m.botStats, key)
+	}
+}
 func (m *BotCardManager) Clear() {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	m.cards = make(map[string]*bot.BotCard)
-	m.botInfos = make(map[string]data.BotInfo)
-	m.botStats = make(map[string]bot.BotStats)
+	m.cards = make(map[data.BotKey]*bot.BotCard)
+	m.botInfos = make(map[data.BotKey]data.BotInfo)
+	m.botStats = make(map[data.BotKey]bot.BotStats)
 }
-func (m *BotCardManager) GetAllBotInfos() map[string]data.BotInfo {
+func (m *BotCardManager) GetAllBotInfos() map[data.BotKey]data.BotInfo {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	result := make(map[string]data.BotInfo)
+	result := make(map[data.BotKey]data.BotInfo)
 	for k, v := range m.botInfos {
 		result[k] = v
 	}
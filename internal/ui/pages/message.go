@@ -1,20 +1,24 @@
 package pages
 import (
-	"encoding/json"
 	"fmt"
 	"lazytea-mobile/internal/data"
 	"lazytea-mobile/internal/network"
+	"lazytea-mobile/internal/protocol"
 	"lazytea-mobile/internal/ui/components/message"
 	"lazytea-mobile/internal/utils"
 	"strings"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
 	fyneTheme "fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
 )
 type MessagePage struct {
 	*PageBase
+	window           fyne.Window
 	messageContainer *fyne.Container
 	messageScroll    *container.Scroll
 	messages         []data.Message
@@ -22,15 +26,32 @@ type MessagePage struct {
 	searchEntry      *widget.Entry
 	autoScrollBtn    *widget.Button
 	clearBtn         *widget.Button
+	filterBtn        *widget.Button
 	statusLabel      *widget.Label
+	historyProgress  *widget.ProgressBarInfinite
 	emptyLabel       *widget.Label
 	autoScroll       bool
 	isSearching      bool
 	accentColor      string
+	conversationSelect *widget.Select
+	conversations      []data.ConversationSummary
+	conversation       *data.Conversation
+	hasOlder           bool
+	hasNewer           bool
+	loadingOlder       atomic.Bool
+	searchFilter       data.SearchQuery
 }
-func NewMessagePage(client *network.Client, storage *data.Storage, logger *utils.Logger) *MessagePage {
+const (
+	messagePageSize   = 50
+	maxMessageBubbles = 200
+	searchResultLimit = 100
+	searchDateLayout  = "2006-01-02"
+	longResultRunes   = 120
+)
+func NewMessagePage(clients *network.Manager, storage *data.Storage, logger *utils.Logger, window fyne.Window) *MessagePage {
 	page := &MessagePage{
-		PageBase:       NewPageBase(client, storage, logger),
+		PageBase:       NewManagedPageBase(clients, storage, logger),
+		window:         window,
 		messages:       make([]data.Message, 0),
 		messageBubbles: make([]*message.MessageBubble, 0),
 		autoScroll:     true,
@@ -46,9 +67,9 @@ func (p *MessagePage) setupUI() {
 	p.statusLabel = widget.NewLabel("正在加载...")
 	p.statusLabel.Alignment = fyne.TextAlignCenter
 	p.searchEntry = widget.NewEntry()
-	p.searchEntry.SetPlaceHolder("搜索消息内容...")
+	p.searchEntry.SetPlaceHolder("搜索消息内容，\"引号\" 内为短语...")
 	p.searchEntry.OnSubmitted = func(query string) {
-		if strings.TrimSpace(query) != "" {
+		if strings.TrimSpace(query) != "" || p.searchFilter.HasFilters() {
 			p.searchMessages(query)
 		} else {
 			p.loadRecentMessages()
@@ -56,12 +77,18 @@ func (p *MessagePage) setupUI() {
 	}
 	searchBtn := widget.NewButtonWithIcon("", fyneTheme.SearchIcon(), func() {
 		query := strings.TrimSpace(p.searchEntry.Text)
-		if query != "" {
+		if query != "" || p.searchFilter.HasFilters() {
 			p.searchMessages(query)
 		}
 	})
+	p.filterBtn = widget.NewButtonWithIcon("", fyneTheme.ListIcon(), func() {
+		p.showSearchFilters()
+	})
 	p.clearBtn = widget.NewButtonWithIcon("", fyneTheme.CancelIcon(), func() {
 		p.searchEntry.SetText("")
+		p.searchFilter = data.SearchQuery{}
+		p.filterBtn.Importance = widget.MediumImportance
+		p.filterBtn.Refresh()
 		p.loadRecentMessages()
 	})
 	p.clearBtn.Hide()  
@@ -78,16 +105,30 @@ func (p *MessagePage) setupUI() {
 	})
 	searchContainer := container.NewBorder(
 		nil, nil, nil,
-		container.NewHBox(searchBtn, p.clearBtn, refreshBtn),
+		container.NewHBox(searchBtn, p.filterBtn, p.clearBtn, refreshBtn),
 		p.searchEntry,
 	)
+	p.conversationSelect = widget.NewSelect([]string{allConversationsLabel}, func(selected string) {
+		p.selectConversation(selected)
+	})
+	p.conversationSelect.SetSelected(allConversationsLabel)
+	conversationContainer := container.NewBorder(nil, nil, widget.NewLabel("会话"), nil, p.conversationSelect)
+	p.historyProgress = widget.NewProgressBarInfinite()
+	p.historyProgress.Stop()
+	p.historyProgress.Hide()
 	controlContainer := container.NewHBox(
 		p.autoScrollBtn,
 		widget.NewSeparator(),
 		p.statusLabel,
+		p.historyProgress,
 	)
 	p.messageContainer = container.NewVBox()
 	p.messageScroll = container.NewScroll(p.messageContainer)
+	p.messageScroll.OnScrolled = func(offset fyne.Position) {
+		if offset.Y <= 0 {
+			p.loadOlderMessages()
+		}
+	}
 	p.emptyLabel = widget.NewLabel("暂无消息记录")
 	p.emptyLabel.Alignment = fyne.TextAlignCenter
 	p.emptyLabel.Hide()  
@@ -95,6 +136,7 @@ func (p *MessagePage) setupUI() {
 		titleLabel,
 		widget.NewSeparator(),
 		searchContainer,
+		conversationContainer,
 		controlContainer,
 		widget.NewSeparator(),
 	)
@@ -114,14 +156,41 @@ func (p *MessagePage) addMessageBubbleToContainer(msg data.Message) {
 	bubble := message.NewMessageBubble(msg, p.accentColor)
 	bubbleWithMargin := container.NewPadded(bubble)
 	p.messageContainer.Add(bubbleWithMargin)
-	if len(p.messageContainer.Objects) > 50 {
-		p.messageContainer.Objects = p.messageContainer.Objects[1:]
+	if p.autoScroll && len(p.messageContainer.Objects) > maxMessageBubbles {
+		trim := len(p.messageContainer.Objects) - maxMessageBubbles
+		p.messageContainer.Objects = p.messageContainer.Objects[trim:]
+		if len(p.messages) > maxMessageBubbles {
+			p.messages = p.messages[len(p.messages)-maxMessageBubbles:]
+		}
+		p.hasOlder = true
 		p.messageContainer.Refresh()
 	}
 	if p.autoScroll {
 		p.scrollToBottom()
 	}
 }
+func (p *MessagePage) prependMessageBubbles(messages []data.Message) {
+	bubbles := make([]fyne.CanvasObject, 0, len(messages)+len(p.messageContainer.Objects))
+	for _, msg := range messages {
+		bubbles = append(bubbles, container.NewPadded(message.NewMessageBubble(msg, p.accentColor)))
+	}
+	before := p.messageContainer.MinSize().Height
+	p.messageContainer.Objects = append(bubbles, p.messageContainer.Objects...)
+	if len(p.messageContainer.Objects) > maxMessageBubbles {
+		p.messageContainer.Objects = p.messageContainer.Objects[:maxMessageBubbles]
+		if len(p.messages) > maxMessageBubbles {
+			p.messages = p.messages[:maxMessageBubbles]
+		}
+		// 最新的消息已被裁掉，停止追加实时消息，恢复自动滚动时重新加载
+		p.hasNewer = true
+		if p.autoScroll {
+			p.toggleAutoScroll()
+		}
+	}
+	p.messageContainer.Refresh()
+	p.messageScroll.Offset.Y += p.messageContainer.MinSize().Height - before
+	p.messageScroll.Refresh()
+}
 func (p *MessagePage) clearMessageBubbles() {
 	p.messageContainer.Objects = []fyne.CanvasObject{}
 	p.messageContainer.Refresh()
@@ -135,137 +204,95 @@ func (p *MessagePage) addMessageBubbleWithMeta(msg data.Message) {
 	p.addMessageBubbleToContainer(msg)
 }
 func (p *MessagePage) setupEventHandlers() {
-	p.client.OnConnectionChanged(func(connected bool) {
-		if connected {
-			p.statusLabel.SetText("已连接")
+	p.Track(p.clients.OnConnectionChanged(func(server string, connected bool) {
+		online, total := p.clients.ConnectionCounts()
+		if online > 0 {
+			if total > 1 {
+				p.statusLabel.SetText(fmt.Sprintf("已连接 %d/%d", online, total))
+			} else {
+				p.statusLabel.SetText("已连接")
+			}
 			p.statusLabel.Importance = widget.SuccessImportance
 		} else {
 			p.statusLabel.SetText("未连接")
 			p.statusLabel.Importance = widget.DangerImportance
 		}
-	})
-	p.client.OnMessage("message", func(header network.MessageHeader, payload interface{}) {
-		p.handleNewMessage(payload)
-	})
-	p.client.OnMessage("call_api", func(header network.MessageHeader, payload interface{}) {
-		p.handleNewMessage(payload)
-	})
-	p.client.OnMessage("plugin_call", func(header network.MessageHeader, payload interface{}) {
-		payloadBytes, err := json.Marshal(payload)
+	}))
+	if history := p.clients.HistorySync(); history != nil {
+		p.Track(history.OnProgress(p.updateHistoryProgress))
+	}
+	subscribeOptions := network.DefaultSubscribeOptions()
+	subscribeOptions.Name = "message-page"
+	// 回调会修改页面状态，单通道按顺序处理避免并发
+	subscribeOptions.Lanes = 1
+	p.Track(p.clients.OnMessageWithOptions("message", func(header network.MessageHeader, payload interface{}) {
+		event, err := protocol.As[*protocol.Message](protocol.DecodeEvent(header.Version, header.MsgType, payload))
 		if err != nil {
-			p.logger.Error("Failed to marshal plugin_call payload: %v", err)
+			p.logger.Warn("Ignoring message: %v", err)
 			return
 		}
-		var m map[string]interface{}
-		if err := json.Unmarshal(payloadBytes, &m); err != nil {
-			p.logger.Error("Failed to unmarshal plugin_call payload: %v", err)
+		p.handleNewMessage(header, event)
+	}, subscribeOptions))
+	p.Track(p.clients.OnMessageWithOptions("call_api", func(header network.MessageHeader, payload interface{}) {
+		event, err := protocol.As[*protocol.CallAPI](protocol.DecodeEvent(header.Version, header.MsgType, payload))
+		if err != nil {
+			p.logger.Warn("Ignoring call_api: %v", err)
+			return
+		}
+		p.handleNewMessage(header, &event.Message)
+	}, subscribeOptions))
+	p.Track(p.clients.OnMessageWithOptions("plugin_call", func(header network.MessageHeader, payload interface{}) {
+		event, err := protocol.As[*protocol.PluginCall](protocol.DecodeEvent(header.Version, header.MsgType, payload))
+		if err != nil {
+			p.logger.Error("Failed to decode plugin_call payload: %v", err)
 			return
 		}
 		rec := data.PluginCallRecord{
-			Bot:        p.getString(m, "bot"),
-			Platform:   p.getString(m, "platform"),
-			PluginName: p.getString(m, "plugin"),
-			MatcherHash: func() string {
-				if v, ok := m["matcher_hash"].(string); ok {
-					return v
-				}
-				if arr, ok := m["matcher_hash"].([]interface{}); ok {  
-					parts := make([]string, 0, len(arr))
-					for _, it := range arr {
-						parts = append(parts, p.getString(map[string]interface{}{"v": it}, "v"))
-					}
-					return strings.Join(parts, ",")
-				}
-				return ""
-			}(),
-			TimeCosted: func() float64 {
-				if v, ok := m["time_costed"].(float64); ok {
-					return v
-				}
-				return 0
-			}(),
-			Timestamp: func() int64 {
-				if v, ok := m["time"].(float64); ok {
-					return int64(v)
-				}
-				if v2, ok := m["time"].(int64); ok {
-					return v2
-				}
-				return time.Now().Unix()
-			}(),
-		}
-		if gid, ok := m["groupid"].(string); ok && gid != "" {
+			Server:      header.Server,
+			Bot:         string(event.Bot),
+			Platform:    event.Platform,
+			PluginName:  event.Plugin,
+			MatcherHash: event.MatcherHash.Join(),
+			TimeCosted:  event.TimeCosted,
+			Timestamp:   time.Now().Unix(),
+		}
+		if !event.Time.IsZero() {
+			rec.Timestamp = event.Time.Unix()
+		}
+		if gid := string(event.GroupID); gid != "" {
 			rec.GroupID = &gid
 		}
-		if uid, ok := m["userid"].(string); ok && uid != "" {
+		if uid := string(event.UserID); uid != "" {
 			rec.UserID = &uid
 		}
-		if ex, ok := m["exception"].(map[string]interface{}); ok {
-			if n, ok := ex["name"].(string); ok && n != "" {
-				rec.ExceptionName = &n
+		if ex := event.Exception; ex != nil {
+			if ex.Name != "" {
+				rec.ExceptionName = &ex.Name
 			}
-			if d, ok := ex["detail"].(string); ok && d != "" {
-				rec.ExceptionDetail = &d
+			if ex.Detail != "" {
+				rec.ExceptionDetail = &ex.Detail
 			}
 		}
+		if header.Replayed {
+			return
+		}
 		if err := p.storage.SavePluginCall(rec); err != nil {
 			p.logger.Error("Failed to save plugin_call record: %v", err)
 		}
-	})
+	}, subscribeOptions))
 }
-func (p *MessagePage) handleNewMessage(payload interface{}) {
-	payloadBytes, err := json.Marshal(payload)
+func (p *MessagePage) handleNewMessage(header network.MessageHeader, event *protocol.Message) {
+	msg, err := data.MessageFromEvent(header.Server, event)
 	if err != nil {
-		p.logger.Error("Failed to marshal message payload: %v", err)
+		p.logger.Error("Failed to build message: %v", err)
 		return
 	}
-	var msgData map[string]interface{}
-	if err := json.Unmarshal(payloadBytes, &msgData); err != nil {
-		p.logger.Error("Failed to unmarshal message data: %v", err)
-		return
-	}
-	metadata := map[string]interface{}{
-		"bot":        []interface{}{p.getString(msgData, "bot"), "color: {bot_color}; font-weight: bold;"},
-		"time":       []interface{}{msgData["time"], "color: #757575; font-size: 12px;"},
-		"session":    []interface{}{fmt.Sprintf("会话：%s", p.getString(msgData, "session")), "color: #616161; font-style: italic;"},
-		"avatar":     []interface{}{p.getString(msgData, "avatar"), 0},  
-		"timestamps": []interface{}{msgData["time"], "hidden"},
-	}
-	metaBytes, err := json.Marshal(metadata)
-	if err != nil {
-		p.logger.Error("Failed to marshal metadata: %v", err)
-		return
-	}
-	metaStr := string(metaBytes)
-	msg := data.Message{
-		Bot:       p.getString(msgData, "bot"),
-		BotID:     p.getString(msgData, "bot"),
-		Content:   p.extractContent(msgData),
-		FromBot:   msgData["from_bot"] == true,
-		Timestamp: time.Now(),
-		User:      p.getString(msgData, "userid"),
-		UserID:    p.getString(msgData, "userid"),
-		UserName:  p.getString(msgData, "username"),
-		Plaintext: p.extractContent(msgData),  
-		Meta:      &metaStr,                   
-	}
-	if groupID, ok := msgData["groupid"].(string); ok && groupID != "" {
-		msg.GroupID = &groupID
-		if groupName, ok := msgData["groupname"].(string); ok && groupName != "" {
-			msg.GroupName = &groupName
+	if !header.Replayed {
+		if err := p.storage.SaveMessage(msg); err != nil {
+			p.logger.Error("Failed to save message: %v", err)
 		}
 	}
-	if tv, ok := msgData["time"].(float64); ok {
-		if tv > 1e10 {  
-			msg.Timestamps = int64(tv)
-		} else {
-			msg.Timestamps = int64(tv * 1000)
-		}
-	}
-	if err := p.storage.SaveMessage(msg); err != nil {
-		p.logger.Error("Failed to save message: %v", err)
-	}
-	if !p.isSearching && p.autoScroll {
+	if !p.isSearching && p.autoScroll && (p.conversation == nil || p.conversation.Contains(msg)) {
 		p.messages = append(p.messages, msg)
 		p.addMessageBubbleWithMeta(msg)
 	}
@@ -278,52 +305,55 @@ func (p *MessagePage) handleNewMessage(payload interface{}) {
 	}()
 	p.updateEmptyState()  
 }
-func (p *MessagePage) getString(data map[string]interface{}, key string) string {
-	if val, ok := data[key]; ok {
-		if str, ok := val.(string); ok {
-			return str
+func (p *MessagePage) updateHistoryProgress(progress network.HistoryProgress) {
+	switch progress.Phase {
+	case network.HistoryStarted:
+		p.historyProgress.Show()
+		p.historyProgress.Start()
+		p.statusLabel.SetText("正在同步历史消息...")
+	case network.HistoryFetching:
+		p.statusLabel.SetText(fmt.Sprintf("正在同步历史消息... 第 %d 页，共 %d 条", progress.Pages, progress.Fetched))
+	case network.HistoryDone:
+		p.historyProgress.Stop()
+		p.historyProgress.Hide()
+		if progress.Imported == 0 {
+			p.statusLabel.SetText("历史消息已是最新")
+			return
 		}
-	}
-	return ""
-}
-func (p *MessagePage) extractContent(data map[string]interface{}) string {
-	if content, ok := data["content"]; ok {
-		if contentSlice, ok := content.([]interface{}); ok {
-			var contentParts []string
-			for _, part := range contentSlice {
-				if partSlice, ok := part.([]interface{}); ok && len(partSlice) >= 2 {
-					if partType, ok := partSlice[0].(string); ok {
-						if partType == "text" {
-							if text, ok := partSlice[1].(string); ok {
-								contentParts = append(contentParts, text)
-							}
-						} else {
-							contentParts = append(contentParts, fmt.Sprintf("[%s]", partType))
-						}
-					}
-				}
-			}
-			return strings.Join(contentParts, "")
+		p.statusLabel.SetText(fmt.Sprintf("已同步 %d 条历史消息", progress.Imported))
+		if !p.isSearching {
+			p.loadRecentMessages()
 		}
-		if str, ok := content.(string); ok {
-			return str
+	case network.HistoryTruncated:
+		p.historyProgress.Stop()
+		p.historyProgress.Hide()
+		p.statusLabel.SetText(fmt.Sprintf("已同步 %d 条历史消息，其余将在下次连接时继续同步", progress.Imported))
+		if !p.isSearching {
+			p.loadRecentMessages()
 		}
+	case network.HistoryFailed:
+		p.historyProgress.Stop()
+		p.historyProgress.Hide()
+		p.statusLabel.SetText("历史消息同步失败")
 	}
-	return ""
 }
 func (p *MessagePage) loadRecentMessages() {
 	p.isSearching = false
 	p.clearBtn.Hide()
 	p.statusLabel.SetText("正在加载...")
 	go func() {
-		messages, err := p.storage.GetMessages(50, 0)
+		p.loadConversations()
+		page, err := p.storage.QueryMessages(data.MessageQuery{Conversation: p.conversation, Limit: messagePageSize})
 		if err != nil {
 			p.logger.Error("Failed to load messages: %v", err)
 			p.statusLabel.SetText("加载失败")
 			p.updateEmptyState()
 			return
 		}
+		messages := page.Messages
 		p.messages = messages
+		p.hasOlder = page.HasMore
+		p.hasNewer = false
 		p.clearMessageBubbles()
 		if len(messages) > 0 {
 			for _, msg := range messages {
@@ -345,32 +375,217 @@ func (p *MessagePage) loadRecentMessages() {
 		p.updateEmptyState()  
 	}()
 }
+func (p *MessagePage) loadOlderMessages() {
+	if p.isSearching || !p.hasOlder || len(p.messages) == 0 {
+		return
+	}
+	if !p.loadingOlder.CompareAndSwap(false, true) {
+		return
+	}
+	cursor := data.CursorOf(p.messages[0])
+	p.statusLabel.SetText("正在加载更早的消息...")
+	go func() {
+		defer p.loadingOlder.Store(false)
+		page, err := p.storage.QueryMessages(data.MessageQuery{Conversation: p.conversation, Before: &cursor, Limit: messagePageSize})
+		if err != nil {
+			p.logger.Error("Failed to load older messages: %v", err)
+			p.statusLabel.SetText("加载失败")
+			return
+		}
+		p.hasOlder = page.HasMore
+		if len(page.Messages) > 0 {
+			p.messages = append(append([]data.Message(nil), page.Messages...), p.messages...)
+			p.prependMessageBubbles(page.Messages)
+		}
+		if p.hasOlder {
+			p.statusLabel.SetText(fmt.Sprintf("已加载 %d 条消息", len(p.messages)))
+		} else {
+			p.statusLabel.SetText(fmt.Sprintf("已加载全部 %d 条消息", len(p.messages)))
+		}
+	}()
+}
+const allConversationsLabel = "全部会话"
+func conversationLabel(summary data.ConversationSummary) string {
+	bot := data.BotKey{Server: summary.Server, ID: summary.Bot}
+	if summary.IsGroup() {
+		return fmt.Sprintf("%s · 群 %s (%d)", bot, summary.GroupID, summary.Count)
+	}
+	return fmt.Sprintf("%s · 私聊 %s (%d)", bot, summary.UserID, summary.Count)
+}
+func (p *MessagePage) loadConversations() {
+	summaries, err := p.storage.ListConversations(100)
+	if err != nil {
+		p.logger.Error("Failed to load conversations: %v", err)
+		return
+	}
+	p.conversations = summaries
+	options := []string{allConversationsLabel}
+	selected := allConversationsLabel
+	for _, summary := range summaries {
+		label := conversationLabel(summary)
+		options = append(options, label)
+		if p.conversation != nil && summary.Conversation == *p.conversation {
+			selected = label
+		}
+	}
+	p.conversationSelect.Options = options
+	p.conversationSelect.Selected = selected
+	p.conversationSelect.Refresh()
+}
+func (p *MessagePage) selectConversation(selected string) {
+	var conversation *data.Conversation
+	for _, summary := range p.conversations {
+		if conversationLabel(summary) == selected {
+			value := summary.Conversation
+			conversation = &value
+			break
+		}
+	}
+	if conversation == nil && p.conversation == nil {
+		return
+	}
+	if conversation != nil && p.conversation != nil && *conversation == *p.conversation {
+		return
+	}
+	p.conversation = conversation
+	p.searchEntry.SetText("")
+	p.loadRecentMessages()
+}
 func (p *MessagePage) searchMessages(query string) {
 	p.isSearching = true
 	p.clearBtn.Show()
 	p.statusLabel.SetText("正在搜索...")
+	search := p.searchFilter
+	search.Text = query
+	search.Limit = searchResultLimit
 	go func() {
-		messages, err := p.storage.SearchMessages(query, 100)
+		results, err := p.storage.Search(search)
 		if err != nil {
 			p.logger.Error("Failed to search messages: %v", err)
 			p.statusLabel.SetText("搜索失败")
 			return
 		}
-		p.messages = messages
+		p.messages = make([]data.Message, 0, len(results))
 		p.clearMessageBubbles()
-		for _, msg := range messages {
-			p.addMessageBubbleToContainer(msg)
+		for _, result := range results {
+			p.messages = append(p.messages, result.Message)
+			p.addSearchResultBubble(result)
 		}
 		p.statusLabel.SetText(fmt.Sprintf("找到 %d 条匹配消息", len(p.messages)))
 		p.updateEmptyState()  
 	}()
 }
+func (p *MessagePage) addSearchResultBubble(result data.SearchResult) {
+	bubble := message.NewMessageBubble(result.Message, p.accentColor)
+	highlighted := result.Highlight
+	if utf8.RuneCountInString(highlighted) > longResultRunes && result.Snippet != "" {
+		highlighted = result.Snippet
+	}
+	if highlighted != "" {
+		bubble.SetHighlight(highlighted)
+	}
+	p.messageContainer.Add(container.NewPadded(bubble))
+}
+func (p *MessagePage) showSearchFilters() {
+	filter := p.searchFilter
+	botEntry := widget.NewEntry()
+	botEntry.SetText(filter.Bot)
+	botEntry.SetPlaceHolder("Bot ID")
+	userEntry := widget.NewEntry()
+	userEntry.SetText(filter.UserID)
+	userEntry.SetPlaceHolder("用户 ID")
+	groupEntry := widget.NewEntry()
+	groupEntry.SetText(filter.GroupID)
+	groupEntry.SetPlaceHolder("群号")
+	sinceEntry := widget.NewEntry()
+	sinceEntry.SetPlaceHolder(searchDateLayout)
+	if !filter.Since.IsZero() {
+		sinceEntry.SetText(filter.Since.Format(searchDateLayout))
+	}
+	untilEntry := widget.NewEntry()
+	untilEntry.SetPlaceHolder(searchDateLayout)
+	if !filter.Until.IsZero() {
+		untilEntry.SetText(filter.Until.AddDate(0, 0, -1).Format(searchDateLayout))
+	}
+	senderOptions := []string{"全部", "仅用户", "仅Bot"}
+	senderSelect := widget.NewSelect(senderOptions, nil)
+	switch {
+	case filter.FromBot == nil:
+		senderSelect.SetSelected(senderOptions[0])
+	case *filter.FromBot:
+		senderSelect.SetSelected(senderOptions[2])
+	default:
+		senderSelect.SetSelected(senderOptions[1])
+	}
+	items := []*widget.FormItem{
+		widget.NewFormItem("Bot", botEntry),
+		widget.NewFormItem("用户", userEntry),
+		widget.NewFormItem("群组", groupEntry),
+		widget.NewFormItem("开始日期", sinceEntry),
+		widget.NewFormItem("结束日期", untilEntry),
+		widget.NewFormItem("发送方", senderSelect),
+	}
+	dialog.ShowForm("筛选条件", "应用", "取消", items, func(ok bool) {
+		if !ok {
+			return
+		}
+		next := data.SearchQuery{
+			Bot:     strings.TrimSpace(botEntry.Text),
+			UserID:  strings.TrimSpace(userEntry.Text),
+			GroupID: strings.TrimSpace(groupEntry.Text),
+		}
+		var err error
+		if next.Since, err = parseSearchDate(sinceEntry.Text); err != nil {
+			dialog.ShowError(fmt.Errorf("开始日期格式应为 %s", searchDateLayout), p.window)
+			return
+		}
+		if next.Until, err = parseSearchDate(untilEntry.Text); err != nil {
+			dialog.ShowError(fmt.Errorf("结束日期格式应为 %s", searchDateLayout), p.window)
+			return
+		}
+		if !next.Until.IsZero() {
+			next.Until = next.Until.AddDate(0, 0, 1)
+		}
+		switch senderSelect.Selected {
+		case senderOptions[1]:
+			fromBot := false
+			next.FromBot = &fromBot
+		case senderOptions[2]:
+			fromBot := true
+			next.FromBot = &fromBot
+		}
+		p.searchFilter = next
+		if next.HasFilters() {
+			p.filterBtn.Importance = widget.HighImportance
+		} else {
+			p.filterBtn.Importance = widget.MediumImportance
+		}
+		p.filterBtn.Refresh()
+		query := strings.TrimSpace(p.searchEntry.Text)
+		if query != "" || next.HasFilters() {
+			p.searchMessages(query)
+		} else if p.isSearching {
+			p.loadRecentMessages()
+		}
+	}, p.window)
+}
+func parseSearchDate(text string) (time.Time, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return time.Time{}, nil
+	}
+	return time.ParseInLocation(searchDateLayout, text, time.Local)
+}
 func (p *MessagePage) toggleAutoScroll() {
 	p.autoScroll = !p.autoScroll
 	if p.autoScroll {
 		p.autoScrollBtn.SetText("自动滚动")
 		p.autoScrollBtn.SetIcon(fyneTheme.MoveDownIcon())
 		p.autoScrollBtn.Importance = widget.MediumImportance
+		if p.hasNewer && !p.isSearching {
+			p.loadRecentMessages()
+			return
+		}
 		p.scrollToBottom()
 	} else {
 		p.autoScrollBtn.SetText("手动浏览")
@@ -20,10 +20,11 @@ type OverviewPage struct {
 	messageCountLabel     *widget.Label
 	versionLabel   *widget.Label
 	statsContainer *fyne.Container
+	connect        func() error
 }
-func NewOverviewPage(client *network.Client, storage *data.Storage, logger *utils.Logger, cfg *config.Config) *OverviewPage {
+func NewOverviewPage(clients *network.Manager, storage *data.Storage, logger *utils.Logger, cfg *config.Config) *OverviewPage {
 	page := &OverviewPage{
-		PageBase: NewPageBase(client, storage, logger),
+		PageBase: NewManagedPageBase(clients, storage, logger),
 		config:   cfg,
 	}
 	page.setupUI()
@@ -34,6 +35,9 @@ func NewOverviewPage(client *network.Client, storage *data.Storage, logger *util
 	}()
 	return page
 }
+func (p *OverviewPage) SetConnector(connect func() error) {
+	p.connect = connect
+}
 func (p *OverviewPage) setupUI() {
 	titleLabel := widget.NewLabelWithStyle("🌟 LazyTea Mobile", fyne.TextAlignCenter, fyne.TextStyle{Bold: true})
 	titleLabel.TextStyle = fyne.TextStyle{Bold: true}
@@ -126,31 +130,40 @@ func (p *OverviewPage) createModernInfoCard(title string, valueLabel *widget.Lab
 	return card
 }
 func (p *OverviewPage) setupEventHandlers() {
-	p.client.OnConnectionChanged(func(connected bool) {
-		if connected {
-			p.connectionStatusLabel.SetText("✓ 已连接")
-			p.connectionStatusLabel.Importance = widget.SuccessImportance
-		} else {
+	p.Track(p.clients.OnConnectionChanged(func(server string, connected bool) {
+		online, total := p.clients.ConnectionCounts()
+		switch {
+		case online == 0:
 			p.connectionStatusLabel.SetText("✗ 未连接")
 			p.connectionStatusLabel.Importance = widget.DangerImportance
 			p.onlineBotCountLabel.SetText("0 / 0")
+		case total > 1:
+			p.connectionStatusLabel.SetText(fmt.Sprintf("✓ 已连接 %d/%d", online, total))
+			p.connectionStatusLabel.Importance = widget.SuccessImportance
+		default:
+			p.connectionStatusLabel.SetText("✓ 已连接")
+			p.connectionStatusLabel.Importance = widget.SuccessImportance
 		}
 		p.refreshData()
-	})
-	p.client.OnMessage("bot_connect", func(header network.MessageHeader, payload interface{}) {
-		go p.refreshData()  
-	})
-	p.client.OnMessage("bot_disconnect", func(header network.MessageHeader, payload interface{}) {
-		go p.refreshData()  
-	})
-	p.client.OnMessage("message", func(header network.MessageHeader, payload interface{}) {
-		go func() {
-			totalMsgs, err := p.storage.GetTotalMessageCount()
-			if err == nil {
-				p.messageCountLabel.SetText(fmt.Sprintf("%d", totalMsgs))
-			}
-		}()
-	})
+	}))
+	refreshOptions := network.SubscribeOptions{
+		Name:      "overview",
+		QueueSize: 16,
+		Policy:    network.PolicyCoalesce,
+		Key:       network.TypeKey,
+	}
+	p.Track(p.clients.OnMessageWithOptions("bot_connect", func(header network.MessageHeader, payload interface{}) {
+		p.refreshData()
+	}, refreshOptions))
+	p.Track(p.clients.OnMessageWithOptions("bot_disconnect", func(header network.MessageHeader, payload interface{}) {
+		p.refreshData()
+	}, refreshOptions))
+	p.Track(p.clients.OnMessageWithOptions("message", func(header network.MessageHeader, payload interface{}) {
+		totalMsgs, err := p.storage.GetTotalMessageCount()
+		if err == nil {
+			p.messageCountLabel.SetText(fmt.Sprintf("%d", totalMsgs))
+		}
+	}, refreshOptions))
 }
 func (p *OverviewPage) refreshData() {
 	go func() {
@@ -195,17 +208,13 @@ func (p *OverviewPage) toggleConnection() {
 		p.logger.Info("断开连接")
 		return
 	}
-	if p.config.Network.Host == "" || p.config.Network.Port == 0 {
+	if p.connect == nil {
 		p.logger.Error("连接配置不完整，请在设置页面配置服务器信息")
 		return
 	}
 	p.logger.Info("正在连接到服务器...")
 	go func() {
-		if err := p.client.Connect(
-			p.config.Network.Host,
-			p.config.Network.Port,
-			p.config.Network.Token,
-		); err != nil {
+		if err := p.connect(); err != nil {
 			p.logger.Error("连接失败: %v", err)
 		}
 	}()
@@ -6,6 +6,7 @@ import (
 	"image/color"
 	"lazytea-mobile/internal/data"
 	"lazytea-mobile/internal/network"
+	"lazytea-mobile/internal/protocol"
 	"lazytea-mobile/internal/utils"
 	"regexp"
 	"strconv"
@@ -124,6 +125,9 @@ func (p *PluginPage) createPluginCard(plugin data.Plugin, _ int) fyne.CanvasObje
 		p.showInlinePluginConfig(plugin)
 	})
 	configBtn.Importance = widget.LowImportance
+	if !p.client.HasCapability(protocol.CapabilityPluginConfig) {
+		configBtn.Hide()
+	}
 	desc := plugin.Meta.Description
 	if len(desc) > 100 {
 		desc = desc[:100] + "..."
@@ -186,11 +190,11 @@ func (p *PluginPage) createPluginCard(plugin data.Plugin, _ int) fyne.CanvasObje
 	return container.NewPadded(card)
 }
 func (p *PluginPage) setupEventHandlers() {
-	p.client.OnConnectionChanged(func(connected bool) {
+	p.Track(p.client.OnConnectionChanged(func(connected bool) {
 		if connected {
 			p.requestPluginList()
 		}
-	})
+	}))
 }
 func (p *PluginPage) loadPlugins() {
 	p.statusLabel.SetText("正在请求服务端数据...")
@@ -205,54 +209,19 @@ func (p *PluginPage) requestPluginList() {
 	p.logger.Debug("Requesting plugin list with empty params")
 	callback := &network.RequestCallback{
 		Success: func(payload interface{}) {
-			var dataAny interface{} = payload
-			if pm, ok := payload.(map[string]interface{}); ok {
-				if d, has := pm["data"]; has {
-					dataAny = d
-				}
-			}
-			parsed := make([]data.Plugin, 0)
-			switch v := dataAny.(type) {
-			case []interface{}:
-				for _, item := range v {
-					if m, ok := item.(map[string]interface{}); ok {
-						parsed = append(parsed, data.Plugin{
-							Name:   toString(m["name"]),
-							Module: toString(m["module"]),
-							Meta: data.PluginMeta{
-								Name:        toString(getMeta(m, "name")),
-								Description: toString(getMeta(m, "description")),
-								Homepage:    toString(getMeta(m, "homepage")),
-								ConfigExist: toBool(getMeta(m, "config_exist")),
-								IconAbspath: toString(getMeta(m, "icon_abspath")),
-								Author:      toString(getMeta(m, "author")),
-								Version:     toString(getMeta(m, "version")),
-							},
-						})
-					}
-				}
-			case map[string]interface{}:
-				for _, item := range v {
-					if m, ok := item.(map[string]interface{}); ok {
-						parsed = append(parsed, data.Plugin{
-							Name:   toString(m["name"]),
-							Module: toString(m["module"]),
-							Meta: data.PluginMeta{
-								Name:        toString(getMeta(m, "name")),
-								Description: toString(getMeta(m, "description")),
-								Homepage:    toString(getMeta(m, "homepage")),
-								ConfigExist: toBool(getMeta(m, "config_exist")),
-								IconAbspath: toString(getMeta(m, "icon_abspath")),
-								Author:      toString(getMeta(m, "author")),
-								Version:     toString(getMeta(m, "version")),
-							},
-						})
-					}
-				}
-			default:
-				p.logger.Warn("Invalid plugin list format: %T", dataAny)
+			list, err := protocol.As[*protocol.PluginList](protocol.DecodeResponse("", "get_plugins", payload))
+			if err != nil {
+				p.logger.Warn("Invalid plugin list: %v", err)
 				return
 			}
+			parsed := make([]data.Plugin, 0, len(list.Plugins))
+			for _, info := range list.Plugins {
+				parsed = append(parsed, data.Plugin{
+					Name:   info.Name,
+					Module: info.Module,
+					Meta:   data.PluginMeta(info.Meta),
+				})
+			}
 			p.allPlugins = parsed
 			if p.isSearching {
 				p.filterPlugins(p.searchEntry.Text)
@@ -270,21 +239,6 @@ func (p *PluginPage) requestPluginList() {
 		p.logger.Error("Failed to send plugin list request: %v", err)
 	}
 }
-func getMeta(m map[string]interface{}, key string) interface{} {
-	if meta, ok := m["meta"].(map[string]interface{}); ok {
-		return meta[key]
-	}
-	return nil
-}
-func toBool(v interface{}) bool {
-	if v == nil {
-		return false
-	}
-	if b, ok := v.(bool); ok {
-		return b
-	}
-	return false
-}
 func toString(v interface{}) string {
 	if v == nil {
 		return ""
@@ -362,7 +316,7 @@ func (p *PluginPage) showPluginDetails(plugin data.Plugin) {
 	details.Add(descScroll)
 	details.Add(widget.NewSeparator())
 	buttonContainer := container.NewHBox()
-	if p.client.IsConnected() {
+	if p.client.IsConnected() && p.client.HasCapability(protocol.CapabilityPluginConfig) {
 		configBtn := widget.NewButtonWithIcon("配置", fyneTheme.SettingsIcon(), func() {
 			p.showInlinePluginConfig(plugin)
 		})
@@ -452,14 +406,13 @@ func (p *PluginPage) updateEmptyState() {
 }
 func (p *PluginPage) handleInlinePluginConfigResponse(plugin data.Plugin, payload interface{}) {
 	p.logger.Debug("Plugin config response for %s: %+v", plugin.Name, payload)
-	payloadMap, ok := payload.(map[string]interface{})
-	if !ok {
-		p.logger.Error("Invalid plugin config response format: %T", payload)
-		dialog.ShowError(fmt.Errorf("插件配置响应格式无效"), fyne.CurrentApp().Driver().AllWindows()[0])
+	config, err := protocol.As[*protocol.PluginConfig](protocol.DecodeResponse("", "get_plugin_config", payload))
+	if err != nil {
+		p.logger.Error("Invalid plugin config response: %v", err)
+		dialog.ShowError(fmt.Errorf("插件配置响应格式无效: %v", err), fyne.CurrentApp().Driver().AllWindows()[0])
 		return
 	}
-	if errorMsg, hasError := payloadMap["error"]; hasError && errorMsg != nil && errorMsg != "<nil>" && toString(errorMsg) != "" {
-		errorStr := toString(errorMsg)
+	if errorStr := config.Error; errorStr != "" {
 		p.logger.Debug("Plugin %s config error: %s", plugin.Name, errorStr)
 		var userMessage string
 		switch errorStr {
@@ -473,40 +426,23 @@ func (p *PluginPage) handleInlinePluginConfigResponse(plugin data.Plugin, payloa
 		dialog.ShowInformation("插件配置", userMessage, fyne.CurrentApp().Driver().AllWindows()[0])
 		return
 	}
-	var schema map[string]interface{}
-	var data string
-	var schemaExists bool
-	if dataField, hasDataField := payloadMap["data"].(map[string]interface{}); hasDataField {
-		p.logger.Debug("Plugin %s found nested data field", plugin.Name)
-		schema, schemaExists = dataField["schema"].(map[string]interface{})
-		if dataStr, ok := dataField["data"].(string); ok {
-			data = dataStr
-		}
-	} else {
-		schema, schemaExists = payloadMap["schema"].(map[string]interface{})
-		if dataStr, ok := payloadMap["data"].(string); ok {
-			data = dataStr
-		}
-	}
-	if !schemaExists || schema == nil || len(schema) == 0 {
+	if len(config.Schema) == 0 {
 		p.logger.Debug("Plugin %s has no schema or empty schema", plugin.Name)
 		dialog.ShowInformation("插件配置", "此插件没有可配置的选项", fyne.CurrentApp().Driver().AllWindows()[0])
 		return
 	}
-	properties, hasProperties := schema["properties"].(map[string]interface{})
-	if !hasProperties || len(properties) == 0 {
+	properties := config.Properties()
+	if len(properties) == 0 {
 		p.logger.Debug("Plugin %s has no properties in schema", plugin.Name)
 		dialog.ShowInformation("插件配置", "此插件没有可配置的选项", fyne.CurrentApp().Driver().AllWindows()[0])
 		return
 	}
 	moduleName := plugin.Name
-	if m, ok := payloadMap["module"]; ok {
-		moduleName = toString(m)
-	} else if m2, ok := payloadMap["module_name"]; ok {
-		moduleName = toString(m2)
+	if config.Module != "" {
+		moduleName = config.Module
 	}
 	p.logger.Debug("Creating inline config view for plugin %s with %d properties", plugin.Name, len(properties))
-	p.createInlineConfigView(plugin, schema, data, moduleName)
+	p.createInlineConfigView(plugin, config.Schema, config.Data, moduleName)
 }
 func (p *PluginPage) createInlineConfigView(plugin data.Plugin, schema map[string]interface{}, configData string, moduleName string) {
 	p.currentPlugin = plugin
@@ -604,7 +540,11 @@ func (p *PluginPage) saveInlinePluginConfig(
 		"data":        data,
 	}
 	if err := p.client.SendRequestWithCallback("save_env", envParams, &network.RequestCallback{
-		Success: func(_ interface{}) {
+		Success: func(payload interface{}) {
+			if _, err := protocol.DecodeResponse("", "save_env", payload); err != nil {
+				dialog.ShowError(fmt.Errorf("保存失败: %v", err), fyne.CurrentApp().Driver().AllWindows()[0])
+				return
+			}
 			dialog.ShowInformation("保存成功", fmt.Sprintf("插件 '%s' 的配置已保存", plugin.Name), fyne.CurrentApp().Driver().AllWindows()[0])
 			p.hideConfigView()  
 		},
@@ -934,6 +874,10 @@ func (p *PluginPage) showPluginUpdateDialog(plugin data.Plugin, pluginDisplayNam
 		p.performPluginUpdate(plugin, pluginDisplayName, latestVersion)
 	})
 	updateBtn.Importance = widget.HighImportance
+	if !p.client.HasCapability(protocol.CapabilityPluginUpdate) {
+		updateBtn.SetText("服务端不支持更新")
+		updateBtn.Disable()
+	}
 	laterBtn := widget.NewButton("稍后提醒", func() {
 		updateDialog.Hide()  
 	})
@@ -967,6 +911,10 @@ func (p *PluginPage) startPluginUpdate(plugin data.Plugin, pluginDisplayName, la
 	callback := &network.RequestCallback{
 		Success: func(payload interface{}) {
 			progressDialog.Hide()
+			if _, err := protocol.DecodeResponse("", "update_plugin", payload); err != nil {
+				dialog.ShowError(fmt.Errorf("插件 %s 更新失败:\n%v", pluginDisplayName, err), fyne.CurrentApp().Driver().AllWindows()[0])
+				return
+			}
 			dialog.ShowInformation("更新成功",
 				fmt.Sprintf("插件 %s 已成功更新到 %s\n重启NoneBot以应用更新", pluginDisplayName, latestVersion),
 				fyne.CurrentApp().Driver().AllWindows()[0])
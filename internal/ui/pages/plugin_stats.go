@@ -0,0 +1,285 @@
+package pages
+import (
+	"fmt"
+	"lazytea-mobile/internal/data"
+	"lazytea-mobile/internal/network"
+	"lazytea-mobile/internal/utils"
+	"sync/atomic"
+	"time"
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	fyneTheme "fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+const (
+	allScopeLabel        = "全部"
+	pluginStatsBreakdown = 50
+	slowestMatcherLimit  = 10
+)
+var statsWindows = []struct {
+	Label  string
+	Window time.Duration
+}{
+	{"最近 1 小时", time.Hour},
+	{"最近 24 小时", 24 * time.Hour},
+	{"最近 7 天", 7 * 24 * time.Hour},
+	{"最近 30 天", 30 * 24 * time.Hour},
+	{"全部时间", 0},
+}
+type PluginStatsPage struct {
+	*PageBase
+	window        time.Duration
+	filter        data.PluginCallFilter
+	windowSelect  *widget.Select
+	serverSelect  *widget.Select
+	botSelect     *widget.Select
+	groupSelect   *widget.Select
+	userSelect    *widget.Select
+	summaryLabel  *widget.Label
+	pluginList    *fyne.Container
+	matcherList   *fyne.Container
+	generation    atomic.Uint64
+}
+type scopeOptions struct {
+	selector *widget.Select
+	value    string
+	options  []string
+}
+func NewPluginStatsPage(clients *network.Manager, storage *data.Storage, logger *utils.Logger) *PluginStatsPage {
+	page := &PluginStatsPage{
+		PageBase: NewManagedPageBase(clients, storage, logger),
+		window:   statsWindows[1].Window,
+	}
+	page.setupUI()
+	return page
+}
+func (p *PluginStatsPage) setupUI() {
+	titleLabel := widget.NewLabelWithStyle("插件调用分析", fyne.TextAlignCenter, fyne.TextStyle{Bold: true})
+	windowLabels := make([]string, 0, len(statsWindows))
+	for _, w := range statsWindows {
+		windowLabels = append(windowLabels, w.Label)
+	}
+	p.windowSelect = widget.NewSelect(windowLabels, func(selected string) {
+		for _, w := range statsWindows {
+			if w.Label == selected {
+				p.window = w.Window
+			}
+		}
+		p.refreshData()
+	})
+	p.serverSelect = widget.NewSelect([]string{allScopeLabel}, func(selected string) {
+		p.selectScope(&p.filter.Server, selected, &p.filter.Bot, &p.filter.GroupID, &p.filter.UserID)
+	})
+	p.botSelect = widget.NewSelect([]string{allScopeLabel}, func(selected string) {
+		p.selectScope(&p.filter.Bot, selected, &p.filter.GroupID, &p.filter.UserID)
+	})
+	p.groupSelect = widget.NewSelect([]string{allScopeLabel}, func(selected string) {
+		p.selectScope(&p.filter.GroupID, selected, &p.filter.UserID)
+	})
+	p.userSelect = widget.NewSelect([]string{allScopeLabel}, func(selected string) {
+		p.selectScope(&p.filter.UserID, selected)
+	})
+	refreshBtn := widget.NewButtonWithIcon("", fyneTheme.ViewRefreshIcon(), func() {
+		p.refreshData()
+	})
+	scopeForm := widget.NewForm(
+		widget.NewFormItem("时间", container.NewBorder(nil, nil, nil, refreshBtn, p.windowSelect)),
+		widget.NewFormItem("服务器", p.serverSelect),
+		widget.NewFormItem("Bot", p.botSelect),
+		widget.NewFormItem("群组", p.groupSelect),
+		widget.NewFormItem("用户", p.userSelect),
+	)
+	p.summaryLabel = widget.NewLabel("正在加载...")
+	p.summaryLabel.Alignment = fyne.TextAlignCenter
+	p.pluginList = container.NewVBox()
+	p.matcherList = container.NewVBox()
+	content := container.NewVBox(
+		titleLabel,
+		widget.NewSeparator(),
+		scopeForm,
+		p.summaryLabel,
+		widget.NewCard("插件", "调用次数、延迟与错误率", p.pluginList),
+		widget.NewCard("最慢的匹配器", "按 P95 延迟排序", p.matcherList),
+	)
+	p.windowSelect.Selected = statsWindows[1].Label
+	p.serverSelect.Selected = allScopeLabel
+	p.botSelect.Selected = allScopeLabel
+	p.groupSelect.Selected = allScopeLabel
+	p.userSelect.Selected = allScopeLabel
+	p.SetContent(container.NewScroll(content))
+}
+func (p *PluginStatsPage) OnEnter() {
+	p.refreshData()
+}
+func (p *PluginStatsPage) selectScope(target *string, selected string, narrower ...*string) {
+	value := selected
+	if value == allScopeLabel {
+		value = ""
+	}
+	if *target == value {
+		return
+	}
+	*target = value
+	for _, field := range narrower {
+		*field = ""
+	}
+	p.refreshData()
+}
+func (p *PluginStatsPage) currentFilter() data.PluginCallFilter {
+	filter := p.filter
+	if p.window > 0 {
+		filter.Since = time.Now().Add(-p.window)
+	}
+	return filter
+}
+// refreshData runs the queries in the background. Each refresh takes a new
+// generation and a result is only shown while its generation is the latest,
+// so a slow query cannot overwrite the view of a newer filter.
+func (p *PluginStatsPage) refreshData() {
+	if p.storage == nil {
+		return
+	}
+	generation := p.generation.Add(1)
+	filter := p.currentFilter()
+	p.summaryLabel.SetText("正在统计...")
+	go func() {
+		stats, err := p.storage.PluginCallStats(filter)
+		if err != nil {
+			p.logger.Error("Failed to load plugin call stats: %v", err)
+			p.showFailure(generation)
+			return
+		}
+		matchers, err := p.storage.SlowestMatchers(filter, slowestMatcherLimit)
+		if err != nil {
+			p.logger.Error("Failed to load slowest matchers: %v", err)
+			p.showFailure(generation)
+			return
+		}
+		scopes := p.loadScopeOptions(filter)
+		if p.generation.Load() != generation {
+			return
+		}
+		p.applyScopeOptions(scopes)
+		p.renderStats(stats, matchers)
+	}()
+}
+func (p *PluginStatsPage) showFailure(generation uint64) {
+	if p.generation.Load() == generation {
+		p.summaryLabel.SetText("统计失败")
+	}
+}
+func (p *PluginStatsPage) loadScopeOptions(filter data.PluginCallFilter) []scopeOptions {
+	levels := []struct {
+		selector  *widget.Select
+		dimension data.PluginCallDimension
+		value     string
+		scope     data.PluginCallFilter
+	}{
+		{p.serverSelect, data.PluginCallByServer, filter.Server, data.PluginCallFilter{Since: filter.Since}},
+		{p.botSelect, data.PluginCallByBot, filter.Bot, data.PluginCallFilter{Since: filter.Since, Server: filter.Server}},
+		{p.groupSelect, data.PluginCallByGroup, filter.GroupID, data.PluginCallFilter{Since: filter.Since, Server: filter.Server, Bot: filter.Bot}},
+		{p.userSelect, data.PluginCallByUser, filter.UserID, data.PluginCallFilter{Since: filter.Since, Server: filter.Server, Bot: filter.Bot, GroupID: filter.GroupID}},
+	}
+	var scopes []scopeOptions
+	for _, level := range levels {
+		buckets, err := p.storage.PluginCallBreakdown(level.scope, level.dimension, pluginStatsBreakdown)
+		if err != nil {
+			p.logger.Warn("Failed to load %s breakdown: %v", level.dimension, err)
+			continue
+		}
+		options := []string{allScopeLabel}
+		for _, bucket := range buckets {
+			options = append(options, bucket.Value)
+		}
+		if level.value != "" && !containsString(options, level.value) {
+			options = append(options, level.value)
+		}
+		scopes = append(scopes, scopeOptions{selector: level.selector, value: level.value, options: options})
+	}
+	return scopes
+}
+// applyScopeOptions sets Selected directly rather than through SetSelected,
+// which would call back into selectScope and change the filter again.
+func (p *PluginStatsPage) applyScopeOptions(scopes []scopeOptions) {
+	for _, scope := range scopes {
+		scope.selector.Options = scope.options
+		scope.selector.Selected = allScopeLabel
+		if scope.value != "" {
+			scope.selector.Selected = scope.value
+		}
+		scope.selector.Refresh()
+	}
+}
+func (p *PluginStatsPage) renderStats(stats, matchers []data.PluginCallStats) {
+	var calls, errors int
+	for _, s := range stats {
+		calls += s.Calls
+		errors += s.Errors
+	}
+	if calls == 0 {
+		p.summaryLabel.SetText("所选范围内暂无插件调用记录")
+	} else {
+		p.summaryLabel.SetText(fmt.Sprintf("共 %d 次调用，%d 个插件，错误率 %.1f%%",
+			calls, len(stats), float64(errors)*100/float64(calls)))
+	}
+	p.pluginList.Objects = nil
+	for _, s := range stats {
+		p.pluginList.Add(pluginStatsRow(s.Plugin, s))
+	}
+	if len(stats) == 0 {
+		p.pluginList.Add(widget.NewLabel("暂无数据"))
+	}
+	p.pluginList.Refresh()
+	p.matcherList.Objects = nil
+	for _, s := range matchers {
+		p.matcherList.Add(pluginStatsRow(fmt.Sprintf("%s · %s", s.Plugin, truncateMatcher(s.MatcherHash)), s))
+	}
+	if len(matchers) == 0 {
+		p.matcherList.Add(widget.NewLabel("暂无数据"))
+	}
+	p.matcherList.Refresh()
+}
+func pluginStatsRow(title string, s data.PluginCallStats) fyne.CanvasObject {
+	name := widget.NewLabelWithStyle(title, fyne.TextAlignLeading, fyne.TextStyle{Bold: true})
+	name.Truncation = fyne.TextTruncateEllipsis
+	detail := widget.NewLabel(fmt.Sprintf("%d 次 · P50 %s · P95 %s · 最慢 %s",
+		s.Calls, formatCallCost(s.P50), formatCallCost(s.P95), formatCallCost(s.Max)))
+	detail.Importance = widget.LowImportance
+	errorLabel := widget.NewLabel(fmt.Sprintf("错误 %.1f%%", s.ErrorRate()*100))
+	switch {
+	case s.Errors == 0:
+		errorLabel.Importance = widget.SuccessImportance
+	case s.ErrorRate() < 0.05:
+		errorLabel.Importance = widget.WarningImportance
+	default:
+		errorLabel.Importance = widget.DangerImportance
+	}
+	return container.NewVBox(
+		container.NewBorder(nil, nil, nil, errorLabel, name),
+		detail,
+		widget.NewSeparator(),
+	)
+}
+func formatCallCost(d time.Duration) string {
+	if d < time.Second {
+		return fmt.Sprintf("%d ms", d.Milliseconds())
+	}
+	return fmt.Sprintf("%.2f s", d.Seconds())
+}
+func truncateMatcher(hash string) string {
+	if hash == "" {
+		return "未知匹配器"
+	}
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return hash
+}
+func containsString(values []string, target string) bool {
+	for _, value := range values {
+		if value == target {
+			return true
+		}
+	}
+	return false
+}
@@ -1,10 +1,14 @@
 package pages
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"lazytea-mobile/internal/data"
 	"lazytea-mobile/internal/network"
+	"lazytea-mobile/internal/protocol"
 	"lazytea-mobile/internal/ui/components/roster"
+	"time"
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/dialog"
@@ -24,6 +28,7 @@ type RosterPage struct {
 	contentContainer *fyne.Container
 	currentNode      *roster.TreeNode
 	tabs             *container.AppTabs  
+	statusLabel      *widget.Label
 }
 func NewRosterPage(initialData map[string]interface{}, onSave func(data map[string]interface{}), mainWindow fyne.Window, pageBase *PageBase) *RosterPage {
 	var cfg *data.FullConfigModel
@@ -65,24 +70,16 @@ func NewRosterPage(initialData map[string]interface{}, onSave func(data map[stri
 		targetBotID: "",  
 	}
 	p.setupUI()
+	p.setupEventHandlers()
 	if len(initialData) == 0 && pageBase != nil && pageBase.client != nil {
 		cb := &network.RequestCallback{
 			Success: func(payload interface{}) {
-				var m map[string]interface{}
-				if pm, ok := payload.(map[string]interface{}); ok {
-					if d, has := pm["data"]; has {
-						if mm, ok := d.(map[string]interface{}); ok {
-							m = mm
-						} else {
-							m = pm
-						}
-					} else {
-						m = pm
-					}
-				}
-				if m == nil {
+				matchers, err := protocol.As[*protocol.Matchers](protocol.DecodeResponse("", "get_matchers", payload))
+				if err != nil {
+					dialog.ShowError(fmt.Errorf("解析服务端配置失败: %v", err), p.mainWindow)
 					return
 				}
+				m := matchers.Roster
 				if parsed, err := data.ParseConfigFromMap(m); err == nil {
 					p.data = m
 					p.config = parsed
@@ -142,24 +139,16 @@ func NewRosterPageForBot(initialData map[string]interface{}, onSave func(data ma
 		targetBotID: botID,  
 	}
 	p.setupUI()
+	p.setupEventHandlers()
 	if len(initialData) == 0 && pageBase != nil && pageBase.client != nil {
 		cb := &network.RequestCallback{
 			Success: func(payload interface{}) {
-				var m map[string]interface{}
-				if pm, ok := payload.(map[string]interface{}); ok {
-					if d, has := pm["data"]; has {
-						if mm, ok := d.(map[string]interface{}); ok {
-							m = mm
-						} else {
-							m = pm
-						}
-					} else {
-						m = pm
-					}
-				}
-				if m == nil {
+				matchers, err := protocol.As[*protocol.Matchers](protocol.DecodeResponse("", "get_matchers", payload))
+				if err != nil {
+					dialog.ShowError(fmt.Errorf("解析服务端配置失败: %v", err), p.mainWindow)
 					return
 				}
+				m := matchers.Roster
 				if parsed, err := data.ParseConfigFromMap(m); err == nil {
 					p.data = m
 					p.config = parsed
@@ -367,11 +356,28 @@ func (p *RosterPage) createBottomBar() *fyne.Container {
 		p.SaveConfig()
 	})
 	saveBtn.Importance = widget.HighImportance
+	p.statusLabel = widget.NewLabel("")
+	p.statusLabel.Importance = widget.WarningImportance
 	return container.NewHBox(
+		p.statusLabel,
 		layout.NewSpacer(),
 		saveBtn,
 	)
 }
+func (p *RosterPage) setupEventHandlers() {
+	if p.PageBase == nil || p.client == nil {
+		return
+	}
+	p.updateConnectionHint(p.client.IsConnected())
+	p.Track(p.client.OnConnectionChanged(p.updateConnectionHint))
+}
+func (p *RosterPage) updateConnectionHint(connected bool) {
+	if connected {
+		p.statusLabel.SetText("")
+		return
+	}
+	p.statusLabel.SetText("离线，保存将在重连后同步")
+}
 func (p *RosterPage) UpdateConfig(newData map[string]interface{}) {
 	config, err := data.ParseConfigFromMap(newData)
 	if err != nil {
@@ -434,27 +440,48 @@ func (p *RosterPage) SaveConfig() {
 	params := map[string]interface{}{
 		"new_roster": string(jsonBytes),
 	}
-	callback := &network.RequestCallback{
-		Success: func(payload interface{}) {
+	mutation := network.Mutation{
+		Method:  "sync_matchers",
+		Params:  params,
+		Summary: "同步权限名单",
+	}
+	if p.targetBotID != "" {
+		mutation.Summary = fmt.Sprintf("同步 Bot %s 的权限名单", p.targetBotID)
+	}
+	if len(p.data) > 0 {
+		mutation.Guard = &network.Guard{
+			Method:      "get_matchers",
+			Params:      map[string]interface{}{},
+			Fingerprint: network.StateFingerprint(p.data),
+		}
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		response, err := p.client.Submit(ctx, mutation)
+		if errors.Is(err, network.ErrQueued) {
 			if p.PageBase != nil && p.PageBase.logger != nil {
-				p.PageBase.logger.Info("SaveConfig: 服务端同步成功")
+				p.PageBase.logger.Info("SaveConfig: 已加入离线队列: %v", err)
 			}
-			dialog.ShowInformation("保存成功", "配置已成功同步！", p.mainWindow)
-		},
-		Error: func(e error) {
+			dialog.ShowInformation("已加入队列", queuedNotice(err, "配置"), p.mainWindow)
+			return
+		}
+		if err == nil {
+			_, err = protocol.DecodeResponse("", "sync_matchers", response)
+		}
+		if err != nil {
 			if p.PageBase != nil && p.PageBase.logger != nil {
-				p.PageBase.logger.Error("SaveConfig: 服务端同步失败: %v", e)
+				p.PageBase.logger.Error("SaveConfig: 服务端同步失败: %v", err)
 			}
-			dialog.ShowError(fmt.Errorf("同步失败: %v", e), p.mainWindow)
-		},
-	}
-	if err := p.client.SendRequestWithCallback("sync_matchers", params, callback); err != nil {
+			dialog.ShowError(fmt.Errorf("同步失败: %v", err), p.mainWindow)
+			return
+		}
+		p.data = dataMap
 		if p.PageBase != nil && p.PageBase.logger != nil {
-			p.PageBase.logger.Error("SaveConfig: 请求发送失败: %v", err)
+			p.PageBase.logger.Info("SaveConfig: 服务端同步成功")
 		}
-		dialog.ShowError(fmt.Errorf("请求发送失败: %v", err), p.mainWindow)
-		return
-	}
+		dialog.ShowInformation("保存成功", "配置已成功同步！", p.mainWindow)
+	}()
 }
 func getMapKeysDebug(m map[string]interface{}) []string {
 	keys := make([]string, 0, len(m))
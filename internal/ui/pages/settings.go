@@ -1,18 +1,22 @@
 package pages
 
 import (
+	"context"
 	"fmt"
 	"lazytea-mobile/internal/config"
 	"lazytea-mobile/internal/data"
+	"lazytea-mobile/internal/mockserver"
 	"lazytea-mobile/internal/network"
 	"lazytea-mobile/internal/utils"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/storage"
 	fyneTheme "fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
 )
@@ -21,6 +25,18 @@ type SettingsPage struct {
 	*PageBase
 	config            *config.Config
 	window            fyne.Window
+	profileSelect     *widget.Select
+	profileNameEntry  *widget.Entry
+	profileColor      *widget.Select
+	parallelCheck     *widget.Check
+	authModeSelect    *widget.Select
+	tlsCheck          *widget.Check
+	tlsOptions        *fyne.Container
+	caBundleEntry     *widget.Entry
+	pinEntry          *widget.Entry
+	selfSignedCheck   *widget.Check
+	compressionCheck  *widget.Check
+	encodingSelect    *widget.Select
 	hostEntry         *widget.Entry
 	portEntry         *widget.Entry
 	tokenEntry        *widget.Entry
@@ -31,11 +47,104 @@ type SettingsPage struct {
 	connectBtn        *widget.Button
 	saveBtn           *widget.Button
 	resetBtn          *widget.Button
+	profiles          []data.ConnectionProfile
+	demoServer        *mockserver.Server
+	recordingPath     string
+	pruner            *data.Pruner
+	dbSizeLabel       *widget.Label
+	maxAgeEntry       *widget.Entry
+	maxMessagesEntry  *widget.Entry
+	maxSizeEntry      *widget.Entry
+	retentionRules    []config.RetentionRuleConfig
 }
 
-func NewSettingsPage(client *network.Client, storage *data.Storage, logger *utils.Logger, window fyne.Window, cfg *config.Config) *SettingsPage {
+var profileColors = []struct {
+	Label string
+	Value string
+}{
+	{"🔵 蓝色", "#38A5FD"},
+	{"🟢 绿色", "#4CAF50"},
+	{"🟠 橙色", "#FF9800"},
+	{"🔴 红色", "#F44336"},
+	{"🟣 紫色", "#9C27B0"},
+	{"⚪ 灰色", "#9E9E9E"},
+}
+
+var authModes = []struct {
+	Label string
+	Value network.AuthMode
+}{
+	{"自动协商", network.AuthModeAuto},
+	{"Authorization 请求头", network.AuthModeHeader},
+	{"首帧认证消息", network.AuthModeFrame},
+	{"URL 参数 (旧版)", network.AuthModeQuery},
+}
+
+var encodings = []struct {
+	Label string
+	Value network.Encoding
+}{
+	{"JSON", network.EncodingJSON},
+	{"MessagePack (二进制)", network.EncodingMsgPack},
+}
+
+func encodingLabel(value string) string {
+	for _, e := range encodings {
+		if string(e.Value) == value {
+			return e.Label
+		}
+	}
+	return encodings[0].Label
+}
+
+func encodingValue(label string) string {
+	for _, e := range encodings {
+		if e.Label == label {
+			return string(e.Value)
+		}
+	}
+	return string(network.EncodingJSON)
+}
+
+func authModeLabel(value string) string {
+	for _, m := range authModes {
+		if string(m.Value) == value {
+			return m.Label
+		}
+	}
+	return authModes[0].Label
+}
+
+func authModeValue(label string) string {
+	for _, m := range authModes {
+		if m.Label == label {
+			return string(m.Value)
+		}
+	}
+	return string(network.AuthModeAuto)
+}
+
+func profileColorLabel(value string) string {
+	for _, c := range profileColors {
+		if c.Value == value {
+			return c.Label
+		}
+	}
+	return ""
+}
+
+func profileColorValue(label string) string {
+	for _, c := range profileColors {
+		if c.Label == label {
+			return c.Value
+		}
+	}
+	return ""
+}
+
+func NewSettingsPage(clients *network.Manager, storage *data.Storage, logger *utils.Logger, window fyne.Window, cfg *config.Config) *SettingsPage {
 	page := &SettingsPage{
-		PageBase: NewPageBase(client, storage, logger),
+		PageBase: NewManagedPageBase(clients, storage, logger),
 		config:   cfg,
 		window:   window,
 	}
@@ -50,6 +159,7 @@ func (p *SettingsPage) setupUI() {
 	p.statusLabel = widget.NewLabel("设置已加载")
 	p.statusLabel.Alignment = fyne.TextAlignCenter
 	p.statusLabel.Importance = widget.SuccessImportance
+	profileCard := p.createProfileCard()
 	connectionCard := p.createConnectionCard()
 	databaseCard := p.createDatabaseCard()
 	aboutCard := p.createAboutCard()
@@ -59,6 +169,7 @@ func (p *SettingsPage) setupUI() {
 		widget.NewSeparator(),
 		p.statusLabel,
 		widget.NewSeparator(),
+		profileCard,
 		connectionCard,
 		databaseCard,
 		aboutCard,
@@ -69,6 +180,45 @@ func (p *SettingsPage) setupUI() {
 	scroll.SetMinSize(fyne.NewSize(350, 550))
 	p.SetContent(scroll)
 }
+func (p *SettingsPage) createProfileCard() *widget.Card {
+	p.profileSelect = widget.NewSelect(nil, func(name string) {
+		p.applyProfile(name)
+	})
+	p.profileSelect.PlaceHolder = "选择连接配置"
+	p.profileNameEntry = widget.NewEntry()
+	p.profileNameEntry.SetPlaceHolder(data.DefaultProfileName)
+	colorLabels := make([]string, 0, len(profileColors))
+	for _, c := range profileColors {
+		colorLabels = append(colorLabels, c.Label)
+	}
+	p.profileColor = widget.NewSelect(colorLabels, nil)
+	p.profileColor.PlaceHolder = "颜色标签"
+	p.parallelCheck = widget.NewCheck("启动时同时连接此服务器", nil)
+	parallelBtn := widget.NewButtonWithIcon("并行连接", fyneTheme.ComputerIcon(), func() {
+		p.connectParallel()
+	})
+	saveProfileBtn := widget.NewButtonWithIcon("保存配置", fyneTheme.DocumentSaveIcon(), func() {
+		p.saveProfile()
+	})
+	deleteProfileBtn := widget.NewButtonWithIcon("删除", fyneTheme.DeleteIcon(), func() {
+		p.confirmDeleteProfile()
+	})
+	deleteProfileBtn.Importance = widget.DangerImportance
+	content := container.NewVBox(
+		p.profileSelect,
+		container.NewGridWithColumns(2,
+			widget.NewLabel("配置名称:"),
+			p.profileNameEntry,
+		),
+		container.NewGridWithColumns(2,
+			widget.NewLabel("颜色标签:"),
+			p.profileColor,
+		),
+		p.parallelCheck,
+		container.NewGridWithColumns(3, saveProfileBtn, parallelBtn, deleteProfileBtn),
+	)
+	return widget.NewCard("连接配置", "在多个服务器之间切换", content)
+}
 func (p *SettingsPage) createConnectionCard() *widget.Card {
 	p.hostEntry = widget.NewEntry()
 	p.hostEntry.SetPlaceHolder("127.0.0.1")
@@ -76,12 +226,62 @@ func (p *SettingsPage) createConnectionCard() *widget.Card {
 	p.portEntry.SetPlaceHolder("8080")
 	p.tokenEntry = widget.NewPasswordEntry()
 	p.tokenEntry.SetPlaceHolder("请输入访问令牌")
+	authLabels := make([]string, 0, len(authModes))
+	for _, m := range authModes {
+		authLabels = append(authLabels, m.Label)
+	}
+	p.authModeSelect = widget.NewSelect(authLabels, nil)
+	p.authModeSelect.SetSelected(authModes[0].Label)
+	p.caBundleEntry = widget.NewMultiLineEntry()
+	p.caBundleEntry.SetPlaceHolder("-----BEGIN CERTIFICATE-----")
+	p.caBundleEntry.SetMinRowsVisible(3)
+	p.pinEntry = widget.NewEntry()
+	p.pinEntry.SetPlaceHolder("SHA-256 指纹，多个用逗号分隔")
+	p.selfSignedCheck = widget.NewCheck("允许自签名证书", nil)
+	p.tlsOptions = container.NewVBox(
+		widget.NewLabel("CA 证书 (PEM，可选):"),
+		p.caBundleEntry,
+		widget.NewLabel("证书固定 (可选):"),
+		p.pinEntry,
+		p.selfSignedCheck,
+	)
+	p.tlsOptions.Hide()
+	p.tlsCheck = widget.NewCheck("使用 TLS (wss://)", func(checked bool) {
+		if checked {
+			p.tlsOptions.Show()
+		} else {
+			p.tlsOptions.Hide()
+		}
+	})
+	p.compressionCheck = widget.NewCheck("启用压缩 (permessage-deflate)", nil)
+	encodingLabels := make([]string, 0, len(encodings))
+	for _, e := range encodings {
+		encodingLabels = append(encodingLabels, e.Label)
+	}
+	p.encodingSelect = widget.NewSelect(encodingLabels, nil)
+	p.encodingSelect.SetSelected(encodings[0].Label)
 	p.autoConnectCheck = widget.NewCheck("启动时自动连接", nil)
 	p.rememberAuthCheck = widget.NewCheck("记住认证信息", nil)
 	p.connectBtn = widget.NewButtonWithIcon("测试连接", fyneTheme.ComputerIcon(), func() {
 		p.testConnection()
 	})
 	p.connectBtn.Importance = widget.MediumImportance
+	statsBtn := widget.NewButtonWithIcon("请求统计", fyneTheme.InfoIcon(), func() {
+		p.showRequestStats()
+	})
+	outboxBtn := widget.NewButtonWithIcon("离线队列", fyneTheme.UploadIcon(), func() {
+		p.showOutbox()
+	})
+	demoBtn := widget.NewButtonWithIcon("演示模式", fyneTheme.MediaPlayIcon(), func() {
+		p.StartDemo()
+	})
+	recordBtn := widget.NewButtonWithIcon("录制会话", fyneTheme.MediaRecordIcon(), nil)
+	recordBtn.OnTapped = func() {
+		p.toggleRecording(recordBtn)
+	}
+	replayBtn := widget.NewButtonWithIcon("回放录制", fyneTheme.MediaReplayIcon(), func() {
+		p.showReplay()
+	})
 	content := container.NewVBox(
 		container.NewGridWithColumns(2,
 			widget.NewLabel("服务器地址:"),
@@ -95,11 +295,24 @@ func (p *SettingsPage) createConnectionCard() *widget.Card {
 			widget.NewLabel("访问令牌:"),
 			p.tokenEntry,
 		),
+		container.NewGridWithColumns(2,
+			widget.NewLabel("认证方式:"),
+			p.authModeSelect,
+		),
+		p.tlsCheck,
+		p.tlsOptions,
+		p.compressionCheck,
+		container.NewGridWithColumns(2,
+			widget.NewLabel("消息编码:"),
+			p.encodingSelect,
+		),
 		widget.NewSeparator(),
 		p.autoConnectCheck,
 		p.rememberAuthCheck,
 		widget.NewSeparator(),
-		p.connectBtn,
+		container.NewGridWithColumns(2, p.connectBtn, statsBtn),
+		container.NewGridWithColumns(2, outboxBtn, demoBtn),
+		container.NewGridWithColumns(2, recordBtn, replayBtn),
 	)
 	return widget.NewCard("连接设置", "", content)
 }
@@ -113,6 +326,27 @@ func (p *SettingsPage) createDatabaseCard() *widget.Card {
 	}
 	dbPathLabel := widget.NewLabel(dbPathText)
 	dbPathLabel.Importance = widget.MediumImportance
+	p.dbSizeLabel = widget.NewLabel("数据库大小: -")
+	p.maxAgeEntry = widget.NewEntry()
+	p.maxAgeEntry.SetPlaceHolder("不限制")
+	p.maxMessagesEntry = widget.NewEntry()
+	p.maxMessagesEntry.SetPlaceHolder("不限制")
+	p.maxSizeEntry = widget.NewEntry()
+	p.maxSizeEntry.SetPlaceHolder("不限制")
+	retentionForm := widget.NewForm(
+		widget.NewFormItem("保留天数", p.maxAgeEntry),
+		widget.NewFormItem("最多消息数", p.maxMessagesEntry),
+		widget.NewFormItem("大小上限 (MB)", p.maxSizeEntry),
+	)
+	rulesBtn := widget.NewButtonWithIcon("分组规则", fyneTheme.ListIcon(), func() {
+		p.showRetentionRules()
+	})
+	pruneBtn := widget.NewButtonWithIcon("立即清理", fyneTheme.ContentClearIcon(), func() {
+		p.pruneNow()
+	})
+	reindexBtn := widget.NewButtonWithIcon("重建搜索索引", fyneTheme.SearchIcon(), func() {
+		p.rebuildSearchIndex()
+	})
 	cleanBtn := widget.NewButtonWithIcon("清理数据", fyneTheme.DeleteIcon(), func() {
 		p.confirmCleanDatabase()
 	})
@@ -121,8 +355,14 @@ func (p *SettingsPage) createDatabaseCard() *widget.Card {
 		container.NewVBox(
 			widget.NewLabel("数据库路径:"),
 			dbPathLabel,
+			p.dbSizeLabel,
 		),
 		widget.NewSeparator(),
+		widget.NewLabel("消息保留策略:"),
+		retentionForm,
+		container.NewGridWithColumns(2, rulesBtn, pruneBtn),
+		widget.NewSeparator(),
+		reindexBtn,
 		cleanBtn,
 	)
 	return widget.NewCard("数据设置", "", content)
@@ -156,7 +396,7 @@ func (p *SettingsPage) createActionButtons() *fyne.Container {
 	return container.NewGridWithColumns(2, p.saveBtn, p.resetBtn)
 }
 func (p *SettingsPage) setupEventHandlers() {
-	p.client.OnConnectionChanged(func(connected bool) {
+	p.Track(p.client.OnConnectionChanged(func(connected bool) {
 		if connected {
 			p.connectBtn.SetText("连接成功")
 			p.connectBtn.SetIcon(fyneTheme.ConfirmIcon())
@@ -166,7 +406,7 @@ func (p *SettingsPage) setupEventHandlers() {
 			p.connectBtn.SetIcon(fyneTheme.ComputerIcon())
 			p.connectBtn.Importance = widget.MediumImportance
 		}
-	})
+	}))
 }
 func (p *SettingsPage) loadSettings() {
 	p.hostEntry.SetText(p.config.Network.Host)
@@ -174,24 +414,208 @@ func (p *SettingsPage) loadSettings() {
 	p.tokenEntry.SetText(p.config.Network.Token)
 	p.autoConnectCheck.SetChecked(p.config.Network.AutoConnect)
 	p.rememberAuthCheck.SetChecked(p.config.Network.RememberAuth)
+	p.profileNameEntry.SetText(p.config.Network.Profile)
+	p.reloadProfiles(p.config.Network.Profile)
+	p.loadRetention()
 	p.statusLabel.SetText("设置已加载")
 	p.statusLabel.Importance = widget.SuccessImportance
 }
-func (p *SettingsPage) saveSettings() {
-	if strings.TrimSpace(p.hostEntry.Text) == "" {
-		dialog.ShowError(fmt.Errorf("服务器地址不能为空"), p.window)
+func (p *SettingsPage) reloadProfiles(selected string) {
+	if p.storage == nil {
+		return
+	}
+	profiles, err := p.storage.ListConnectionProfiles()
+	if err != nil {
+		p.logger.Error("Failed to load connection profiles: %v", err)
+		return
+	}
+	p.profiles = profiles
+	names := make([]string, 0, len(profiles))
+	for _, profile := range profiles {
+		names = append(names, profile.Name)
+	}
+	p.profileSelect.Options = names
+	p.profileSelect.Refresh()
+	for _, name := range names {
+		if name == selected {
+			p.profileSelect.SetSelected(name)
+			return
+		}
+	}
+}
+func (p *SettingsPage) findProfile(name string) (data.ConnectionProfile, bool) {
+	for _, profile := range p.profiles {
+		if profile.Name == name {
+			return profile, true
+		}
+	}
+	return data.ConnectionProfile{}, false
+}
+func (p *SettingsPage) applyProfile(name string) {
+	profile, ok := p.findProfile(name)
+	if !ok {
 		return
 	}
-	port, err := strconv.Atoi(p.portEntry.Text)
+	p.profileNameEntry.SetText(profile.Name)
+	p.hostEntry.SetText(profile.Host)
+	p.portEntry.SetText(strconv.Itoa(profile.Port))
+	p.tokenEntry.SetText(profile.Token)
+	p.authModeSelect.SetSelected(authModeLabel(profile.AuthMode))
+	p.tlsCheck.SetChecked(profile.TLS)
+	p.caBundleEntry.SetText(profile.CABundle)
+	p.pinEntry.SetText(profile.PinnedSHA256)
+	p.selfSignedCheck.SetChecked(profile.AllowSelfSigned)
+	p.compressionCheck.SetChecked(profile.Compression)
+	p.encodingSelect.SetSelected(encodingLabel(profile.Encoding))
+	p.parallelCheck.SetChecked(profile.AutoConnect)
+	if label := profileColorLabel(profile.Color); label != "" {
+		p.profileColor.SetSelected(label)
+	} else {
+		p.profileColor.ClearSelected()
+	}
+	p.statusLabel.SetText(fmt.Sprintf("已选择配置: %s", profile.Name))
+	p.statusLabel.Importance = widget.MediumImportance
+}
+func (p *SettingsPage) profileFromForm() (data.ConnectionProfile, error) {
+	name := strings.TrimSpace(p.profileNameEntry.Text)
+	if name == "" {
+		name = data.DefaultProfileName
+	}
+	host := strings.TrimSpace(p.hostEntry.Text)
+	if host == "" {
+		return data.ConnectionProfile{}, fmt.Errorf("服务器地址不能为空")
+	}
+	port, err := strconv.Atoi(strings.TrimSpace(p.portEntry.Text))
 	if err != nil || port <= 0 || port > 65535 {
-		dialog.ShowError(fmt.Errorf("端口必须是1-65535之间的数字"), p.window)
+		return data.ConnectionProfile{}, fmt.Errorf("端口必须是1-65535之间的数字")
+	}
+	pins, err := network.ParsePins(p.pinEntry.Text)
+	if err != nil {
+		return data.ConnectionProfile{}, err
+	}
+	return data.ConnectionProfile{
+		Name:            name,
+		Host:            host,
+		Port:            port,
+		Token:           p.tokenEntry.Text,
+		AuthMode:        authModeValue(p.authModeSelect.Selected),
+		TLS:             p.tlsCheck.Checked,
+		CABundle:        strings.TrimSpace(p.caBundleEntry.Text),
+		PinnedSHA256:    strings.Join(pins, ","),
+		AllowSelfSigned: p.selfSignedCheck.Checked,
+		Compression:     p.compressionCheck.Checked,
+		Encoding:        encodingValue(p.encodingSelect.Selected),
+		Color:           profileColorValue(p.profileColor.Selected),
+		AutoConnect:     p.parallelCheck.Checked,
+	}, nil
+}
+func (p *SettingsPage) saveProfile() {
+	profile, err := p.profileFromForm()
+	if err != nil {
+		dialog.ShowError(err, p.window)
+		return
+	}
+	if err := p.storage.SaveConnectionProfile(profile); err != nil {
+		dialog.ShowError(fmt.Errorf("保存连接配置失败: %v", err), p.window)
+		return
+	}
+	p.reloadProfiles(profile.Name)
+	p.statusLabel.SetText(fmt.Sprintf("连接配置 %s 已保存", profile.Name))
+	p.statusLabel.Importance = widget.SuccessImportance
+}
+func (p *SettingsPage) connectParallel() {
+	profile, err := p.profileFromForm()
+	if err != nil {
+		dialog.ShowError(err, p.window)
+		return
+	}
+	if p.clients.Primary().ServerName() == profile.Name {
+		dialog.ShowInformation("并行连接", fmt.Sprintf("%s 已作为主连接使用", profile.Name), p.window)
 		return
 	}
-	p.config.Network.Host = strings.TrimSpace(p.hostEntry.Text)
-	p.config.Network.Port = port
-	p.config.Network.Token = p.tokenEntry.Text
+	client := p.clients.Client(profile.Name)
+	if !p.clients.Has(profile.Name) {
+		client, err = p.clients.Add(profile.Name)
+		if err != nil {
+			dialog.ShowError(err, p.window)
+			return
+		}
+	}
+	if client.IsConnected() {
+		dialog.ShowInformation("并行连接", fmt.Sprintf("%s 已连接", profile.Name), p.window)
+		return
+	}
+	p.statusLabel.SetText(fmt.Sprintf("正在连接 %s...", profile.Name))
+	p.statusLabel.Importance = widget.MediumImportance
+	go func() {
+		if err := client.ConnectWithOptions(network.ProfileConnectOptions(profile)); err != nil {
+			p.clients.Remove(profile.Name)
+			p.statusLabel.SetText(fmt.Sprintf("%s 连接失败", profile.Name))
+			p.statusLabel.Importance = widget.DangerImportance
+			dialog.ShowError(fmt.Errorf("连接 %s 失败: %v", profile.Name, err), p.window)
+			return
+		}
+		if err := network.RememberLegacy(p.storage, profile, client); err != nil {
+			p.logger.Warn("Failed to save legacy protocol flag: %v", err)
+		}
+		online, total := p.clients.ConnectionCounts()
+		p.statusLabel.SetText(fmt.Sprintf("%s 已连接 (%d/%d)", profile.Name, online, total))
+		p.statusLabel.Importance = widget.SuccessImportance
+	}()
+}
+func (p *SettingsPage) confirmDeleteProfile() {
+	name := p.profileSelect.Selected
+	if name == "" {
+		return
+	}
+	dialog.ShowConfirm(
+		"删除连接配置",
+		fmt.Sprintf("确定要删除连接配置 %s 吗？", name),
+		func(confirmed bool) {
+			if !confirmed {
+				return
+			}
+			if err := p.storage.DeleteConnectionProfile(name); err != nil {
+				dialog.ShowError(fmt.Errorf("删除连接配置失败: %v", err), p.window)
+				return
+			}
+			p.clients.Remove(name)
+			p.profileSelect.ClearSelected()
+			p.reloadProfiles("")
+			p.statusLabel.SetText(fmt.Sprintf("连接配置 %s 已删除", name))
+			p.statusLabel.Importance = widget.MediumImportance
+		},
+		p.window,
+	)
+}
+func (p *SettingsPage) saveSettings() {
+	profile, err := p.profileFromForm()
+	if err != nil {
+		dialog.ShowError(err, p.window)
+		return
+	}
+	if p.storage != nil {
+		if err := p.storage.SaveConnectionProfile(profile); err != nil {
+			dialog.ShowError(fmt.Errorf("保存连接配置失败: %v", err), p.window)
+			return
+		}
+		p.reloadProfiles(profile.Name)
+	}
+	p.config.Network.Profile = profile.Name
+	p.config.Network.Host = profile.Host
+	p.config.Network.Port = profile.Port
+	p.config.Network.Token = profile.Token
 	p.config.Network.AutoConnect = p.autoConnectCheck.Checked
 	p.config.Network.RememberAuth = p.rememberAuthCheck.Checked
+	retention, err := p.retentionFromForm()
+	if err != nil {
+		dialog.ShowError(err, p.window)
+		return
+	}
+	p.config.Retention = retention
+	if p.pruner != nil {
+		p.pruner.SetPolicy(retention.Policy())
+	}
 	if err := config.Save(p.config); err != nil {
 		dialog.ShowError(fmt.Errorf("保存设置失败: %v", err), p.window)
 		return
@@ -201,17 +625,16 @@ func (p *SettingsPage) saveSettings() {
 	dialog.ShowInformation("保存成功", "设置已保存，部分设置需要重启应用后生效", p.window)
 }
 func (p *SettingsPage) testConnection() {
-	host := strings.TrimSpace(p.hostEntry.Text)
-	portStr := strings.TrimSpace(p.portEntry.Text)
-	token := p.tokenEntry.Text
-	if host == "" {
-		dialog.ShowError(fmt.Errorf("请输入服务器地址"), p.window)
+	profile, err := p.profileFromForm()
+	if err != nil {
+		dialog.ShowError(err, p.window)
 		return
 	}
-	port, err := strconv.Atoi(portStr)
-	if err != nil || port <= 0 || port > 65535 {
-		dialog.ShowError(fmt.Errorf("端口必须是1-65535之间的数字"), p.window)
-		return
+	if name := p.profileSelect.Selected; name != "" {
+		if err := p.clients.SetPrimaryServer(name); err != nil {
+			dialog.ShowError(err, p.window)
+			return
+		}
 	}
 	p.connectBtn.SetText("连接中...")
 	p.connectBtn.Disable()
@@ -219,13 +642,21 @@ func (p *SettingsPage) testConnection() {
 		if p.client.IsConnected() {
 			p.client.Disconnect()
 		}
-		if err := p.client.Connect(host, port, token); err != nil {
+		if err := p.client.ConnectWithOptions(network.ProfileConnectOptions(profile)); err != nil {
 			p.connectBtn.SetText("连接失败")
 			p.connectBtn.SetIcon(fyneTheme.CancelIcon())
 			p.connectBtn.Importance = widget.DangerImportance
 			p.connectBtn.Enable()
 			dialog.ShowError(fmt.Errorf("连接失败: %v", err), p.window)
 		} else {
+			if name := p.profileSelect.Selected; name != "" && p.storage != nil {
+				if err := p.storage.TouchConnectionProfile(name); err != nil {
+					p.logger.Warn("Failed to update profile usage: %v", err)
+				}
+			}
+			if err := network.RememberLegacy(p.storage, profile, p.client); err != nil {
+				p.logger.Warn("Failed to save legacy protocol flag: %v", err)
+			}
 			p.connectBtn.SetText("连接成功")
 			p.connectBtn.SetIcon(fyneTheme.ConfirmIcon())
 			p.connectBtn.Importance = widget.SuccessImportance
@@ -233,6 +664,268 @@ func (p *SettingsPage) testConnection() {
 		}
 	}()
 }
+func (p *SettingsPage) StartDemo() {
+	if p.demoServer == nil {
+		server, err := mockserver.StartDemo(p.logger)
+		if err != nil {
+			dialog.ShowError(fmt.Errorf("启动演示服务端失败: %v", err), p.window)
+			return
+		}
+		p.demoServer = server
+	}
+	if err := p.clients.SetPrimaryServer(mockserver.DemoProfileName); err != nil {
+		dialog.ShowError(err, p.window)
+		return
+	}
+	p.connectBtn.SetText("连接中...")
+	p.connectBtn.Disable()
+	go func() {
+		if p.client.IsConnected() {
+			p.client.Disconnect()
+		}
+		err := p.client.ConnectWithOptions(p.demoServer.ConnectOptions())
+		p.connectBtn.Enable()
+		if err != nil {
+			p.connectBtn.SetText("连接失败")
+			p.connectBtn.SetIcon(fyneTheme.CancelIcon())
+			p.connectBtn.Importance = widget.DangerImportance
+			dialog.ShowError(fmt.Errorf("连接演示服务端失败: %v", err), p.window)
+			return
+		}
+		p.statusLabel.SetText("演示模式：已连接到内置模拟服务端")
+		p.statusLabel.Importance = widget.WarningImportance
+		p.statusLabel.Refresh()
+	}()
+}
+func (p *SettingsPage) toggleRecording(button *widget.Button) {
+	if recorder := p.client.Recorder(); recorder != nil {
+		p.client.SetRecorder(nil)
+		count := recorder.Count()
+		if err := recorder.Close(); err != nil {
+			dialog.ShowError(fmt.Errorf("保存录制失败: %v", err), p.window)
+		} else {
+			dialog.ShowInformation("录制会话", fmt.Sprintf("已录制 %d 条消息，保存在:\n%s", count, p.recordingPath), p.window)
+		}
+		button.SetText("录制会话")
+		button.Importance = widget.MediumImportance
+		button.Refresh()
+		return
+	}
+	uri, err := config.GetRecordingURI(fmt.Sprintf("session-%s.jsonl", time.Now().Format("20060102-150405")))
+	if err != nil {
+		dialog.ShowError(fmt.Errorf("无法确定录制文件路径: %v", err), p.window)
+		return
+	}
+	recorder, err := network.NewRecorder(uri.Path())
+	if err != nil {
+		dialog.ShowError(err, p.window)
+		return
+	}
+	p.recordingPath = uri.Path()
+	p.client.SetRecorder(recorder)
+	p.logger.Info("开始录制会话: %s", p.recordingPath)
+	button.SetText("停止录制")
+	button.Importance = widget.DangerImportance
+	button.Refresh()
+}
+func (p *SettingsPage) showReplay() {
+	speeds := []struct {
+		Label string
+		Value float64
+	}{
+		{"原速", 1},
+		{"4 倍速", 4},
+		{"16 倍速", 16},
+		{"立即", 0},
+	}
+	labels := make([]string, 0, len(speeds))
+	for _, s := range speeds {
+		labels = append(labels, s.Label)
+	}
+	speedSelect := widget.NewSelect(labels, nil)
+	speedSelect.SetSelected(labels[0])
+	open := dialog.NewFileOpen(func(reader fyne.URIReadCloser, err error) {
+		if err != nil {
+			dialog.ShowError(err, p.window)
+			return
+		}
+		if reader == nil {
+			return
+		}
+		entries, err := network.ReadRecording(reader)
+		reader.Close()
+		if err != nil {
+			dialog.ShowError(fmt.Errorf("读取录制文件失败: %v", err), p.window)
+			return
+		}
+		speed := speeds[0].Value
+		for _, s := range speeds {
+			if s.Label == speedSelect.Selected {
+				speed = s.Value
+			}
+		}
+		p.statusLabel.SetText(fmt.Sprintf("正在回放 %d 条录制消息...", len(entries)))
+		go func() {
+			replayed, err := p.client.Replay(context.Background(), entries, speed)
+			if err != nil {
+				p.statusLabel.SetText(fmt.Sprintf("回放中断: %v", err))
+				return
+			}
+			p.statusLabel.SetText(fmt.Sprintf("回放完成，共 %d 条消息", replayed))
+		}()
+	}, p.window)
+	open.SetFilter(storage.NewExtensionFileFilter([]string{".jsonl"}))
+	dialog.ShowCustomConfirm("回放录制", "选择文件", "取消", container.NewVBox(
+		widget.NewLabel("录制中的入站消息将按原始节奏重新分发给各页面，回放内容不会写入数据库"),
+		container.NewGridWithColumns(2, widget.NewLabel("回放速度:"), speedSelect),
+	), func(ok bool) {
+		if ok {
+			open.Show()
+		}
+	}, p.window)
+}
+func (p *SettingsPage) showRequestStats() {
+	stats := p.clients.RequestStats()
+	rows := container.NewVBox(
+		widget.NewLabelWithStyle(fmt.Sprintf("进行中的请求: %d", p.clients.InFlight()), fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
+		widget.NewSeparator(),
+	)
+	if info := p.client.ServerInfo(); info != nil {
+		protocolText := fmt.Sprintf("服务端协议: %s", info.Version)
+		if info.Legacy {
+			protocolText += " (旧版)"
+		}
+		rows.Add(widget.NewLabel(protocolText))
+		rows.Add(widget.NewLabel(fmt.Sprintf("支持功能: %s", strings.Join(info.Capabilities, ", "))))
+		transport := p.client.Transport()
+		compression := "关闭"
+		if transport.Compression {
+			compression = "permessage-deflate"
+		}
+		rows.Add(widget.NewLabel(fmt.Sprintf("传输编码: %s · 压缩: %s", encodingLabel(string(transport.Encoding)), compression)))
+		rows.Add(widget.NewSeparator())
+	}
+	if dispatch := p.clients.DispatchStats(); len(dispatch) > 0 {
+		rows.Add(widget.NewLabelWithStyle("事件分发", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}))
+		for _, d := range dispatch {
+			rows.Add(widget.NewLabel(fmt.Sprintf("%s (%s): %s", d.Name, d.Policy, strings.Join(d.MsgTypes, ", "))))
+			rows.Add(widget.NewLabel(fmt.Sprintf("队列 %d · 峰值 %d · 已处理 %d · 丢弃 %d · 合并 %d",
+				d.Depth, d.MaxDepth, d.Delivered, d.Dropped, d.Coalesced)))
+		}
+		rows.Add(widget.NewSeparator())
+	}
+	if len(stats) == 0 {
+		rows.Add(widget.NewLabel("暂无请求记录"))
+	}
+	for _, s := range stats {
+		rows.Add(widget.NewLabelWithStyle(s.Method, fyne.TextAlignLeading, fyne.TextStyle{Bold: true}))
+		rows.Add(widget.NewLabel(fmt.Sprintf("完成 %d · 失败 %d · 超时 %d · 进行中 %d",
+			s.Completed, s.Errors, s.Timeouts, s.InFlight)))
+		rows.Add(widget.NewLabel(fmt.Sprintf("平均 %s · 最大 %s · 最近 %s",
+			formatLatency(s.AvgLatency), formatLatency(s.MaxLatency), formatLatency(s.LastLatency))))
+	}
+	scroll := container.NewVScroll(rows)
+	scroll.SetMinSize(fyne.NewSize(320, 360))
+	dialog.ShowCustom("请求统计", "关闭", scroll, p.window)
+}
+func (p *SettingsPage) showOutbox() {
+	outbox := p.clients.Outbox()
+	if outbox == nil {
+		dialog.ShowInformation("离线队列", "存储不可用，离线队列未启用", p.window)
+		return
+	}
+	rows := container.NewVBox()
+	var render func()
+	act := func(action func() error) {
+		if err := action(); err != nil {
+			dialog.ShowError(err, p.window)
+		}
+		render()
+	}
+	render = func() {
+		rows.Objects = nil
+		items, err := outbox.Items()
+		if err != nil {
+			rows.Add(widget.NewLabel(fmt.Sprintf("读取离线队列失败: %v", err)))
+		} else if len(items) == 0 {
+			rows.Add(widget.NewLabel("队列为空"))
+		}
+		blocked := make(map[string]bool)
+		for _, item := range items {
+			item := item
+			server := item.Server
+			if server == "" {
+				server = data.DefaultProfileName
+			}
+			title := widget.NewLabelWithStyle(item.Summary, fyne.TextAlignLeading, fyne.TextStyle{Bold: true})
+			detail := widget.NewLabel(fmt.Sprintf("%s · %s · %s", server, item.Method, item.CreatedAt.Format("01-02 15:04:05")))
+			rows.Add(title)
+			rows.Add(detail)
+			switch item.Status {
+			case data.OutboxConflict:
+				status := widget.NewLabel(fmt.Sprintf("冲突: %s", item.LastError))
+				status.Importance = widget.WarningImportance
+				status.Wrapping = fyne.TextWrapWord
+				rows.Add(status)
+			case data.OutboxFailed:
+				status := widget.NewLabel(fmt.Sprintf("失败: %s", item.LastError))
+				status.Importance = widget.DangerImportance
+				status.Wrapping = fyne.TextWrapWord
+				rows.Add(status)
+			default:
+				if blocked[item.Server] {
+					status := widget.NewLabel("等待前面的请求重试或丢弃后再发送")
+					status.Importance = widget.LowImportance
+					rows.Add(status)
+				}
+			}
+			if item.Status != data.OutboxPending {
+				blocked[item.Server] = true
+			}
+			upBtn := widget.NewButtonWithIcon("", fyneTheme.MoveUpIcon(), func() {
+				act(func() error { return outbox.Move(item, -1) })
+			})
+			downBtn := widget.NewButtonWithIcon("", fyneTheme.MoveDownIcon(), func() {
+				act(func() error { return outbox.Move(item, 1) })
+			})
+			retryBtn := widget.NewButtonWithIcon("", fyneTheme.ViewRefreshIcon(), func() {
+				if item.Status != data.OutboxConflict {
+					act(func() error { return outbox.Retry(item) })
+					return
+				}
+				dialog.ShowConfirm("仍然发送", fmt.Sprintf("服务端状态已在排队期间变化，仍然发送「%s」将覆盖当前状态，确定吗？", item.Summary), func(confirmed bool) {
+					if confirmed {
+						act(func() error { return outbox.Retry(item) })
+					}
+				}, p.window)
+			})
+			if item.Status == data.OutboxPending {
+				retryBtn.Disable()
+			}
+			discardBtn := widget.NewButtonWithIcon("", fyneTheme.DeleteIcon(), func() {
+				dialog.ShowConfirm("丢弃请求", fmt.Sprintf("确定要丢弃「%s」吗？", item.Summary), func(confirmed bool) {
+					if confirmed {
+						act(func() error { return outbox.Discard(item) })
+					}
+				}, p.window)
+			})
+			discardBtn.Importance = widget.DangerImportance
+			rows.Add(container.NewGridWithColumns(4, upBtn, downBtn, retryBtn, discardBtn))
+			rows.Add(widget.NewSeparator())
+		}
+		rows.Refresh()
+	}
+	render()
+	scroll := container.NewVScroll(rows)
+	scroll.SetMinSize(fyne.NewSize(320, 360))
+	dialog.ShowCustom("离线队列", "关闭", scroll, p.window)
+}
+func formatLatency(d time.Duration) string {
+	if d <= 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%dms", d.Milliseconds())
+}
 func (p *SettingsPage) resetToDefaults() {
 	dialog.ShowConfirm(
 		"重置设置",
@@ -244,7 +937,19 @@ func (p *SettingsPage) resetToDefaults() {
 				p.tokenEntry.SetText("疯狂星期四V我50")
 				p.autoConnectCheck.SetChecked(false)
 				p.rememberAuthCheck.SetChecked(false)
+				p.authModeSelect.SetSelected(authModes[0].Label)
+				p.tlsCheck.SetChecked(false)
+				p.caBundleEntry.SetText("")
+				p.pinEntry.SetText("")
+				p.selfSignedCheck.SetChecked(false)
+				p.compressionCheck.SetChecked(false)
+				p.encodingSelect.SetSelected(encodings[0].Label)
+				p.profileNameEntry.SetText(data.DefaultProfileName)
 				p.autoScrollCheck.SetChecked(true)
+				p.maxAgeEntry.SetText("")
+				p.maxMessagesEntry.SetText("")
+				p.maxSizeEntry.SetText("")
+				p.retentionRules = nil
 				p.statusLabel.SetText("已重置为默认设置")
 				p.statusLabel.Importance = widget.MediumImportance
 			}
@@ -252,6 +957,200 @@ func (p *SettingsPage) resetToDefaults() {
 		p.window,
 	)
 }
+func (p *SettingsPage) SetPruner(pruner *data.Pruner) {
+	p.pruner = pruner
+}
+func (p *SettingsPage) refreshDatabaseSize() {
+	if p.storage == nil {
+		return
+	}
+	go func() {
+		size, err := p.storage.DatabaseSize()
+		if err != nil {
+			p.dbSizeLabel.SetText(fmt.Sprintf("数据库大小: 读取失败 (%v)", err))
+			return
+		}
+		p.dbSizeLabel.SetText(fmt.Sprintf("数据库大小: %s", formatBytes(size)))
+	}()
+}
+func formatBytes(size int64) string {
+	switch {
+	case size >= 1<<30:
+		return fmt.Sprintf("%.2f GB", float64(size)/(1<<30))
+	case size >= 1<<20:
+		return fmt.Sprintf("%.2f MB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(size)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", size)
+}
+func (p *SettingsPage) loadRetention() {
+	retention := p.config.Retention
+	p.maxAgeEntry.SetText(optionalInt(retention.MaxAgeDays))
+	p.maxMessagesEntry.SetText(optionalInt(retention.MaxMessages))
+	p.maxSizeEntry.SetText(optionalInt(retention.MaxSizeMB))
+	p.retentionRules = append([]config.RetentionRuleConfig(nil), retention.Rules...)
+	p.refreshDatabaseSize()
+}
+func optionalInt(value int) string {
+	if value <= 0 {
+		return ""
+	}
+	return strconv.Itoa(value)
+}
+func parseOptionalInt(label, text string) (int, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return 0, nil
+	}
+	value, err := strconv.Atoi(text)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("%s必须是非负整数", label)
+	}
+	return value, nil
+}
+func (p *SettingsPage) retentionFromForm() (config.RetentionConfig, error) {
+	var retention config.RetentionConfig
+	var err error
+	if retention.MaxAgeDays, err = parseOptionalInt("保留天数", p.maxAgeEntry.Text); err != nil {
+		return retention, err
+	}
+	if retention.MaxMessages, err = parseOptionalInt("最多消息数", p.maxMessagesEntry.Text); err != nil {
+		return retention, err
+	}
+	if retention.MaxSizeMB, err = parseOptionalInt("大小上限", p.maxSizeEntry.Text); err != nil {
+		return retention, err
+	}
+	retention.Rules = append([]config.RetentionRuleConfig(nil), p.retentionRules...)
+	return retention, nil
+}
+func (p *SettingsPage) showRetentionRules() {
+	list := container.NewVBox()
+	var render func()
+	render = func() {
+		list.Objects = nil
+		if len(p.retentionRules) == 0 {
+			list.Add(widget.NewLabel("暂无分组规则，所有消息使用全局策略"))
+		}
+		for i, rule := range p.retentionRules {
+			index := i
+			scope := []string{}
+			if rule.Bot != "" {
+				scope = append(scope, "Bot "+rule.Bot)
+			}
+			if rule.Group != "" {
+				scope = append(scope, "群 "+rule.Group)
+			}
+			limits := []string{}
+			if rule.MaxAgeDays > 0 {
+				limits = append(limits, fmt.Sprintf("%d 天", rule.MaxAgeDays))
+			}
+			if rule.MaxMessages > 0 {
+				limits = append(limits, fmt.Sprintf("%d 条", rule.MaxMessages))
+			}
+			if len(limits) == 0 {
+				limits = append(limits, "不限制")
+			}
+			removeBtn := widget.NewButtonWithIcon("", fyneTheme.DeleteIcon(), func() {
+				p.retentionRules = append(p.retentionRules[:index:index], p.retentionRules[index+1:]...)
+				render()
+			})
+			list.Add(container.NewBorder(nil, nil, nil, removeBtn,
+				widget.NewLabel(fmt.Sprintf("%s: %s", strings.Join(scope, " / "), strings.Join(limits, "，")))))
+		}
+		list.Refresh()
+	}
+	render()
+	botEntry := widget.NewEntry()
+	botEntry.SetPlaceHolder("Bot ID")
+	groupEntry := widget.NewEntry()
+	groupEntry.SetPlaceHolder("群号")
+	ageEntry := widget.NewEntry()
+	ageEntry.SetPlaceHolder("不限制")
+	countEntry := widget.NewEntry()
+	countEntry.SetPlaceHolder("不限制")
+	addBtn := widget.NewButtonWithIcon("添加规则", fyneTheme.ContentAddIcon(), func() {
+		rule := config.RetentionRuleConfig{Bot: strings.TrimSpace(botEntry.Text), Group: strings.TrimSpace(groupEntry.Text)}
+		if rule.Bot == "" && rule.Group == "" {
+			dialog.ShowError(fmt.Errorf("请填写 Bot ID 或群号"), p.window)
+			return
+		}
+		var err error
+		if rule.MaxAgeDays, err = parseOptionalInt("保留天数", ageEntry.Text); err != nil {
+			dialog.ShowError(err, p.window)
+			return
+		}
+		if rule.MaxMessages, err = parseOptionalInt("最多消息数", countEntry.Text); err != nil {
+			dialog.ShowError(err, p.window)
+			return
+		}
+		p.retentionRules = append(p.retentionRules, rule)
+		botEntry.SetText("")
+		groupEntry.SetText("")
+		ageEntry.SetText("")
+		countEntry.SetText("")
+		render()
+	})
+	form := widget.NewForm(
+		widget.NewFormItem("Bot", botEntry),
+		widget.NewFormItem("群组", groupEntry),
+		widget.NewFormItem("保留天数", ageEntry),
+		widget.NewFormItem("最多消息数", countEntry),
+	)
+	content := container.NewBorder(nil, container.NewVBox(widget.NewSeparator(), form, addBtn), nil, nil,
+		container.NewVScroll(list))
+	rulesDialog := dialog.NewCustom("分组保留规则", "完成", content, p.window)
+	rulesDialog.SetOnClosed(func() {
+		p.statusLabel.SetText("分组规则已修改，保存设置后生效")
+		p.statusLabel.Importance = widget.MediumImportance
+	})
+	rulesDialog.Resize(fyne.NewSize(360, 480))
+	rulesDialog.Show()
+}
+func (p *SettingsPage) pruneNow() {
+	if p.pruner == nil {
+		dialog.ShowInformation("立即清理", "本地数据库不可用", p.window)
+		return
+	}
+	if p.pruner.Policy().IsZero() {
+		dialog.ShowInformation("立即清理", "未设置任何保留限制，请先保存保留策略", p.window)
+		return
+	}
+	p.statusLabel.SetText("正在清理过期数据...")
+	p.statusLabel.Importance = widget.MediumImportance
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+		result, err := p.pruner.Run(ctx)
+		if err != nil {
+			p.statusLabel.SetText(fmt.Sprintf("清理失败: %v", err))
+			p.statusLabel.Importance = widget.DangerImportance
+			return
+		}
+		p.statusLabel.SetText(fmt.Sprintf("已清理 %d 条消息、%d 条调用记录，释放 %s",
+			result.Messages, result.PluginCalls, formatBytes(result.Reclaimed)))
+		p.statusLabel.Importance = widget.SuccessImportance
+		p.dbSizeLabel.SetText(fmt.Sprintf("数据库大小: %s", formatBytes(result.Size)))
+	}()
+}
+func (p *SettingsPage) rebuildSearchIndex() {
+	if p.storage == nil {
+		dialog.ShowInformation("重建搜索索引", "本地数据库不可用", p.window)
+		return
+	}
+	p.statusLabel.SetText("正在重建搜索索引...")
+	p.statusLabel.Importance = widget.MediumImportance
+	go func() {
+		indexed, err := p.storage.RebuildSearchIndex()
+		if err != nil {
+			p.statusLabel.SetText(fmt.Sprintf("重建索引失败: %v", err))
+			p.statusLabel.Importance = widget.DangerImportance
+			return
+		}
+		p.statusLabel.SetText(fmt.Sprintf("已重建 %d 条消息的搜索索引", indexed))
+		p.statusLabel.Importance = widget.SuccessImportance
+	}()
+}
 func (p *SettingsPage) confirmCleanDatabase() {
 	dialog.ShowConfirm(
 		"清理数据",
@@ -276,6 +1175,7 @@ func (p *SettingsPage) cleanDatabase() {
 		}
 		p.statusLabel.SetText("数据清理成功")
 		p.statusLabel.Importance = widget.SuccessImportance
+		p.refreshDatabaseSize()
 		dialog.ShowInformation("清理完成", "所有数据已成功清理，包括消息记录、插件调用记录和连接配置。", p.window)
 	}()
 }